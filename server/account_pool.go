@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accountAuthCooldown      = 10 * time.Minute
+	accountRateLimitCooldown = 5 * time.Minute
+	accountUsageStaleAfter   = time.Minute
+	accountPinIdleTimeout    = time.Hour
+)
+
+type AccountStatus struct {
+	Name                    string     `json:"name"`
+	OrganizationID          string     `json:"organization_id"`
+	Available               bool       `json:"available"`
+	State                   string     `json:"state"`
+	Reason                  string     `json:"reason"`
+	FiveHourUtilization     int        `json:"five_hour_utilization"`
+	FiveHourResetsAt        any        `json:"five_hour_resets_at"`
+	SevenDayUtilization     int        `json:"seven_day_utilization"`
+	SevenDayResetsAt        any        `json:"seven_day_resets_at"`
+	SevenDayOpusUtilization int        `json:"seven_day_opus_utilization"`
+	DisabledUntil           *time.Time `json:"disabled_until"`
+	LastStatusCode          int        `json:"last_status_code"`
+	LastError               string     `json:"last_error"`
+	UsageUpdatedAt          *time.Time `json:"usage_updated_at"`
+	Conversations           int        `json:"conversations"`
+}
+
+type Account struct {
+	Name           string
+	config         AccountConfig
+	client         *ClaudeWebClient
+	mu             sync.RWMutex
+	refreshMu      sync.Mutex
+	usage          map[string]any
+	usageUpdatedAt time.Time
+	disabledUntil  time.Time
+	disabledReason string
+	lastStatusCode int
+	lastError      string
+	mcpTools       MCPToolsCache
+	remoteMCPTools RemoteMCPToolsCache
+}
+
+type accountPin struct {
+	account  *Account
+	lastUsed time.Time
+}
+
+type AccountPool struct {
+	accounts  []*Account
+	mu        sync.RWMutex
+	pins      map[string]*accountPin
+	lastSweep time.Time
+}
+
+var accountPool *AccountPool
+
+func InitAccountPool(config *Config) {
+	accountPool = &AccountPool{
+		pins: make(map[string]*accountPin),
+	}
+	for _, cfg := range config.GetAccounts() {
+		accountPool.accounts = append(accountPool.accounts, &Account{
+			Name:   cfg.Name,
+			config: cfg,
+			client: NewClaudeWebClient(config, cfg),
+			usage:  emptyUsage(),
+		})
+	}
+	log.Printf("✓ 账号池已初始化: %d 个账号", len(accountPool.accounts))
+}
+
+func (a *Account) utilization() (int, int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	fiveHour, _ := a.usage["five_hour_utilization"].(int)
+	sevenDay, _ := a.usage["seven_day_utilization"].(int)
+	return fiveHour, sevenDay
+}
+
+func (a *Account) unavailableReason(now time.Time) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if now.Before(a.disabledUntil) {
+		return a.disabledReason
+	}
+	if blocked, _ := a.usage["is_blocked"].(bool); blocked {
+		reason, _ := a.usage["block_reason"].(string)
+		return reason
+	}
+	return ""
+}
+
+func (a *Account) observe(err error) {
+	if err == nil {
+		return
+	}
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastStatusCode = upstreamErr.StatusCode
+	a.lastError = truncateString(upstreamErr.Body, 200)
+	switch upstreamErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		a.disabledUntil = time.Now().Add(accountAuthCooldown)
+		a.disabledReason = fmt.Sprintf("认证失败 (%d)", upstreamErr.StatusCode)
+		log.Printf("⚠️ 账号 %s 认证失败 (%d)，暂停使用至 %s", a.Name, upstreamErr.StatusCode, a.disabledUntil.Format("15:04:05"))
+	case http.StatusTooManyRequests:
+		until := rateLimitResetTime(upstreamErr)
+		if until.After(a.disabledUntil) {
+			a.disabledUntil = until
+		}
+		a.disabledReason = "请求受限 (429)"
+		log.Printf("⚠️ 账号 %s 被限流，暂停使用至 %s", a.Name, a.disabledUntil.Format("15:04:05"))
+	}
+}
+
+func rateLimitResetTime(upstreamErr *UpstreamError) time.Time {
+	if upstreamErr.RetryAfter > 0 {
+		return time.Now().Add(upstreamErr.RetryAfter)
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(upstreamErr.Body), &body) == nil {
+		var limit struct {
+			ResetsAt int64 `json:"resetsAt"`
+		}
+		if json.Unmarshal([]byte(body.Error.Message), &limit) == nil && limit.ResetsAt > 0 {
+			return time.Unix(limit.ResetsAt, 0)
+		}
+	}
+	return time.Now().Add(accountRateLimitCooldown)
+}
+
+func (a *Account) refreshUsage(force bool) {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+	a.mu.RLock()
+	fresh := time.Since(a.usageUpdatedAt) < accountUsageStaleAfter
+	a.mu.RUnlock()
+	if fresh && !force {
+		return
+	}
+	usage, err := fetchUsage(a.config.Tokens.OrganizationID, a.config.GetCookie())
+	if err != nil {
+		log.Printf("获取账号 %s 使用量失败: %v", a.Name, err)
+		a.observe(err)
+		a.mu.Lock()
+		a.usageUpdatedAt = time.Now()
+		a.lastError = truncateString(err.Error(), 200)
+		a.mu.Unlock()
+		return
+	}
+	applyUsageLimits(usage)
+	a.mu.Lock()
+	a.usage = usage
+	a.usageUpdatedAt = time.Now()
+	a.lastStatusCode = http.StatusOK
+	a.lastError = ""
+	if strings.HasPrefix(a.disabledReason, "认证失败") {
+		a.disabledUntil = time.Time{}
+		a.disabledReason = ""
+		log.Printf("✓ 账号 %s 已恢复", a.Name)
+	}
+	a.mu.Unlock()
+	log.Printf("✓ 账号 %s 使用量已更新 - 5小时: %v%%, 7天: %v%%, Opus: %v%%",
+		a.Name, usage["five_hour_utilization"], usage["seven_day_utilization"], usage["seven_day_opus_utilization"])
+}
+
+func (a *Account) status(now time.Time, conversations int) AccountStatus {
+	reason := a.unavailableReason(now)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	status := AccountStatus{
+		Name:             a.Name,
+		OrganizationID:   a.config.Tokens.OrganizationID,
+		Available:        reason == "",
+		State:            "active",
+		Reason:           reason,
+		FiveHourResetsAt: a.usage["five_hour_resets_at"],
+		SevenDayResetsAt: a.usage["seven_day_resets_at"],
+		LastStatusCode:   a.lastStatusCode,
+		LastError:        a.lastError,
+		Conversations:    conversations,
+	}
+	status.FiveHourUtilization, _ = a.usage["five_hour_utilization"].(int)
+	status.SevenDayUtilization, _ = a.usage["seven_day_utilization"].(int)
+	status.SevenDayOpusUtilization, _ = a.usage["seven_day_opus_utilization"].(int)
+	if now.Before(a.disabledUntil) {
+		status.State = "cooldown"
+		disabledUntil := a.disabledUntil
+		status.DisabledUntil = &disabledUntil
+	} else if reason != "" {
+		status.State = "blocked"
+	}
+	if !a.usageUpdatedAt.IsZero() {
+		usageUpdatedAt := a.usageUpdatedAt
+		status.UsageUpdatedAt = &usageUpdatedAt
+	}
+	return status
+}
+
+func (p *AccountPool) pick(exclude map[*Account]bool) *Account {
+	now := time.Now()
+	var best *Account
+	bestFiveHour, bestSevenDay := 0, 0
+	for _, account := range p.accounts {
+		if exclude[account] || account.unavailableReason(now) != "" {
+			continue
+		}
+		fiveHour, sevenDay := account.utilization()
+		if best == nil || fiveHour < bestFiveHour || (fiveHour == bestFiveHour && sevenDay < bestSevenDay) {
+			best = account
+			bestFiveHour, bestSevenDay = fiveHour, sevenDay
+		}
+	}
+	return best
+}
+
+func (p *AccountPool) byName(name string) *Account {
+	for _, account := range p.accounts {
+		if account.Name == name {
+			return account
+		}
+	}
+	return nil
+}
+
+func (p *AccountPool) byOrganization(orgID string) *Account {
+	for _, account := range p.accounts {
+		if account.config.Tokens.OrganizationID == orgID {
+			return account
+		}
+	}
+	return nil
+}
+
+func (p *AccountPool) forOrganization(orgID string) *Account {
+	if account := p.byOrganization(orgID); account != nil {
+		return account
+	}
+	return p.pick(nil)
+}
+
+func (p *AccountPool) pin(conversationID string, account *Account) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pins[conversationID] = &accountPin{account: account, lastUsed: now}
+	if now.Sub(p.lastSweep) < time.Minute {
+		return
+	}
+	p.lastSweep = now
+	for id, pin := range p.pins {
+		if now.Sub(pin.lastUsed) > accountPinIdleTimeout {
+			delete(p.pins, id)
+		}
+	}
+}
+
+func (p *AccountPool) accountFor(conversationID string) *Account {
+	p.mu.RLock()
+	pin, ok := p.pins[conversationID]
+	p.mu.RUnlock()
+	if ok {
+		p.pin(conversationID, pin.account)
+		return pin.account
+	}
+	var account *Account
+	if db != nil {
+		if conv, err := db.GetConversationByUID(conversationID); err == nil {
+			account = p.byName(conv.Account)
+		}
+	}
+	if account == nil {
+		account = p.pick(nil)
+	}
+	if account == nil {
+		return p.accounts[0]
+	}
+	p.pin(conversationID, account)
+	return account
+}
+
+func (p *AccountPool) AccountName(conversationID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if pin, ok := p.pins[conversationID]; ok {
+		return pin.account.Name
+	}
+	return ""
+}
+
+func isAccountError(err error) bool {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return false
+	}
+	switch upstreamErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+func (p *AccountPool) CreateConversation(ctx context.Context, incognito bool) (string, error) {
+	tried := make(map[*Account]bool)
+	var lastErr error
+	for {
+		account := p.pick(tried)
+		if account == nil {
+			if lastErr != nil {
+				return "", fmt.Errorf("no available account: %v", lastErr)
+			}
+			return "", fmt.Errorf("no available account")
+		}
+		tried[account] = true
+		conversationID, err := account.client.CreateConversation(ctx, incognito)
+		if err != nil {
+			account.observe(err)
+			if isAccountError(err) {
+				log.Printf("账号 %s 创建对话失败，切换账号: %v", account.Name, err)
+				lastErr = err
+				continue
+			}
+			return "", err
+		}
+		p.pin(conversationID, account)
+		DebugLog("Conversation %s pinned to account %s", conversationID, account.Name)
+		return conversationID, nil
+	}
+}
+
+func (p *AccountPool) UploadFile(ctx context.Context, conversationID string, file *RequestFile) (*UploadResponse, error) {
+	account := p.accountFor(conversationID)
+	resp, err := account.client.UploadFile(ctx, conversationID, file)
+	account.observe(err)
+	return resp, err
+}
+
+func (p *AccountPool) GetLastMessageUUID(ctx context.Context, conversationID string) (string, error) {
+	account := p.accountFor(conversationID)
+	uuid, err := account.client.GetLastMessageUUID(ctx, conversationID)
+	account.observe(err)
+	return uuid, err
+}
+
+func (p *AccountPool) GetConversation(ctx context.Context, conversationID string) (*UpstreamConversation, error) {
+	account := p.accountFor(conversationID)
+	conversation, err := account.client.GetConversation(ctx, conversationID)
+	account.observe(err)
+	return conversation, err
+}
+
+func (p *AccountPool) Complete(ctx context.Context, cr CompletionRequest) (<-chan StreamEvent, error) {
+	account := p.accountFor(cr.ConversationID)
+	events, err := account.client.Complete(ctx, cr)
+	account.observe(err)
+	return events, err
+}
+
+func (p *AccountPool) StopResponse(ctx context.Context, conversationID string) error {
+	return p.accountFor(conversationID).client.StopResponse(ctx, conversationID)
+}
+
+func (p *AccountPool) RefreshUsage() {
+	for _, account := range p.accounts {
+		account.refreshUsage(true)
+	}
+}
+
+func (p *AccountPool) Statuses() []AccountStatus {
+	p.mu.RLock()
+	counts := make(map[*Account]int)
+	for _, pin := range p.pins {
+		counts[pin.account]++
+	}
+	p.mu.RUnlock()
+	now := time.Now()
+	statuses := make([]AccountStatus, 0, len(p.accounts))
+	for _, account := range p.accounts {
+		statuses = append(statuses, account.status(now, counts[account]))
+	}
+	return statuses
+}
+
+func (p *AccountPool) Usage() map[string]any {
+	for _, account := range p.accounts {
+		account.refreshUsage(false)
+	}
+	statuses := p.Statuses()
+	account := p.pick(nil)
+	result := emptyUsage()
+	if account != nil {
+		account.mu.RLock()
+		for k, v := range account.usage {
+			result[k] = v
+		}
+		account.mu.RUnlock()
+		result["account"] = account.Name
+		result["accounts"] = statuses
+		return result
+	}
+	reasons := make([]string, 0, len(statuses))
+	var resetTime string
+	var least *AccountStatus
+	for i := range statuses {
+		status := &statuses[i]
+		reasons = append(reasons, fmt.Sprintf("%s: %s", status.Name, status.Reason))
+		recovery := ""
+		if status.DisabledUntil != nil {
+			recovery = status.DisabledUntil.UTC().Format(time.RFC3339)
+		} else if resetAt, ok := status.FiveHourResetsAt.(string); ok {
+			recovery = resetAt
+		}
+		if recovery != "" && (resetTime == "" || recovery < resetTime) {
+			resetTime = recovery
+		}
+		if least == nil || status.FiveHourUtilization < least.FiveHourUtilization {
+			least = status
+		}
+	}
+	if least != nil {
+		result["five_hour_utilization"] = least.FiveHourUtilization
+		result["five_hour_resets_at"] = least.FiveHourResetsAt
+		result["seven_day_utilization"] = least.SevenDayUtilization
+		result["seven_day_resets_at"] = least.SevenDayResetsAt
+		result["seven_day_opus_utilization"] = least.SevenDayOpusUtilization
+	}
+	result["is_blocked"] = true
+	result["block_reason"] = "所有账号均不可用\n" + strings.Join(reasons, "\n")
+	result["block_reset_time"] = resetTime
+	result["accounts"] = statuses
+	return result
+}
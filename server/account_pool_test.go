@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountPoolEvictsIdlePins(t *testing.T) {
+	account := &Account{Name: "test"}
+	pool := &AccountPool{accounts: []*Account{account}, pins: make(map[string]*accountPin)}
+	pool.pins["idle"] = &accountPin{account: account, lastUsed: time.Now().Add(-accountPinIdleTimeout - time.Minute)}
+	pool.pins["recent"] = &accountPin{account: account, lastUsed: time.Now().Add(-time.Minute)}
+	pool.pin("new", account)
+	if _, ok := pool.pins["idle"]; ok {
+		t.Fatalf("idle pin was not evicted")
+	}
+	for _, id := range []string{"recent", "new"} {
+		if got := pool.AccountName(id); got != "test" {
+			t.Fatalf("AccountName(%q) = %q, want test", id, got)
+		}
+	}
+}
+
+func TestAccountForUnknownConversationSkipsUnavailableAccounts(t *testing.T) {
+	limited := &Account{Name: "limited", usage: emptyUsage(), disabledUntil: time.Now().Add(time.Minute), disabledReason: "请求受限 (429)"}
+	healthy := &Account{Name: "healthy", usage: emptyUsage()}
+	pool := &AccountPool{accounts: []*Account{limited, healthy}, pins: make(map[string]*accountPin)}
+	if got := pool.accountFor("unknown-conversation"); got != healthy {
+		t.Fatalf("accountFor picked %s, want healthy", got.Name)
+	}
+	if got := pool.AccountName("unknown-conversation"); got != "healthy" {
+		t.Fatalf("conversation pinned to %q, want healthy", got)
+	}
+	healthy.disabledUntil, healthy.disabledReason = limited.disabledUntil, limited.disabledReason
+	if got := pool.accountFor("another-conversation"); got != limited || pool.AccountName("another-conversation") != "" {
+		t.Fatalf("accountFor picked %s with every account unavailable, want the first account without a pin", got.Name)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	adminSessionCookie  = "admin_session"
+	adminPasswordMinLen = 8
+	adminLoginPage      = "/static/login/login.html"
+)
+
+var adminUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+var adminPagePrefixes = []string{
+	"/static/dashboard/",
+	"/static/dialogues/",
+	"/static/history/",
+	"/static/processing/",
+	"/static/requests/",
+}
+
+var (
+	adminSessionSecret []byte
+	adminDummyHash, _  = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+)
+
+type AdminSession struct {
+	AdminID   int    `json:"aid"`
+	Username  string `json:"usr"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type AdminLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func InitAdminSessions(cfg *Config) {
+	if cfg.AdminSessionSecret != "" {
+		adminSessionSecret = []byte(cfg.AdminSessionSecret)
+		return
+	}
+	adminSessionSecret = make([]byte, 32)
+	rand.Read(adminSessionSecret)
+	log.Println("⚠ 未配置 admin_session_secret，已随机生成会话密钥，重启后需重新登录")
+}
+
+func hashAdminPassword(password string) (string, error) {
+	if len(password) < adminPasswordMinLen {
+		return "", fmt.Errorf("password must be at least %d characters", adminPasswordMinLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func migrateLegacyAdminPasswords(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&CldDevice{}, "admin_password") {
+		return nil
+	}
+	var devices []struct {
+		ID            int
+		Fingerprint   string
+		AdminPassword string
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("cld_device").
+			Select("id, fingerprint, admin_password").
+			Where("admin = ? AND admin_password IS NOT NULL AND admin_password <> ''", true).
+			Order("id ASC").
+			Scan(&devices).Error; err != nil {
+			return err
+		}
+		for _, device := range devices {
+			username := device.Fingerprint
+			if !adminUsernamePattern.MatchString(username) {
+				username = fmt.Sprintf("device-%d", device.ID)
+			}
+			var existing int64
+			if err := tx.Model(&CldAdmin{}).Where("LOWER(username) = LOWER(?)", username).Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing > 0 {
+				log.Printf("⚠ 管理员账号 %s 已存在，跳过设备 %d 的旧管理员密码", username, device.ID)
+				continue
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(device.AdminPassword), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&CldAdmin{Username: username, PasswordHash: string(hash), CreateTime: time.Now()}).Error; err != nil {
+				return err
+			}
+			log.Printf("✓ 已将设备 %d 的旧管理员密码迁移为管理员账号 %s", device.ID, username)
+		}
+		if err := tx.Exec(`ALTER TABLE cld_device DROP COLUMN admin_password`).Error; err != nil {
+			return err
+		}
+		log.Printf("✓ 旧管理员密码迁移完成 (%d 个设备)，已移除 cld_device.admin_password", len(devices))
+		return nil
+	})
+}
+
+func createAdmin(db *Database, username, password string) (*CldAdmin, error) {
+	if !adminUsernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("username must be 1-64 characters of letters, digits, '_', '.' or '-'")
+	}
+	hash, err := hashAdminPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.GetAdminByUsername(username); err == nil {
+		return nil, fmt.Errorf("admin %s already exists", username)
+	}
+	admin := &CldAdmin{Username: username, PasswordHash: hash}
+	if err := db.CreateAdmin(admin); err != nil {
+		return nil, err
+	}
+	return admin, nil
+}
+
+func authenticateAdmin(db *Database, username, password string) (*CldAdmin, error) {
+	admin, err := db.GetAdminByUsername(username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(adminDummyHash, []byte(password))
+		return nil, fmt.Errorf("invalid username or password")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password)) != nil {
+		return nil, fmt.Errorf("invalid username or password")
+	}
+	return admin, nil
+}
+
+func signAdminSessionPayload(payload string) string {
+	mac := hmac.New(sha256.New, adminSessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signAdminSession(session AdminSession) string {
+	data, _ := json.Marshal(session)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signAdminSessionPayload(payload)
+}
+
+func parseAdminSession(token string) (*AdminSession, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signAdminSessionPayload(payload))) {
+		return nil, errors.New("invalid session token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("invalid session token")
+	}
+	var session AdminSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, errors.New("invalid session token")
+	}
+	if time.Now().Unix() >= session.ExpiresAt {
+		return nil, errors.New("session expired")
+	}
+	return &session, nil
+}
+
+func requestAdminSession(c *gin.Context, db *Database) *AdminSession {
+	token, err := c.Cookie(adminSessionCookie)
+	if err != nil || token == "" {
+		token, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if token == "" || strings.HasPrefix(token, apiKeyPrefix) {
+		return nil
+	}
+	session, err := parseAdminSession(strings.TrimSpace(token))
+	if err != nil {
+		return nil
+	}
+	if _, err := db.GetAdminByID(session.AdminID); err != nil {
+		return nil
+	}
+	return session
+}
+
+func isAdminPage(path string) bool {
+	for _, prefix := range adminPagePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func AdminPageMiddleware(db *Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !isAdminPage(path) || requestAdminSession(c, db) != nil {
+			c.Next()
+			return
+		}
+		if !strings.HasSuffix(path, ".html") && !strings.HasSuffix(path, "/") {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Redirect(http.StatusFound, adminLoginPage+"?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	}
+}
+
+func (h *Handler) AdminLogin(c *gin.Context) {
+	var req AdminLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	admin, err := authenticateAdmin(h.db, strings.TrimSpace(req.Username), req.Password)
+	if err != nil {
+		log.Printf("⚠ 管理员登录失败: %s (%s)", req.Username, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+	lifetime := time.Duration(h.config.AdminSessionHours) * time.Hour
+	expiresAt := time.Now().Add(lifetime)
+	token := signAdminSession(AdminSession{
+		AdminID:   admin.ID,
+		Username:  admin.Username,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err := h.db.TouchAdminLogin(admin.ID); err != nil {
+		log.Printf("Failed to update admin login time: %v", err)
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(adminSessionCookie, token, int(lifetime.Seconds()), "/", "", false, true)
+	log.Printf("✓ 管理员已登录: %s", admin.Username)
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"username":   admin.Username,
+		"expires_at": expiresAt,
+	})
+}
+
+func (h *Handler) AdminLogout(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(adminSessionCookie, "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (h *Handler) ListAdmins(c *gin.Context) {
+	admins, err := h.db.GetAdmins()
+	if err != nil {
+		log.Printf("Failed to load admins: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load admins"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"admins": admins})
+}
+
+func (h *Handler) CreateAdmin(c *gin.Context) {
+	var req AdminLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	admin, err := createAdmin(h.db, strings.TrimSpace(req.Username), req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("✓ 已创建管理员: %s", admin.Username)
+	c.JSON(http.StatusOK, admin)
+}
+
+func (h *Handler) DeleteAdmin(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin ID"})
+		return
+	}
+	if session := requestAPIAccess(c).Admin; session != nil && session.AdminID == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete the admin you are logged in as"})
+		return
+	}
+	deleted, err := h.db.DeleteAdmin(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !deleted) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin not found"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to delete admin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete admin"})
+		return
+	}
+	log.Printf("✓ 已删除管理员: %d", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Admin deleted successfully"})
+}
+
+func CreateAdminCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: claude-adapter -a <用户名> [密码]")
+	}
+	password := ""
+	if len(args) > 1 {
+		password = args[1]
+	} else {
+		fmt.Printf("请输入管理员 %s 的密码（至少 %d 位）: ", args[0], adminPasswordMinLen)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("读取密码失败: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	database, err := InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	admin, err := createAdmin(database, args[0], password)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ 已创建管理员 #%d: %s\n", admin.ID, admin.Username)
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMigrateLegacyAdminPasswords(t *testing.T) {
+	env := newTestEnv(t, nil)
+	if err := env.db.Exec(`ALTER TABLE cld_device ADD COLUMN admin_password varchar`).Error; err != nil {
+		t.Fatalf("failed to add legacy column: %v", err)
+	}
+	for _, fingerprint := range []string{"legacy-admin", "legacy device", "legacy-user"} {
+		if _, err := env.db.GetOrCreateDevice(fingerprint, "windows"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.db.Exec(`UPDATE cld_device SET admin = true, admin_password = 'old-secret' WHERE fingerprint IN ('legacy-admin', 'legacy device')`)
+	env.db.Exec(`UPDATE cld_device SET admin_password = 'not-an-admin' WHERE fingerprint = 'legacy-user'`)
+	if err := migrateLegacyAdminPasswords(env.db.DB); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if env.db.Migrator().HasColumn(&CldDevice{}, "admin_password") {
+		t.Fatalf("legacy column was not dropped")
+	}
+	spaced, err := env.db.GetDeviceByFingerprint("legacy device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"legacy-admin", fmt.Sprintf("device-%d", spaced.ID)} {
+		if _, err := authenticateAdmin(env.db, username, "old-secret"); err != nil {
+			t.Fatalf("migrated admin %s cannot log in: %v", username, err)
+		}
+	}
+	if count, _ := env.db.CountAdmins(); count != 2 {
+		t.Fatalf("got %d admins, want 2", count)
+	}
+	if err := migrateLegacyAdminPasswords(env.db.DB); err != nil {
+		t.Fatalf("second migration run failed: %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AnthropicContent []AnthropicContentBlock
+
+func (a *AnthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*a = AnthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return fmt.Errorf("content must be a string or an array of content blocks")
+	}
+	*a = blocks
+	return nil
+}
+
+func (b AnthropicContentBlock) MarshalJSON() ([]byte, error) {
+	type block AnthropicContentBlock
+	if b.Type == "text" {
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{b.Type, b.Text})
+	}
+	return json.Marshal(block(b))
+}
+
+func (a AnthropicContent) Parts() (string, []RequestFile, error) {
+	texts := make([]string, 0, len(a))
+	var files []RequestFile
+	for i, block := range a {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "image", "document":
+			file, err := block.Source.file(block.Title)
+			if err != nil {
+				return "", nil, fmt.Errorf("content.%d: %v", i, err)
+			}
+			files = append(files, file)
+		default:
+			return "", nil, fmt.Errorf("content.%d: unsupported content block type %q", i, block.Type)
+		}
+	}
+	return strings.Join(texts, "\n"), files, nil
+}
+
+func (m AnthropicMessage) compatMessages() ([]OpenAIMessage, error) {
+	var texts []string
+	var files []RequestFile
+	var toolCalls []OpenAIToolCall
+	var results []OpenAIMessage
+	for i, block := range m.Content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "image", "document":
+			file, err := block.Source.file(block.Title)
+			if err != nil {
+				return nil, fmt.Errorf("content.%d: %v", i, err)
+			}
+			files = append(files, file)
+		case "tool_use":
+			if m.Role != "assistant" {
+				return nil, fmt.Errorf("content.%d: tool_use blocks are only allowed in assistant messages", i)
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: OpenAIFunctionCall{
+					Name:      block.Name,
+					Arguments: string(normalizeToolArguments(block.Input)),
+				},
+			})
+		case "tool_result":
+			content, resultFiles, err := block.Content.Parts()
+			if err != nil {
+				return nil, fmt.Errorf("content.%d: %v", i, err)
+			}
+			if block.IsError {
+				content = "[error] " + content
+			}
+			results = append(results, OpenAIMessage{
+				Role:       "tool",
+				ToolCallID: block.ToolUseID,
+				Content:    content,
+				Files:      resultFiles,
+			})
+		default:
+			return nil, fmt.Errorf("content.%d: unsupported content block type %q", i, block.Type)
+		}
+	}
+	messages := results
+	if len(texts) > 0 || len(files) > 0 || len(toolCalls) > 0 || len(results) == 0 {
+		messages = append(messages, OpenAIMessage{
+			Role:      m.Role,
+			Content:   strings.Join(texts, "\n"),
+			ToolCalls: toolCalls,
+			Files:     files,
+		})
+	}
+	return messages, nil
+}
+
+func anthropicToolChoice(choice *AnthropicToolChoice) (CompatToolChoice, bool, error) {
+	if choice == nil {
+		return CompatToolChoice{Mode: "auto"}, true, nil
+	}
+	parallel := !choice.DisableParallelToolUse
+	switch choice.Type {
+	case "", "auto":
+		return CompatToolChoice{Mode: "auto"}, parallel, nil
+	case "any":
+		return CompatToolChoice{Mode: "required"}, parallel, nil
+	case "tool":
+		if choice.Name == "" {
+			return CompatToolChoice{}, false, fmt.Errorf("tool_choice.name is required for type \"tool\"")
+		}
+		return CompatToolChoice{Mode: "required", Name: choice.Name}, parallel, nil
+	case "none":
+		return CompatToolChoice{Mode: "none"}, parallel, nil
+	}
+	return CompatToolChoice{}, false, fmt.Errorf("invalid tool_choice type %q", choice.Type)
+}
+
+func anthropicResponseContent(result *CompatResult) []AnthropicContentBlock {
+	content := make([]AnthropicContentBlock, 0, len(result.ToolCalls)+1)
+	if result.Text != "" || len(result.ToolCalls) == 0 {
+		content = append(content, AnthropicContentBlock{Type: "text", Text: result.Text})
+	}
+	for _, call := range result.ToolCalls {
+		content = append(content, anthropicToolUse(call, call.Arguments))
+	}
+	return content
+}
+
+func anthropicToolUse(call CompatToolCall, input json.RawMessage) AnthropicContentBlock {
+	return AnthropicContentBlock{
+		Type:  "tool_use",
+		ID:    "toolu_" + call.ID,
+		Name:  call.Name,
+		Input: input,
+	}
+}
+
+func anthropicMessageID() string {
+	return "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+func anthropicError(c *gin.Context, status int, message string) {
+	errType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errType = "invalid_request_error"
+	case http.StatusForbidden:
+		errType = "permission_error"
+	case http.StatusNotFound:
+		errType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		errType = "overloaded_error"
+	}
+	c.JSON(status, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+func anthropicStopReason(result *CompatResult, stop []string) (string, *string) {
+	switch result.FinishReason {
+	case "length":
+		return "max_tokens", nil
+	case "tool_calls":
+		return "tool_use", nil
+	}
+	if result.Result.FinishReason == "stop" && result.Result.StopSequence != "" {
+		sequence := result.Result.StopSequence
+		return "stop_sequence", &sequence
+	}
+	if _, sequence := firstStopSequence(result.Result.Text, stop); sequence != "" {
+		return "stop_sequence", &sequence
+	}
+	return "end_turn", nil
+}
+
+func renderAnthropicPrompt(req AnthropicMessagesRequest) (CompatRequest, error) {
+	messages := make([]OpenAIMessage, 0, len(req.Messages)+1)
+	if len(req.System) > 0 {
+		system, files, err := req.System.Parts()
+		if err == nil && len(files) > 0 {
+			err = fmt.Errorf("only text blocks are supported")
+		}
+		if err != nil {
+			return CompatRequest{}, fmt.Errorf("system: %v", err)
+		}
+		messages = append(messages, OpenAIMessage{Role: "system", Content: system})
+	}
+	for i, msg := range req.Messages {
+		converted, err := msg.compatMessages()
+		if err != nil {
+			return CompatRequest{}, fmt.Errorf("messages.%d: %v", i, err)
+		}
+		messages = append(messages, converted...)
+	}
+	return renderCompatPrompt(messages)
+}
+
+func (h *Handler) AnthropicMessages(c *gin.Context) {
+	var req AnthropicMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		anthropicError(c, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		anthropicError(c, http.StatusBadRequest, "messages: at least one message is required")
+		return
+	}
+	if req.MaxTokens <= 0 {
+		anthropicError(c, http.StatusBadRequest, "max_tokens: must be a positive integer")
+		return
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 1) {
+		anthropicError(c, http.StatusBadRequest, "temperature: must be between 0 and 1")
+		return
+	}
+	model, err := h.config.ResolveModel(req.Model)
+	if err != nil {
+		anthropicError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if req.Model == "" {
+		req.Model = model.ID
+	}
+	compatReq, err := renderAnthropicPrompt(req)
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkModelFiles(model, compatReq.Files); err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	compatReq.Model = model
+	compatReq.MaxTokens = req.MaxTokens
+	compatReq.Stop = req.StopSequences
+	if req.Metadata != nil {
+		compatReq.User = req.Metadata.UserID
+	}
+	toolChoice, parallel, err := anthropicToolChoice(req.ToolChoice)
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	tools := make([]CompatTool, 0, len(req.Tools))
+	for _, tool := range req.Tools {
+		tools = append(tools, CompatTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.InputSchema,
+		})
+	}
+	if err := compatReq.enableTools(tools, toolChoice, parallel); err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := compatReq.enableStructuredOutput(req.OutputFormat); err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
+		log.Printf("[Usage Limit] Anthropic messages blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
+		anthropicError(c, http.StatusTooManyRequests, fmt.Sprintf("Usage limit exceeded (%s), resets at %s", blockReason, blockResetTime))
+		return
+	}
+	if req.Stream {
+		h.streamAnthropicMessages(c, compatReq, req.Model)
+		return
+	}
+	result, err := h.runCompatCompletion(c, compatReq, nil)
+	if err != nil {
+		anthropicError(c, compatErrorStatus(err), err.Error())
+		return
+	}
+	stopReason, stopSequence := anthropicStopReason(result, compatReq.Stop)
+	c.JSON(http.StatusOK, AnthropicMessagesResponse{
+		ID:           anthropicMessageID(),
+		Type:         "message",
+		Role:         "assistant",
+		Model:        req.Model,
+		Content:      anthropicResponseContent(result),
+		StopReason:   &stopReason,
+		StopSequence: stopSequence,
+		Usage: AnthropicUsage{
+			InputTokens:  result.PromptTokens(),
+			OutputTokens: result.CompletionTokens(),
+		},
+	})
+}
+
+func (h *Handler) streamAnthropicMessages(c *gin.Context, req CompatRequest, model string) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		anthropicError(c, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		sendSSEEvent(c.Writer, flusher, "message_start", gin.H{
+			"type": "message_start",
+			"message": AnthropicMessagesResponse{
+				ID:      anthropicMessageID(),
+				Type:    "message",
+				Role:    "assistant",
+				Model:   model,
+				Content: []AnthropicContentBlock{},
+				Usage:   AnthropicUsage{InputTokens: req.PromptTokens()},
+			},
+		})
+		sendSSEEvent(c.Writer, flusher, "content_block_start", gin.H{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": AnthropicContentBlock{Type: "text"},
+		})
+	}
+	textDelta := func(text string) {
+		sendSSEEvent(c.Writer, flusher, "content_block_delta", gin.H{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": gin.H{"type": "text_delta", "text": text},
+		})
+	}
+	req.stream = &compatStream{write: func(delta string) {
+		start()
+		textDelta(delta)
+	}}
+	result, err := h.runCompatCompletion(c, req, nil)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if !started {
+			anthropicError(c, compatErrorStatus(err), err.Error())
+			return
+		}
+		sendSSEEvent(c.Writer, flusher, "error", gin.H{
+			"type":  "error",
+			"error": gin.H{"type": "api_error", "message": err.Error()},
+		})
+		return
+	}
+	start()
+	stopReason, stopSequence := anthropicStopReason(result, req.Stop)
+	sendSSEEvent(c.Writer, flusher, "content_block_stop", gin.H{
+		"type":  "content_block_stop",
+		"index": 0,
+	})
+	for i, call := range result.ToolCalls {
+		sendSSEEvent(c.Writer, flusher, "content_block_start", gin.H{
+			"type":          "content_block_start",
+			"index":         i + 1,
+			"content_block": anthropicToolUse(call, json.RawMessage("{}")),
+		})
+		sendSSEEvent(c.Writer, flusher, "content_block_delta", gin.H{
+			"type":  "content_block_delta",
+			"index": i + 1,
+			"delta": gin.H{"type": "input_json_delta", "partial_json": string(call.Arguments)},
+		})
+		sendSSEEvent(c.Writer, flusher, "content_block_stop", gin.H{
+			"type":  "content_block_stop",
+			"index": i + 1,
+		})
+	}
+	sendSSEEvent(c.Writer, flusher, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": stopReason, "stop_sequence": stopSequence},
+		"usage": gin.H{"output_tokens": result.CompletionTokens()},
+	})
+	sendSSEEvent(c.Writer, flusher, "message_stop", gin.H{"type": "message_stop"})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+func TestAnthropicMessages(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("Bonjour"))
+	var resp AnthropicMessagesResponse
+	env.doJSON(http.MethodPost, "/v1/messages", "device-anthropic", map[string]any{
+		"max_tokens": 100,
+		"messages":   []map[string]any{{"role": "user", "content": "Hello"}},
+	}, http.StatusOK, &resp)
+	if len(resp.Content) != 1 || resp.Content[0].Text != "Bonjour" || resp.StopReason == nil || *resp.StopReason != "end_turn" {
+		t.Fatalf("got %+v, want a single Bonjour text block", resp)
+	}
+}
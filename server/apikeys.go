@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	scopeChat           = "chat"
+	scopeReadHistory    = "read-history"
+	scopeAdmin          = "admin"
+	apiKeyPrefix        = "sk-cs-"
+	apiAccessContextKey = "api_access"
+	queryAPIKeyParam    = "api_key"
+	queryAPIKeyContext  = "query_api_key"
+)
+
+var apiKeyScopes = []string{scopeChat, scopeReadHistory, scopeAdmin}
+
+var errAPIKeyDevice = errors.New("API key is bound to another device")
+
+type APIAccess struct {
+	Key   *CldAPIKey
+	Admin *AdminSession
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	DeviceID  string   `json:"device_id"`
+	QuotaTier string   `json:"quota_tier"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKey() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(raw), nil
+}
+
+func normalizeScopes(scopes []string) (string, error) {
+	var normalized []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || slices.Contains(normalized, scope) {
+			continue
+		}
+		if !slices.Contains(apiKeyScopes, scope) {
+			return "", fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(apiKeyScopes, ", "))
+		}
+		normalized = append(normalized, scope)
+	}
+	if len(normalized) == 0 {
+		return "", fmt.Errorf("at least one scope is required")
+	}
+	return strings.Join(normalized, ","), nil
+}
+
+func (k *CldAPIKey) allows(scopes ...string) bool {
+	granted := strings.Split(k.Scopes, ",")
+	if slices.Contains(granted, scopeAdmin) {
+		return true
+	}
+	for _, scope := range scopes {
+		if slices.Contains(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func issueAPIKey(db *Database, name, scopes string, deviceID *int, quotaTier *string) (string, *CldAPIKey, error) {
+	token, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+	key := &CldAPIKey{
+		Name:      name,
+		Prefix:    token[:len(apiKeyPrefix)+6],
+		KeyHash:   hashAPIKey(token),
+		Scopes:    scopes,
+		DeviceID:  deviceID,
+		QuotaTier: quotaTier,
+	}
+	if err := db.CreateAPIKey(key); err != nil {
+		return "", nil, err
+	}
+	return token, key, nil
+}
+
+func (c *Config) apiKeyRequired() bool {
+	return c.RequireAPIKey == nil || *c.RequireAPIKey
+}
+
+func StripQueryAPIKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		if token := query.Get(queryAPIKeyParam); token != "" {
+			c.Set(queryAPIKeyContext, token)
+		}
+		if query.Has(queryAPIKeyParam) {
+			query.Del(queryAPIKeyParam)
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+func requestAPIKey(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if token := c.GetHeader("X-API-Key"); token != "" {
+		return token
+	}
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		return c.GetString(queryAPIKeyContext)
+	}
+	return ""
+}
+
+func bindRequestDevice(c *gin.Context, fingerprint string) error {
+	query := c.Request.URL.Query()
+	for _, device := range []string{c.GetHeader("X-Device-ID"), query.Get("device_id")} {
+		if device != "" && device != fingerprint {
+			return errAPIKeyDevice
+		}
+	}
+	c.Request.Header.Set("X-Device-ID", fingerprint)
+	query.Set("device_id", fingerprint)
+	c.Request.URL.RawQuery = query.Encode()
+	return nil
+}
+
+func APIKeyMiddleware(cfg *Config, db *Database, scopes ...string) gin.HandlerFunc {
+	return accessMiddleware(cfg, db, false, scopes)
+}
+
+func AdminMiddleware(cfg *Config, db *Database, scopes ...string) gin.HandlerFunc {
+	return accessMiddleware(cfg, db, true, scopes)
+}
+
+func accessMiddleware(cfg *Config, db *Database, alwaysRequired bool, scopes []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if session := requestAdminSession(c, db); session != nil {
+			c.Set(apiAccessContextKey, &APIAccess{Admin: session})
+			c.Next()
+			return
+		}
+		required := alwaysRequired || cfg.apiKeyRequired() || slices.Contains(scopes, scopeAdmin)
+		token := requestAPIKey(c)
+		if token == "" {
+			if required {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Admin login or API key required"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		key, err := db.GetAPIKeyByHash(hashAPIKey(token))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+		if !key.allows(scopes...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API key lacks required scope: %s", strings.Join(scopes, " or "))})
+			c.Abort()
+			return
+		}
+		if key.DeviceID != nil {
+			device, err := db.GetDeviceByID(*key.DeviceID)
+			if err == nil {
+				key.DeviceFingerprint = device.Fingerprint
+				err = bindRequestDevice(c, device.Fingerprint)
+			}
+			if err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": errAPIKeyDevice.Error()})
+				c.Abort()
+				return
+			}
+		}
+		if key.LastUsedTime == nil || time.Since(*key.LastUsedTime) > time.Minute {
+			if err := db.TouchAPIKey(key.ID); err != nil {
+				log.Printf("Failed to update API key usage: %v", err)
+			}
+		}
+		c.Set(apiAccessContextKey, &APIAccess{Key: key})
+		c.Next()
+	}
+}
+
+func requestAPIAccess(ctx context.Context) *APIAccess {
+	if access, ok := ctx.Value(apiAccessContextKey).(*APIAccess); ok {
+		return access
+	}
+	return &APIAccess{}
+}
+
+func (a *APIAccess) allows(scopes ...string) bool {
+	if a.Admin != nil {
+		return true
+	}
+	return a.Key != nil && a.Key.allows(scopes...)
+}
+
+func (a *APIAccess) boundDeviceID() *int {
+	if a.Admin != nil || a.Key == nil {
+		return nil
+	}
+	return a.Key.DeviceID
+}
+
+func (a *APIAccess) ownsConversation(conv *CldConversation) bool {
+	deviceID := a.boundDeviceID()
+	return deviceID == nil || conv.DeviceID == *deviceID
+}
+
+func (a *APIAccess) keyID() *int {
+	if a.Key == nil {
+		return nil
+	}
+	return &a.Key.ID
+}
+
+func (a *APIAccess) actor() string {
+	switch {
+	case a.Admin != nil:
+		return "管理员 " + a.Admin.Username
+	case a.Key != nil:
+		return "API 密钥 " + a.Key.Name
+	}
+	return "匿名请求"
+}
+
+func (a *APIAccess) authorizeWSMessage(msgType string, msg map[string]any) error {
+	if a.Admin != nil {
+		return nil
+	}
+	var scope string
+	switch msgType {
+	case "dialogue", "regenerate", "edit", "cancel", "keepalive":
+		scope = scopeChat
+	case "api_request":
+		scope = scopeReadHistory
+	}
+	if a.Key == nil {
+		if scope == scopeReadHistory {
+			return fmt.Errorf("admin login or API key with %s scope required", scopeReadHistory)
+		}
+		return nil
+	}
+	if scope != "" && !a.Key.allows(scope) {
+		return fmt.Errorf("API key lacks required scope: %s", scope)
+	}
+	data, ok := msg["data"].(map[string]any)
+	if a.Key.DeviceFingerprint == "" || !ok {
+		return nil
+	}
+	if device, _ := data["device_id"].(string); device != "" && device != a.Key.DeviceFingerprint {
+		return errAPIKeyDevice
+	}
+	data["device_id"] = a.Key.DeviceFingerprint
+	return nil
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.db.GetAPIKeys()
+	if err != nil {
+		log.Printf("Failed to load API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key name cannot be empty"})
+		return
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var deviceID *int
+	if req.DeviceID != "" {
+		device, err := h.db.GetDeviceByFingerprint(req.DeviceID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load device"})
+			return
+		}
+		deviceID = &device.ID
+	}
+	quotaTier, err := h.config.quotaTierParam(req.QuotaTier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, key, err := issueAPIKey(h.db, req.Name, scopes, deviceID, quotaTier)
+	if err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	log.Printf("✓ 已创建 API 密钥: %s (%s)", key.Name, key.Scopes)
+	c.JSON(http.StatusOK, gin.H{
+		"key":     token,
+		"api_key": key,
+	})
+}
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+	revoked, err := h.db.RevokeAPIKey(id)
+	if err != nil {
+		log.Printf("Failed to revoke API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	log.Printf("✓ 已吊销 API 密钥: %d", id)
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+func CreateAPIKeyCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("用法: claude-adapter -k <名称> [权限,逗号分隔] [设备ID]")
+	}
+	scopeList := scopeChat
+	if len(args) > 1 {
+		scopeList = args[1]
+	}
+	scopes, err := normalizeScopes(strings.Split(scopeList, ","))
+	if err != nil {
+		return err
+	}
+	database, err := InitDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	var deviceID *int
+	if len(args) > 2 {
+		device, err := database.GetDeviceByFingerprint(args[2])
+		if err != nil {
+			return fmt.Errorf("设备不存在: %s", args[2])
+		}
+		deviceID = &device.ID
+	}
+	token, key, err := issueAPIKey(database, args[0], scopes, deviceID, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ 已创建 API 密钥 #%d: %s (%s)\n", key.ID, key.Name, key.Scopes)
+	fmt.Println(token)
+	fmt.Println("请妥善保存，密钥只会显示这一次")
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var attachmentExtensions = map[string]string{
+	"image/png":        "png",
+	"image/jpeg":       "jpg",
+	"image/gif":        "gif",
+	"image/webp":       "webp",
+	"application/pdf":  "pdf",
+	"application/json": "json",
+	"text/plain":       "txt",
+	"text/markdown":    "md",
+	"text/csv":         "csv",
+	"text/html":        "html",
+}
+
+func attachmentExtension(mediaType string) string {
+	if ext, ok := attachmentExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return strings.TrimPrefix(exts[0], ".")
+	}
+	return "bin"
+}
+
+func decodeDataURL(dataURL string) (string, string, error) {
+	if !strings.HasPrefix(dataURL, "data:") {
+		return "", "", fmt.Errorf("only data URLs are supported")
+	}
+	header, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok {
+		return "", "", fmt.Errorf("invalid data URL")
+	}
+	header, isBase64 := strings.CutSuffix(header, ";base64")
+	mediaType, _, _ := strings.Cut(header, ";")
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	if !isBase64 {
+		text, err := url.PathUnescape(data)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid data URL: %v", err)
+		}
+		data = base64.StdEncoding.EncodeToString([]byte(text))
+	}
+	return mediaType, data, nil
+}
+
+func newRequestFile(name, mediaType, data string) (RequestFile, error) {
+	file := RequestFile{Name: name, Type: mediaType, Content: data}
+	if err := file.DecodeContent(); err != nil {
+		return file, err
+	}
+	if file.Type == "" {
+		file.Type, _, _ = strings.Cut(http.DetectContentType(file.ContentRaw), ";")
+	}
+	return file, nil
+}
+
+func nameAttachment(file *RequestFile, index int) {
+	ext := attachmentExtension(file.Type)
+	if file.Name == "" {
+		file.Name = fmt.Sprintf("attachment-%d.%s", index, ext)
+	} else if path.Ext(file.Name) == "" {
+		file.Name += "." + ext
+	}
+}
+
+func (p OpenAIContentPart) imageURL() (string, error) {
+	var single string
+	if err := json.Unmarshal(p.ImageURL, &single); err == nil {
+		return single, nil
+	}
+	var image OpenAIImageURL
+	if err := json.Unmarshal(p.ImageURL, &image); err != nil || image.URL == "" {
+		return "", fmt.Errorf("image_url must contain a url")
+	}
+	return image.URL, nil
+}
+
+func (p OpenAIContentPart) file() (RequestFile, error) {
+	part := p.File
+	if part == nil {
+		part = &OpenAIFilePart{Filename: p.Filename, FileData: p.FileData}
+	}
+	if part.FileData == "" {
+		return RequestFile{}, fmt.Errorf("file parts must include file_data, file_id is not supported")
+	}
+	if !strings.HasPrefix(part.FileData, "data:") {
+		return newRequestFile(part.Filename, "", part.FileData)
+	}
+	mediaType, data, err := decodeDataURL(part.FileData)
+	if err != nil {
+		return RequestFile{}, err
+	}
+	return newRequestFile(part.Filename, mediaType, data)
+}
+
+func (m *OpenAIMessage) applyContentParts(parts []OpenAIContentPart) error {
+	texts := make([]string, 0, len(parts))
+	for i, part := range parts {
+		switch part.Type {
+		case "text", "input_text":
+			texts = append(texts, part.Text)
+		case "image_url", "input_image":
+			imageURL, err := part.imageURL()
+			if err != nil {
+				return fmt.Errorf("content.%d: %v", i, err)
+			}
+			mediaType, data, err := decodeDataURL(imageURL)
+			if err != nil {
+				return fmt.Errorf("content.%d: image_url: %v", i, err)
+			}
+			file, err := newRequestFile("", mediaType, data)
+			if err != nil {
+				return fmt.Errorf("content.%d: %v", i, err)
+			}
+			m.Files = append(m.Files, file)
+		case "file", "input_file":
+			file, err := part.file()
+			if err != nil {
+				return fmt.Errorf("content.%d: %v", i, err)
+			}
+			m.Files = append(m.Files, file)
+		default:
+			return fmt.Errorf("content.%d: unsupported content part type %q", i, part.Type)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+func (m *OpenAIMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role       string           `json:"role"`
+		Content    json.RawMessage  `json:"content"`
+		Images     []string         `json:"images"`
+		ToolCalls  []OpenAIToolCall `json:"tool_calls"`
+		ToolCallID string           `json:"tool_call_id"`
+		Name       string           `json:"name"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	m.Role = raw.Role
+	m.Content = ""
+	m.ToolCalls = raw.ToolCalls
+	m.ToolCallID = raw.ToolCallID
+	m.Name = raw.Name
+	m.Files = nil
+	if len(raw.Content) > 0 && string(raw.Content) != "null" {
+		if err := json.Unmarshal(raw.Content, &m.Content); err != nil {
+			var parts []OpenAIContentPart
+			if err := json.Unmarshal(raw.Content, &parts); err != nil {
+				return fmt.Errorf("content must be a string or an array of content parts")
+			}
+			if err := m.applyContentParts(parts); err != nil {
+				return err
+			}
+		}
+	}
+	for i, image := range raw.Images {
+		file, err := newRequestFile("", "", image)
+		if err != nil {
+			return fmt.Errorf("images.%d: %v", i, err)
+		}
+		m.Files = append(m.Files, file)
+	}
+	return nil
+}
+
+func (s *AnthropicSource) file(name string) (RequestFile, error) {
+	if s == nil {
+		return RequestFile{}, fmt.Errorf("source is required")
+	}
+	switch s.Type {
+	case "base64":
+		return newRequestFile(name, s.MediaType, s.Data)
+	case "text":
+		mediaType := s.MediaType
+		if mediaType == "" {
+			mediaType = "text/plain"
+		}
+		return newRequestFile(name, mediaType, base64.StdEncoding.EncodeToString([]byte(s.Data)))
+	default:
+		return RequestFile{}, fmt.Errorf("unsupported source type %q, only base64 and text are supported", s.Type)
+	}
+}
+
+func (h *Handler) uploadAttachments(ctx context.Context, conversationID string, files []RequestFile) ([]FileAttachment, error) {
+	attachments := make([]FileAttachment, 0, len(files))
+	for i := range files {
+		uploadResp, err := h.client.UploadFile(ctx, conversationID, &files[i])
+		if err != nil {
+			return nil, fmt.Errorf("file upload error: %v", err)
+		}
+		attachments = append(attachments, FileAttachment{
+			FileUUID: uploadResp.FileUUID,
+			FileName: uploadResp.FileName,
+			FileType: files[i].Type,
+			FileSize: uploadResp.SizeBytes,
+		})
+	}
+	return attachments, nil
+}
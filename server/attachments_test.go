@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+const testPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func TestChatCompletionSendsUploadedImage(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("A single pixel."))
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-image", map[string]any{
+		"messages": []map[string]any{{
+			"role": "user",
+			"content": []map[string]any{
+				{"type": "text", "text": "What is this?"},
+				{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64," + testPNG}},
+			},
+		}},
+	}, http.StatusOK, &resp)
+	if len(env.fake.Requests(fakeclaude.RouteUploadFile)) != 1 {
+		t.Fatalf("image was not uploaded")
+	}
+	requests := env.fake.Requests(fakeclaude.RouteCompletion)
+	var body struct {
+		Files []string `json:"files"`
+	}
+	if len(requests) != 1 || json.Unmarshal([]byte(requests[0].Body), &body) != nil || len(body.Files) != 1 {
+		t.Fatalf("completion did not reference the uploaded file: %+v", requests)
+	}
+	if file, ok := env.fake.Upload(body.Files[0]); !ok || file.FileKind != "image" {
+		t.Fatalf("completion referenced %q, which was never uploaded", body.Files[0])
+	}
+	conv, _ := env.fake.Conversation(conversationFromPath(requests[0].Path))
+	if len(conv.Messages) != 2 || len(conv.Messages[0].Files) != 1 || conv.Messages[0].Files[0].FileUUID != body.Files[0] {
+		t.Fatalf("upstream message %+v does not carry the image", conv.Messages)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+type BranchPoint struct {
+	Source            *CldDialogue
+	ConversationID    string
+	ParentMessageUUID string
+}
+
+type DialogueBranch struct {
+	LeafID      int       `json:"leaf_id"`
+	DialogueIDs []int     `json:"dialogue_ids"`
+	Preview     string    `json:"preview"`
+	Status      string    `json:"status"`
+	Active      bool      `json:"active"`
+	UpdateTime  time.Time `json:"update_time"`
+}
+
+type EditDialogueRequest struct {
+	Request string        `json:"request"`
+	Model   string        `json:"model,omitempty"`
+	Style   string        `json:"style,omitempty"`
+	Files   []RequestFile `json:"files,omitempty"`
+}
+
+type SwitchBranchRequest struct {
+	DialogueID int `json:"dialogue_id"`
+}
+
+func (h *Handler) branchPoint(access *APIAccess, dialogueID int) (*BranchPoint, error) {
+	source, conv, _, err := h.db.GetDialogueWithConversation(dialogueID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !access.ownsConversation(conv)) {
+		return nil, fmt.Errorf("dialogue %d not found", dialogueID)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load dialogue %d: %v", dialogueID, err)
+	}
+	if source.ParentMessageUUID == nil || *source.ParentMessageUUID == "" {
+		return nil, fmt.Errorf("dialogue %d has no upstream message uuid", dialogueID)
+	}
+	return &BranchPoint{
+		Source:            source,
+		ConversationID:    conv.UID,
+		ParentMessageUUID: *source.ParentMessageUUID,
+	}, nil
+}
+
+func (h *Handler) branchFrom(access *APIAccess, dialogueID int, request *string) (*BranchPoint, error) {
+	if dialogueID == 0 {
+		return nil, nil
+	}
+	branch, err := h.branchPoint(access, dialogueID)
+	if err != nil {
+		return nil, err
+	}
+	if *request == "" {
+		*request = branch.Source.UserMessage
+	}
+	return branch, nil
+}
+
+func (h *Handler) lastMessageUUID(ctx context.Context, conversationID string) (string, error) {
+	conv, err := h.db.GetConversationByUID(conversationID)
+	if err != nil {
+		return h.client.GetLastMessageUUID(ctx, conversationID)
+	}
+	if _, err := h.syncConversation(ctx, conv); err != nil {
+		log.Printf("⚠ 同步上游对话历史失败 %s: %v", conversationID, err)
+	}
+	if conv.CurrentDialogueID != nil {
+		if current, err := h.db.GetDialogueByID(*conv.CurrentDialogueID); err == nil && current.AssistantMessageUUID != nil {
+			return *current.AssistantMessageUUID, nil
+		}
+	}
+	return h.client.GetLastMessageUUID(ctx, conversationID)
+}
+
+func (h *Handler) advanceConversation(ctx context.Context, conversationID string, dialogue *CldDialogue, result *StreamResult) {
+	lastMessageUUID := result.MessageUUID
+	if lastMessageUUID == "" {
+		if uuid, err := h.client.GetLastMessageUUID(ctx, conversationID); err == nil {
+			lastMessageUUID = uuid
+		}
+	}
+	if lastMessageUUID != "" {
+		h.dialogueManager.UpdateSession(conversationID, lastMessageUUID)
+	}
+	if err := h.db.SetCurrentDialogue(dialogue.ConversationID, dialogue.ID); err != nil {
+		log.Printf("Failed to update current dialogue: %v", err)
+	}
+}
+
+func currentDialogueID(conv *CldConversation, dialogues []CldDialogue) int {
+	if conv.CurrentDialogueID != nil {
+		return *conv.CurrentDialogueID
+	}
+	if len(dialogues) > 0 {
+		return dialogues[len(dialogues)-1].ID
+	}
+	return 0
+}
+
+func dialogueBranches(dialogues []CldDialogue, currentID int) []DialogueBranch {
+	byID := make(map[int]*CldDialogue, len(dialogues))
+	hasChildren := make(map[int]bool)
+	for i := range dialogues {
+		byID[dialogues[i].ID] = &dialogues[i]
+		if dialogues[i].ParentID != nil {
+			hasChildren[*dialogues[i].ParentID] = true
+		}
+	}
+	branches := make([]DialogueBranch, 0)
+	for _, leaf := range dialogues {
+		if hasChildren[leaf.ID] {
+			continue
+		}
+		var ids []int
+		active := false
+		for node := byID[leaf.ID]; node != nil; {
+			ids = append([]int{node.ID}, ids...)
+			if node.ID == currentID {
+				active = true
+			}
+			if node.ParentID == nil {
+				break
+			}
+			node = byID[*node.ParentID]
+		}
+		updateTime := leaf.CreateTime
+		if leaf.FinishTime != nil {
+			updateTime = *leaf.FinishTime
+		}
+		branches = append(branches, DialogueBranch{
+			LeafID:      leaf.ID,
+			DialogueIDs: ids,
+			Preview:     truncateRunes(leaf.UserMessage, 50),
+			Status:      leaf.Status,
+			Active:      active,
+			UpdateTime:  updateTime,
+		})
+	}
+	return branches
+}
+
+func (h *Handler) RegenerateDialogue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dialogue ID"})
+		return
+	}
+	var req DialogueRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	h.dialogueHTTP(c, DialogueRequest{
+		Model:             req.Model,
+		Style:             req.Style,
+		Files:             req.Files,
+		ReplaceDialogueID: id,
+	})
+}
+
+func (h *Handler) EditDialogue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dialogue ID"})
+		return
+	}
+	var req EditDialogueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Request == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request cannot be empty"})
+		return
+	}
+	h.dialogueHTTP(c, DialogueRequest{
+		Request:           req.Request,
+		Model:             req.Model,
+		Style:             req.Style,
+		Files:             req.Files,
+		ReplaceDialogueID: id,
+	})
+}
+
+func (h *Handler) handleWSBranchRequest(ctx context.Context, conn *websocket.Conn, msg map[string]any, regenerate bool) {
+	data, ok := msg["data"].(map[string]any)
+	if !ok {
+		sendWSError(conn, "Invalid branch request: missing data field")
+		return
+	}
+	dialogueID, _ := data["dialogue_id"].(float64)
+	if dialogueID == 0 {
+		sendWSError(conn, "dialogue_id is required")
+		return
+	}
+	if regenerate {
+		data["request"] = ""
+	} else if request, _ := data["request"].(string); request == "" {
+		sendWSError(conn, "Request cannot be empty")
+		return
+	}
+	data["replace_dialogue_id"] = dialogueID
+	delete(data, "conversation_id")
+	h.handleWSDialogueRequest(ctx, conn, msg)
+}
+
+func (h *Handler) GetDialogueBranches(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	conv, err := h.db.GetConversation(id)
+	if err != nil || !requestAPIAccess(c).ownsConversation(conv) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	dialogues, err := h.db.GetConversationDialogues(conv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dialogues"})
+		return
+	}
+	currentID := currentDialogueID(conv, dialogues)
+	c.JSON(http.StatusOK, gin.H{
+		"conversation_id":     conv.ID,
+		"conversation_uid":    conv.UID,
+		"current_dialogue_id": currentID,
+		"branches":            dialogueBranches(dialogues, currentID),
+		"dialogues":           dialogues,
+	})
+}
+
+func (h *Handler) SwitchDialogueBranch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	var req SwitchBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.DialogueID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dialogue_id is required"})
+		return
+	}
+	conv, err := h.db.GetConversation(id)
+	if err != nil || !requestAPIAccess(c).ownsConversation(conv) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	dialogue, err := h.db.GetDialogueByID(req.DialogueID)
+	if err != nil || dialogue.ConversationID != conv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dialogue not found in conversation"})
+		return
+	}
+	if dialogue.AssistantMessageUUID == nil || *dialogue.AssistantMessageUUID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Dialogue has no upstream reply to continue from"})
+		return
+	}
+	session := h.dialogueManager.GetOrCreateSession(conv.UID)
+	session.GeneratingMutex.RLock()
+	generating := session.IsGenerating
+	session.GeneratingMutex.RUnlock()
+	if generating {
+		c.JSON(http.StatusConflict, gin.H{"error": "Conversation is generating, try again later"})
+		return
+	}
+	if err := h.db.SetCurrentDialogue(conv.ID, dialogue.ID); err != nil {
+		log.Printf("Failed to switch branch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch branch"})
+		return
+	}
+	h.dialogueManager.UpdateSession(conv.UID, *dialogue.AssistantMessageUUID)
+	broadcastDialogues()
+	log.Printf("✓ 已切换对话分支: %s → dialogue %d", conv.UID, dialogue.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Branch switched successfully",
+		"conversation_id":     conv.ID,
+		"current_dialogue_id": dialogue.ID,
+		"parent_message_uuid": *dialogue.AssistantMessageUUID,
+	})
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const rootMessageUUID = "00000000-0000-4000-8000-000000000000"
+
+type CompletionRequest struct {
+	ConversationID    string
+	Prompt            string
+	ParentMessageUUID string
+	Model             string
+	Style             string
+	Timezone          string
+	Locale            string
+	SystemPrompt      string
+	Attachments       []FileAttachment
+	Tools             []map[string]any
+	MaxTokens         int
+	Stop              []string
+}
+
+type UpstreamError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+func newUpstreamError(resp *http.Response, body []byte) *UpstreamError {
+	upstreamErr := &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		upstreamErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return upstreamErr
+}
+
+type ClaudeClient interface {
+	CreateConversation(ctx context.Context, incognito bool) (string, error)
+	UploadFile(ctx context.Context, conversationID string, file *RequestFile) (*UploadResponse, error)
+	GetLastMessageUUID(ctx context.Context, conversationID string) (string, error)
+	GetConversation(ctx context.Context, conversationID string) (*UpstreamConversation, error)
+	Complete(ctx context.Context, req CompletionRequest) (<-chan StreamEvent, error)
+	StopResponse(ctx context.Context, conversationID string) error
+}
+
+type ClaudeWebClient struct {
+	config  *Config
+	baseURL string
+	orgID   string
+	cookie  string
+	mcp     *MCPSessionManager
+}
+
+func NewClaudeWebClient(cfg *Config, account AccountConfig) *ClaudeWebClient {
+	return &ClaudeWebClient{
+		config:  cfg,
+		baseURL: strings.TrimRight(cfg.UpstreamBaseURL, "/"),
+		orgID:   account.Tokens.OrganizationID,
+		cookie:  account.GetCookie(),
+	}
+}
+
+func (c *ClaudeWebClient) orgURL(format string, args ...any) string {
+	return fmt.Sprintf("%s/api/organizations/%s", c.baseURL, c.orgID) + fmt.Sprintf(format, args...)
+}
+
+func (c *ClaudeWebClient) setHeaders(req *http.Request, referer string) {
+	req.Header.Set("Cookie", c.cookie)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Accept-Language", acceptLanguage(c.config.Locale))
+	req.Header.Set("Origin", c.baseURL)
+	req.Header.Set("Referer", c.baseURL+referer)
+}
+
+func (c *ClaudeWebClient) CreateConversation(ctx context.Context, incognito bool) (string, error) {
+	maxRetries := 3
+	var lastErr error
+	for i := 0; i < maxRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * time.Second)
+			DebugLog("Retrying create conversation, attempt %d/%d", i+1, maxRetries)
+		}
+		WaitForNextRequest()
+		reqBody := map[string]any{
+			"uuid":                             generateUUID(),
+			"name":                             "",
+			"is_temporary":                     incognito,
+			"include_conversation_preferences": true,
+		}
+		jsonData, _ := json.Marshal(reqBody)
+		DebugLog("Create conversation request: %s", string(jsonData))
+		req, err := http.NewRequestWithContext(ctx, "POST", c.orgURL("/chat_conversations"), bytes.NewReader(jsonData))
+		if err != nil {
+			return "", fmt.Errorf("create request failed: %v", err)
+		}
+		c.setHeaders(req, "/")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "*/*")
+		resp, err := c.config.CreateHTTPClient(30 * time.Second).Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		DebugLog("Create conversation response status: %d", resp.StatusCode)
+		DebugLog("Create conversation response body: %s", string(body))
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			lastErr = newUpstreamError(resp, body)
+			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+				break
+			}
+			continue
+		}
+		var result map[string]any
+		if err := json.Unmarshal(body, &result); err != nil {
+			lastErr = fmt.Errorf("parse response failed: %v", err)
+			continue
+		}
+		uuid, ok := result["uuid"].(string)
+		if !ok {
+			lastErr = fmt.Errorf("no uuid field in response (available fields: %v)", getMapKeys(result))
+			continue
+		}
+		return uuid, nil
+	}
+	return "", fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+}
+
+func (c *ClaudeWebClient) UploadFile(ctx context.Context, conversationID string, file *RequestFile) (*UploadResponse, error) {
+	WaitForNextRequest()
+	DebugLog("Preparing to upload file: %s, size: %d bytes", file.Name, len(file.ContentRaw))
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": file.Name}))
+	contentType := file.Type
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("create form file failed: %v", err)
+	}
+	if _, err := part.Write(file.ContentRaw); err != nil {
+		return nil, fmt.Errorf("write file content failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close writer failed: %v", err)
+	}
+	url := c.orgURL("/conversations/%s/wiggle/upload-file", conversationID)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %v", err)
+	}
+	c.setHeaders(req, "/")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "*/*")
+	resp, err := c.config.CreateHTTPClient(60 * time.Second).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upload request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %v", err)
+	}
+	DebugLog("Upload response status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upload failed, %w", newUpstreamError(resp, bodyBytes))
+	}
+	var uploadResp UploadResponse
+	if err := json.Unmarshal(bodyBytes, &uploadResp); err != nil {
+		return nil, fmt.Errorf("parse upload response failed: %v", err)
+	}
+	DebugLog("File uploaded successfully: %+v", uploadResp)
+	return &uploadResp, nil
+}
+
+func (c *ClaudeWebClient) GetLastMessageUUID(ctx context.Context, conversationID string) (string, error) {
+	conversation, err := c.GetConversation(ctx, conversationID)
+	if err != nil {
+		return "", err
+	}
+	if conversation.CurrentLeafMessageUUID != "" {
+		return conversation.CurrentLeafMessageUUID, nil
+	}
+	if len(conversation.Messages) == 0 || conversation.Messages[len(conversation.Messages)-1].UUID == "" {
+		return rootMessageUUID, nil
+	}
+	return conversation.Messages[len(conversation.Messages)-1].UUID, nil
+}
+
+func (c *ClaudeWebClient) GetConversation(ctx context.Context, conversationID string) (*UpstreamConversation, error) {
+	WaitForNextRequest()
+	req, err := http.NewRequestWithContext(ctx, "GET", c.orgURL("/chat_conversations/%s?tree=True&rendering_mode=messages&render_all_tools=true", conversationID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %v", err)
+	}
+	c.setHeaders(req, "/chat/"+conversationID)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	resp, err := c.config.CreateHTTPClient(30 * time.Second).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp, body)
+	}
+	var conversation UpstreamConversation
+	if err := json.Unmarshal(body, &conversation); err != nil {
+		return nil, fmt.Errorf("parse response failed: %v", err)
+	}
+	return &conversation, nil
+}
+
+func (c *ClaudeWebClient) Complete(ctx context.Context, cr CompletionRequest) (<-chan StreamEvent, error) {
+	body, err := c.buildCompletionBody(cr)
+	if err != nil {
+		return nil, err
+	}
+	WaitForNextRequest()
+	jsonData, _ := json.Marshal(body)
+	DebugLog("Completion request body: %s", string(jsonData))
+	url := c.orgURL("/chat_conversations/%s/completion", cr.ConversationID)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %v", err)
+	}
+	c.setHeaders(req, "/chat/"+cr.ConversationID)
+	if cr.Locale != "" {
+		req.Header.Set("Accept-Language", acceptLanguage(cr.Locale))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.config.CreateHTTPClient(300 * time.Second).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newUpstreamError(resp, body)
+	}
+	events := make(chan StreamEvent, 16)
+	go readCompletionStream(ctx, resp.Body, events)
+	return events, nil
+}
+
+func (c *ClaudeWebClient) StopResponse(ctx context.Context, conversationID string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.orgURL("/chat_conversations/%s/stop_response", conversationID), nil)
+	if err != nil {
+		return fmt.Errorf("create request failed: %v", err)
+	}
+	c.setHeaders(req, "/chat/"+conversationID)
+	resp, err := c.config.CreateHTTPClient(30 * time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newUpstreamError(resp, body)
+	}
+	return nil
+}
+
+func (c *ClaudeWebClient) buildCompletionBody(cr CompletionRequest) (map[string]any, error) {
+	model, err := c.config.ResolveModel(cr.Model)
+	if err != nil {
+		return nil, err
+	}
+	style, err := resolveStyle(c.config, cr.Style)
+	if err != nil {
+		return nil, err
+	}
+	prompt := cr.Prompt
+	if cr.SystemPrompt != "" {
+		prompt = cr.SystemPrompt + "\n\n" + prompt
+	}
+	parentMessageUUID := cr.ParentMessageUUID
+	if parentMessageUUID == "" {
+		parentMessageUUID = rootMessageUUID
+	}
+	timezone, locale := cr.Timezone, cr.Locale
+	if timezone == "" {
+		timezone = c.config.Timezone
+	}
+	if locale == "" {
+		locale = c.config.Locale
+	}
+	tools := cr.Tools
+	if tools == nil {
+		tools = c.defaultCompletionTools()
+	}
+	attachments := make([]map[string]any, 0, len(cr.Attachments))
+	files := make([]string, 0)
+	for _, att := range cr.Attachments {
+		if att.FileUUID != "" && att.uploadedAsFile() {
+			files = append(files, att.FileUUID)
+			continue
+		}
+		attachments = append(attachments, map[string]any{
+			"file_uuid":         att.FileUUID,
+			"file_name":         att.FileName,
+			"file_type":         att.FileType,
+			"file_size":         att.FileSize,
+			"extracted_content": att.ExtractedText,
+		})
+	}
+	body := map[string]any{
+		"prompt":              prompt,
+		"parent_message_uuid": parentMessageUUID,
+		"timezone":            timezone,
+		"locale":              locale,
+		"rendering_mode":      "messages",
+		"tools":               tools,
+		"attachments":         attachments,
+		"files":               files,
+	}
+	if model.UpstreamID != "" {
+		body["model"] = model.UpstreamID
+	}
+	if style != nil {
+		body["personalized_styles"] = []map[string]any{style.payload()}
+	}
+	return body, nil
+}
+
+func readCompletionStream(ctx context.Context, body io.ReadCloser, events chan<- StreamEvent) {
+	defer close(events)
+	defer body.Close()
+	emit := func(ev StreamEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var eventType string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			eventType = strings.TrimPrefix(line, "event: ")
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		ev := parseStreamEvent(eventType, data)
+		if !emit(ev) || eventType == "message_stop" || eventType == "error" {
+			return
+		}
+		eventType = ""
+	}
+	if err := scanner.Err(); err != nil {
+		emit(StreamEvent{Type: "error", Err: fmt.Errorf("read response failed: %v", err)})
+	}
+}
+
+func completeText(ctx context.Context, client ClaudeClient, cr CompletionRequest, callback StreamCallback) (string, error) {
+	result, err := streamCompletion(ctx, client, cr, func(ev StreamEvent, result *StreamResult) {
+		if ev.IsTextDelta() && callback != nil {
+			callback(result.Text)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+func (c *ClaudeWebClient) defaultCompletionTools() []map[string]any {
+	if c.mcp != nil {
+		if err := c.mcp.EnsureInitialized(); err != nil {
+			log.Printf("MCP initialization failed (continuing without MCP): %v", err)
+		}
+		return c.mcp.GetToolsForRequest()
+	}
+	return []map[string]any{
+		{"type": "web_search_v0", "name": "web_search"},
+		{"type": "artifacts_v0", "name": "artifacts"},
+	}
+}
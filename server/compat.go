@@ -0,0 +1,794 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var errServerBusy = errors.New("server busy, try again later")
+
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = nil
+		if single != "" {
+			*s = StopSequences{single}
+		}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = multiple
+	return nil
+}
+
+type CompatRequest struct {
+	Model     *ModelConfig
+	System    string
+	Prompt    string
+	LastUser  string
+	MaxTokens int
+	Stop      []string
+	User      string
+	Files     []RequestFile
+	Turns     []OpenAIMessage
+	Latest    int
+
+	Tools             []CompatTool
+	ToolChoice        CompatToolChoice
+	ParallelToolCalls bool
+	Format            *StructuredOutput
+
+	stream *compatStream
+}
+
+type compatStream struct {
+	write    func(delta string)
+	streamed string
+}
+
+type CompatResult struct {
+	ConversationID string
+	Dialogue       *CldDialogue
+	Result         *StreamResult
+	Text           string
+	ToolCalls      []CompatToolCall
+	FinishReason   string
+}
+
+type CompatValidationError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *CompatValidationError) Error() string {
+	return fmt.Sprintf("reply failed validation after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *CompatValidationError) Unwrap() error {
+	return e.Err
+}
+
+func compatRepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous reply could not be accepted: %v\n\nReply again with the complete corrected response, following the required format exactly.", err)
+}
+
+func (r CompatRequest) systemPrompt() string {
+	return joinSystemPrompts(LoadSystemPrompt(), r.System)
+}
+
+func (r CompatRequest) PromptTokens() int {
+	return estimateInputTokens(r.systemPrompt(), r.Prompt, r.Files)
+}
+
+func compatOutputTokens(text string, calls []CompatToolCall, thinking string) int {
+	tokens := estimateTokens(text) + estimateTokens(thinking)
+	for _, call := range calls {
+		tokens += estimateTokens(call.Name) + estimateTokens(string(call.Arguments))
+	}
+	return tokens
+}
+
+func (r *CompatResult) PromptTokens() int {
+	return r.Dialogue.InputTokens
+}
+
+func (r *CompatResult) CompletionTokens() int {
+	return r.Dialogue.OutputTokens
+}
+
+func (r *CompatResult) Message() OpenAIMessage {
+	return OpenAIMessage{
+		Role:      "assistant",
+		Content:   r.Text,
+		ToolCalls: openAIToolCalls(r.ToolCalls, false),
+	}
+}
+
+func (r *CompatResult) Usage() OpenAIUsage {
+	return OpenAIUsage{
+		PromptTokens:     r.PromptTokens(),
+		CompletionTokens: r.CompletionTokens(),
+		TotalTokens:      r.PromptTokens() + r.CompletionTokens(),
+	}
+}
+
+func (r CompatRequest) visibleText(text string) string {
+	if r.validator() != nil {
+		return ""
+	}
+	visible, _ := applyStopConditions(text, r.Stop, r.MaxTokens)
+	return holdStopPrefix(visible, r.Stop)
+}
+
+func (s *compatStream) update(visible string) {
+	if len(visible) <= len(s.streamed) || !strings.HasPrefix(visible, s.streamed) {
+		return
+	}
+	s.write(visible[len(s.streamed):])
+	s.streamed = visible
+}
+
+func (s *compatStream) finish(text string) string {
+	if !strings.HasPrefix(text, s.streamed) {
+		return s.streamed
+	}
+	s.update(text)
+	return text
+}
+
+func (r *CompatRequest) enableStructuredOutput(format *ResponseFormat) error {
+	output, err := format.structuredOutput()
+	if err != nil || output == nil {
+		return err
+	}
+	r.Format = output
+	r.System = joinSystemPrompts(r.System, output.prompt())
+	return nil
+}
+
+func (r CompatRequest) validateReply(text string) error {
+	if len(r.Tools) > 0 {
+		if err := r.validateToolReply(text); err != nil {
+			return err
+		}
+		if _, calls, found, _ := parseToolCalls(text); found && len(calls) > 0 {
+			return nil
+		}
+	}
+	if r.Format != nil {
+		return r.Format.validate(text)
+	}
+	return nil
+}
+
+func (r CompatRequest) validator() func(string) error {
+	if len(r.Tools) == 0 && r.Format == nil {
+		return nil
+	}
+	return r.validateReply
+}
+
+func compatTurnText(turn OpenAIMessage) string {
+	switch turn.Role {
+	case "tool":
+		return renderToolResult(turn.ToolCallID, turn.Name, turn.Content)
+	case "assistant":
+		if len(turn.ToolCalls) == 0 {
+			return turn.Content
+		}
+		calls := make([]CompatToolCall, 0, len(turn.ToolCalls))
+		for _, call := range turn.ToolCalls {
+			calls = append(calls, CompatToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: normalizeToolArguments(json.RawMessage(call.Function.Arguments)),
+			})
+		}
+		if strings.TrimSpace(turn.Content) == "" {
+			return renderToolCallsBlock(calls)
+		}
+		return turn.Content + "\n\n" + renderToolCallsBlock(calls)
+	}
+	return turn.Content
+}
+
+func renderCompatPrompt(messages []OpenAIMessage) (CompatRequest, error) {
+	var req CompatRequest
+	var system []string
+	var turns []OpenAIMessage
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system", "developer":
+			if len(msg.Files) > 0 {
+				return req, fmt.Errorf("%s messages cannot contain files", msg.Role)
+			}
+			if strings.TrimSpace(msg.Content) != "" {
+				system = append(system, msg.Content)
+			}
+		case "user", "assistant", "tool":
+			turns = append(turns, msg)
+		default:
+			return req, fmt.Errorf("unsupported message role %q", msg.Role)
+		}
+	}
+	last := len(turns) - 1
+	if last < 0 || (turns[last].Role != "user" && turns[last].Role != "tool") {
+		return req, fmt.Errorf("the last message must have role \"user\" or \"tool\"")
+	}
+	req.System = strings.Join(system, "\n\n")
+	for _, turn := range turns {
+		for i := range turn.Files {
+			nameAttachment(&turn.Files[i], len(req.Files)+1)
+			req.Files = append(req.Files, turn.Files[i])
+		}
+	}
+	start := last
+	for turns[last].Role == "tool" && start > 0 && turns[start-1].Role == "tool" {
+		start--
+	}
+	latest := make([]string, 0, len(turns)-start)
+	for _, turn := range turns[start:] {
+		latest = append(latest, compatTurnText(turn))
+	}
+	req.LastUser = strings.Join(latest, "\n")
+	req.Turns = turns
+	req.Latest = start
+	if start == 0 && turns[last].Role == "user" {
+		req.Prompt = req.LastUser
+		return req, nil
+	}
+	var prompt strings.Builder
+	prompt.WriteString("<conversation_history>\n")
+	for _, turn := range turns[:start] {
+		content := compatTurnText(turn)
+		if turn.Role == "tool" {
+			prompt.WriteString(content + "\n")
+			continue
+		}
+		if len(turn.Files) > 0 {
+			names := make([]string, 0, len(turn.Files))
+			for _, file := range turn.Files {
+				names = append(names, file.Name)
+			}
+			content += "\n[Attached files: " + strings.Join(names, ", ") + "]"
+		}
+		fmt.Fprintf(&prompt, "<%s>\n%s\n</%s>\n", turn.Role, content, turn.Role)
+	}
+	prompt.WriteString("</conversation_history>\n\n")
+	if turns[last].Role == "tool" {
+		prompt.WriteString("Continue the conversation above. Your tool calls were executed and returned these results:\n\n")
+	} else {
+		prompt.WriteString("Continue the conversation above by replying to the latest user message:\n\n")
+	}
+	prompt.WriteString(req.LastUser)
+	req.Prompt = prompt.String()
+	return req, nil
+}
+
+func firstStopSequence(text string, stop []string) (int, string) {
+	cut := -1
+	matched := ""
+	for _, sequence := range stop {
+		if sequence == "" {
+			continue
+		}
+		if i := strings.Index(text, sequence); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+			matched = sequence
+		}
+	}
+	return cut, matched
+}
+
+func holdStopPrefix(text string, stop []string) string {
+	cut := len(text)
+	for _, sequence := range stop {
+		for k := len(sequence) - 1; k > 0; k-- {
+			if strings.HasSuffix(text, sequence[:k]) {
+				cut = min(cut, len(text)-k)
+				break
+			}
+		}
+	}
+	return text[:cut]
+}
+
+func applyStopConditions(text string, stop []string, maxTokens int) (string, string) {
+	finishReason := "stop"
+	if cut, _ := firstStopSequence(text, stop); cut >= 0 {
+		text = text[:cut]
+	}
+	if maxTokens > 0 {
+		if truncated, ok := truncateToTokens(text, maxTokens); ok {
+			text = truncated
+			finishReason = "length"
+		}
+	}
+	return text, finishReason
+}
+
+func joinSystemPrompts(prompts ...string) string {
+	parts := make([]string, 0, len(prompts))
+	for _, prompt := range prompts {
+		if strings.TrimSpace(prompt) != "" {
+			parts = append(parts, prompt)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (h *Handler) streamRepairedCompletion(ctx context.Context, completion CompletionRequest, validate func(string) error, onEvent func(StreamEvent, *StreamResult)) (*StreamResult, *StreamResult, error) {
+	result, err := streamCompletion(ctx, h.client, completion, onEvent)
+	first := result
+	for attempt := 1; err == nil && validate != nil; attempt++ {
+		validationErr := validate(result.Text)
+		if validationErr == nil {
+			break
+		}
+		if attempt > h.config.CompatRepairAttempts {
+			err = &CompatValidationError{Attempts: attempt, Err: validationErr}
+			break
+		}
+		log.Printf("⚠ 回复校验失败，发送修正请求 (%d/%d): %v", attempt, h.config.CompatRepairAttempts, validationErr)
+		completion.ParentMessageUUID = result.MessageUUID
+		if completion.ParentMessageUUID == "" {
+			if completion.ParentMessageUUID, err = h.client.GetLastMessageUUID(ctx, completion.ConversationID); err != nil {
+				break
+			}
+		}
+		completion.Prompt = compatRepairPrompt(validationErr)
+		completion.SystemPrompt = ""
+		completion.Attachments = nil
+		result, err = streamCompletion(ctx, h.client, completion, nil)
+	}
+	return result, first, err
+}
+
+func applyRepairedResult(dialogue *CldDialogue, first, result *StreamResult) {
+	dialogue.ApplyStreamResult(result)
+	if result != first && first != nil && first.ParentMessageUUID != "" {
+		userMessageUUID := first.ParentMessageUUID
+		dialogue.UserMessageUUID = &userMessageUUID
+	}
+}
+
+func compatAnonymousDevice(access *APIAccess) string {
+	if access.Key != nil {
+		return fmt.Sprintf("api-key:%d", access.Key.ID)
+	}
+	return "anonymous"
+}
+
+func (h *Handler) runCompatCompletion(c *gin.Context, req CompatRequest, callback StreamCallback) (*CompatResult, error) {
+	devicePassword := c.GetHeader("X-Device-ID")
+	if devicePassword == "" {
+		devicePassword = req.User
+	}
+	anonymous := devicePassword == ""
+	if anonymous {
+		devicePassword = compatAnonymousDevice(requestAPIAccess(c))
+	}
+	platform := c.GetHeader("X-Platform")
+	if platform == "" {
+		platform = "windows"
+	}
+	device, err := h.db.GetOrCreateDevice(devicePassword, platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device: %v", err)
+	}
+	if err := deviceBan(h.db, requestAPIAccess(c), device); err != nil {
+		return nil, err
+	}
+	quotaDevice := device
+	if anonymous {
+		quotaDevice = nil
+	}
+	if status := quotaExceeded(h.config, h.db, requestAPIAccess(c), quotaDevice); status != nil {
+		c.Header("Retry-After", strconv.Itoa(status.RetryAfter))
+		return nil, &QuotaExceededError{Status: status}
+	}
+	timezone, locale := requestLocale(c)
+	if err := validateLocale(timezone, locale); err != nil {
+		return nil, err
+	}
+	timezone, locale = resolveLocale(h.config, device, timezone, locale)
+	ctx := c.Request.Context()
+	prefix, conv, cached := h.lookupCompatConversation(device, req)
+	systemPrompt := req.systemPrompt()
+	sentSystemPrompt := systemPrompt
+	var conversationID, parentMessageUUID string
+	if conv != nil {
+		conversationID = conv.UID
+		parentMessageUUID = cached.MessageUUID
+		req.Prompt = req.LastUser
+		req.Files = req.latestFiles()
+		if cached.SystemPromptHash == systemPromptHash(systemPrompt) {
+			sentSystemPrompt = ""
+		}
+		log.Printf("✓ 兼容接口复用上游对话: %s", conversationID)
+	} else {
+		conversationID, err = h.client.CreateConversation(ctx, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create conversation: %v", err)
+		}
+		conv, err = h.db.GetOrCreateConversation(device.ID, conversationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create conversation: %v", err)
+		}
+		parentMessageUUID = rootMessageUUID
+	}
+	dialogueOrder, _ := h.db.GetNextDialogueOrder(conv.ID)
+	dialogue := &CldDialogue{
+		UID:               uuid.New().String(),
+		ConversationID:    conv.ID,
+		Order:             dialogueOrder,
+		ParentID:          h.db.GetParentDialogueID(conv.ID, parentMessageUUID),
+		UserMessage:       req.LastUser,
+		InputTokens:       estimateInputTokens(sentSystemPrompt, req.Prompt, req.Files),
+		CreateTime:        time.Now(),
+		Status:            "processing",
+		PromptID:          h.db.GetCurrentPromptID(),
+		ParentMessageUUID: &parentMessageUUID,
+		APIKeyID:          requestAPIAccess(c).keyID(),
+	}
+	h.db.CreateDialogue(dialogue)
+	h.db.IncrementProcessing()
+	requestID := uuid.New().String()
+	processingMutex.Lock()
+	processingRequests[requestID] = &ProcessingRequest{
+		ID:          requestID,
+		SubmitTime:  time.Now(),
+		InputTokens: dialogue.InputTokens,
+		UserMessage: req.LastUser,
+	}
+	processingMutex.Unlock()
+	select {
+	case h.semaphore <- struct{}{}:
+	default:
+		h.db.DecrementProcessing()
+		processingMutex.Lock()
+		delete(processingRequests, requestID)
+		processingMutex.Unlock()
+		dialogue.Status = "send_failed"
+		h.db.UpdateDialogue(dialogue)
+		h.db.IncrementFailed()
+		return nil, errServerBusy
+	}
+	defer func() { <-h.semaphore }()
+	h.db.DecrementProcessing()
+	processingMutex.Lock()
+	delete(processingRequests, requestID)
+	processingMutex.Unlock()
+	ctx, finishGeneration := h.dialogueManager.StartGeneration(ctx, conversationID, dialogue.ID, conv.DeviceID)
+	defer finishGeneration()
+	requestTime := time.Now()
+	dialogue.RequestTime = &requestTime
+	attachments, err := h.uploadAttachments(ctx, conversationID, req.Files)
+	if err != nil {
+		dialogue.Status = "send_failed"
+		h.db.UpdateDialogue(dialogue)
+		h.db.IncrementFailed()
+		return nil, err
+	}
+	if len(attachments) > 0 {
+		dialogue.Attachments, _ = json.Marshal(attachments)
+	}
+	completion := CompletionRequest{
+		ConversationID:    conversationID,
+		Prompt:            req.Prompt,
+		ParentMessageUUID: parentMessageUUID,
+		Model:             req.Model.ID,
+		Timezone:          timezone,
+		Locale:            locale,
+		SystemPrompt:      sentSystemPrompt,
+		Attachments:       attachments,
+		MaxTokens:         req.MaxTokens,
+		Stop:              req.Stop,
+	}
+	result, first, err := h.streamRepairedCompletion(ctx, completion, req.validator(), func(ev StreamEvent, result *StreamResult) {
+		if !ev.IsTextDelta() {
+			return
+		}
+		if callback != nil {
+			callback(result.Text)
+		}
+		if req.stream != nil {
+			req.stream.update(req.visibleText(result.Text))
+		}
+	})
+	finishTime := time.Now()
+	dialogue.FinishTime = &finishTime
+	duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
+	dialogue.Duration = &duration
+	applyRepairedResult(dialogue, first, result)
+	if errors.Is(err, context.Canceled) {
+		h.saveCancelledDialogue(dialogue, conversationID, result)
+		return nil, err
+	}
+	if err != nil {
+		dialogue.Status = "send_failed"
+		h.db.UpdateDialogue(dialogue)
+		h.db.IncrementFailed()
+		LogExchange(req.LastUser, err.Error(), true)
+		if parentMessageUUID != rootMessageUUID {
+			h.db.DeleteCompatCache(prefix)
+		}
+		return nil, err
+	}
+	text, finishReason := applyStopConditions(result.Text, req.Stop, req.MaxTokens)
+	if result.FinishReason == "length" {
+		finishReason = "length"
+	}
+	stored := text
+	var toolCalls []CompatToolCall
+	if len(req.Tools) > 0 {
+		if content, calls, found, _ := parseToolCalls(result.Text); found && len(calls) > 0 {
+			text, toolCalls, finishReason = content, calls, "tool_calls"
+			stored = result.Text
+		}
+	}
+	if req.Format != nil && len(toolCalls) == 0 {
+		if body, err := req.Format.parse(text); err == nil {
+			text, stored = body, body
+		}
+	}
+	if req.stream != nil {
+		if visible := req.stream.finish(text); visible != text {
+			text, stored = visible, visible
+		}
+	}
+	dialogue.OutputTokens = compatOutputTokens(text, toolCalls, result.Thinking)
+	dialogue.FinishReason = &finishReason
+	dialogue.AssistantMessage = &stored
+	dialogue.Status = "done"
+	h.db.UpdateDialogue(dialogue)
+	h.db.IncrementCompleted()
+	LogExchange(req.LastUser, stored, false)
+	compatResult := &CompatResult{
+		ConversationID: conversationID,
+		Dialogue:       dialogue,
+		Result:         result,
+		Text:           text,
+		ToolCalls:      toolCalls,
+		FinishReason:   finishReason,
+	}
+	h.advanceConversation(ctx, conversationID, dialogue, result)
+	h.rememberCompatConversation(device, conv, req, systemPrompt, compatResult)
+	return compatResult, nil
+}
+
+func openAIError(c *gin.Context, status int, errType, message string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    nil,
+		},
+	})
+}
+
+func compatErrorStatus(err error) int {
+	var unknownModel *UnknownModelError
+	var validationErr *CompatValidationError
+	var quotaErr *QuotaExceededError
+	var bannedErr *DeviceBannedError
+	switch {
+	case errors.As(err, &unknownModel):
+		return http.StatusNotFound
+	case errors.As(err, &bannedErr):
+		return http.StatusForbidden
+	case errors.As(err, &quotaErr):
+		return http.StatusTooManyRequests
+	case errors.As(err, &validationErr):
+		return http.StatusBadGateway
+	case errors.Is(err, errServerBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		return 499
+	}
+	return http.StatusInternalServerError
+}
+
+func compatErrorType(err error) string {
+	var unknownModel *UnknownModelError
+	var validationErr *CompatValidationError
+	var quotaErr *QuotaExceededError
+	var bannedErr *DeviceBannedError
+	switch {
+	case errors.As(err, &unknownModel):
+		return "invalid_request_error"
+	case errors.As(err, &bannedErr):
+		return "permission_error"
+	case errors.As(err, &validationErr):
+		return "validation_error"
+	case errors.As(err, &quotaErr):
+		return "rate_limit_error"
+	}
+	return "api_error"
+}
+
+func (h *Handler) ChatCompletion(c *gin.Context) {
+	var req OpenAIChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "Invalid request: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "messages cannot be empty")
+		return
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2) {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "temperature must be between 0 and 2")
+		return
+	}
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens == 0 {
+		maxTokens = req.MaxTokens
+	}
+	if maxTokens < 0 {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "max_tokens must be a positive integer")
+		return
+	}
+	model, err := h.config.ResolveModel(req.Model)
+	if err != nil {
+		openAIError(c, http.StatusNotFound, "invalid_request_error", err.Error())
+		return
+	}
+	compatReq, err := renderCompatPrompt(req.Messages)
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if err := checkModelFiles(model, compatReq.Files); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	compatReq.Model = model
+	compatReq.MaxTokens = maxTokens
+	compatReq.Stop = req.Stop
+	compatReq.User = req.User
+	toolChoice, err := openAIToolChoice(req.ToolChoice)
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	tools := make([]CompatTool, 0, len(req.Tools))
+	for i, tool := range req.Tools {
+		if tool.Type != "" && tool.Type != "function" {
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("tools.%d: unsupported tool type %q", i, tool.Type))
+			return
+		}
+		tools = append(tools, CompatTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+	if err := compatReq.enableTools(tools, toolChoice, req.ParallelToolCalls == nil || *req.ParallelToolCalls); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if err := compatReq.enableStructuredOutput(req.ResponseFormat); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
+		log.Printf("[Usage Limit] Chat completion blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
+		openAIError(c, http.StatusTooManyRequests, "rate_limit_error", fmt.Sprintf("Usage limit exceeded (%s), resets at %s", blockReason, blockResetTime))
+		return
+	}
+	if req.Stream {
+		h.streamChatCompletion(c, compatReq, req.StreamOptions != nil && req.StreamOptions.IncludeUsage)
+		return
+	}
+	result, err := h.runCompatCompletion(c, compatReq, nil)
+	if err != nil {
+		openAIError(c, compatErrorStatus(err), compatErrorType(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, OpenAIResponse{
+		ID:      "chatcmpl-" + result.Dialogue.UID,
+		Object:  "chat.completion",
+		Created: result.Dialogue.CreateTime.Unix(),
+		Model:   model.ID,
+		Choices: []OpenAIResponseChoice{
+			{
+				Index:        0,
+				Message:      result.Message(),
+				FinishReason: result.FinishReason,
+			},
+		},
+		Usage: result.Usage(),
+	})
+}
+
+func writeSSEData(w http.ResponseWriter, flusher http.Flusher, data any) {
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(w, "data: %s\n\n", jsonData)
+	flusher.Flush()
+}
+
+func (h *Handler) streamChatCompletion(c *gin.Context, req CompatRequest, includeUsage bool) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		openAIError(c, http.StatusInternalServerError, "api_error", "Streaming not supported")
+		return
+	}
+	id := "chatcmpl-" + uuid.New().String()
+	created := time.Now().Unix()
+	chunk := func(delta OpenAIDelta, finishReason *string) OpenAIChunk {
+		return OpenAIChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model.ID,
+			Choices: []OpenAIChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		}
+	}
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		writeSSEData(c.Writer, flusher, chunk(OpenAIDelta{Role: "assistant"}, nil))
+	}
+	req.stream = &compatStream{write: func(delta string) {
+		start()
+		writeSSEData(c.Writer, flusher, chunk(OpenAIDelta{Content: delta}, nil))
+	}}
+	result, err := h.runCompatCompletion(c, req, nil)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if !started {
+			openAIError(c, compatErrorStatus(err), compatErrorType(err), err.Error())
+			return
+		}
+		writeSSEData(c.Writer, flusher, gin.H{"error": gin.H{"message": err.Error(), "type": compatErrorType(err)}})
+		fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+		flusher.Flush()
+		return
+	}
+	start()
+	for _, toolCall := range openAIToolCalls(result.ToolCalls, true) {
+		writeSSEData(c.Writer, flusher, chunk(OpenAIDelta{ToolCalls: []OpenAIToolCall{toolCall}}, nil))
+	}
+	finishReason := result.FinishReason
+	writeSSEData(c.Writer, flusher, chunk(OpenAIDelta{}, &finishReason))
+	if includeUsage {
+		usage := result.Usage()
+		writeSSEData(c.Writer, flusher, OpenAIChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model.ID,
+			Choices: []OpenAIChunkChoice{},
+			Usage:   &usage,
+		})
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	flusher.Flush()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log"
+	"strings"
+	"time"
+)
+
+func writeFingerprintField(h hash.Hash, value string) {
+	fmt.Fprintf(h, "%d:%s;", len(value), value)
+}
+
+func writeFingerprintTurn(h hash.Hash, turn OpenAIMessage) {
+	writeFingerprintField(h, turn.Role)
+	writeFingerprintField(h, strings.TrimSpace(turn.Content))
+	for _, call := range turn.ToolCalls {
+		var arguments bytes.Buffer
+		if err := json.Compact(&arguments, normalizeToolArguments(json.RawMessage(call.Function.Arguments))); err != nil {
+			arguments.WriteString(call.Function.Arguments)
+		}
+		writeFingerprintField(h, call.Function.Name)
+		writeFingerprintField(h, arguments.String())
+	}
+	for _, file := range turn.Files {
+		sum := sha256.Sum256([]byte(file.Content))
+		writeFingerprintField(h, file.Name)
+		writeFingerprintField(h, hex.EncodeToString(sum[:]))
+	}
+}
+
+func compatFingerprint(deviceID int, model, system string, turns []OpenAIMessage) string {
+	h := sha256.New()
+	writeFingerprintField(h, fmt.Sprint(deviceID))
+	writeFingerprintField(h, model)
+	writeFingerprintField(h, system)
+	for _, turn := range turns {
+		writeFingerprintTurn(h, turn)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r CompatRequest) prefixFingerprint(deviceID int) string {
+	if r.Latest == 0 {
+		return ""
+	}
+	return compatFingerprint(deviceID, r.Model.ID, r.System, r.Turns[:r.Latest])
+}
+
+func (r CompatRequest) replyFingerprint(deviceID int, result *CompatResult) string {
+	turns := append(append([]OpenAIMessage(nil), r.Turns...), result.Message())
+	return compatFingerprint(deviceID, r.Model.ID, r.System, turns)
+}
+
+func (r CompatRequest) latestFiles() []RequestFile {
+	var files []RequestFile
+	for _, turn := range r.Turns[r.Latest:] {
+		files = append(files, turn.Files...)
+	}
+	return files
+}
+
+func systemPromptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) lookupCompatConversation(device *CldDevice, req CompatRequest) (string, *CldConversation, *CldCompatCache) {
+	if h.config.CompatCacheTTLMinutes < 0 {
+		return "", nil, nil
+	}
+	fingerprint := req.prefixFingerprint(device.ID)
+	if fingerprint == "" {
+		return "", nil, nil
+	}
+	entry, err := h.db.GetCompatCache(fingerprint)
+	if err != nil {
+		return fingerprint, nil, nil
+	}
+	conv, err := h.db.GetConversation(entry.ConversationID)
+	if err != nil || conv.DeviceID != device.ID {
+		return fingerprint, nil, nil
+	}
+	return fingerprint, conv, entry
+}
+
+func (h *Handler) rememberCompatConversation(device *CldDevice, conv *CldConversation, req CompatRequest, systemPrompt string, result *CompatResult) {
+	if h.config.CompatCacheTTLMinutes < 0 || result.Result == nil || result.Result.MessageUUID == "" {
+		return
+	}
+	now := time.Now()
+	entry := &CldCompatCache{
+		Fingerprint:      req.replyFingerprint(device.ID, result),
+		DeviceID:         device.ID,
+		ConversationID:   conv.ID,
+		MessageUUID:      result.Result.MessageUUID,
+		SystemPromptHash: systemPromptHash(systemPrompt),
+		CreateTime:       now,
+		ExpireTime:       now.Add(time.Duration(h.config.CompatCacheTTLMinutes) * time.Minute),
+	}
+	if err := h.db.SaveCompatCache(entry, h.config.CompatCacheMaxEntries); err != nil {
+		log.Printf("⚠ 保存兼容接口会话指纹失败: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+func TestChatCompletion(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("Hello there, how can I help?"))
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-chat", chatRequest("Hi", nil), http.StatusOK, &resp)
+	if len(resp.Choices) != 1 {
+		t.Fatalf("got %d choices, want 1", len(resp.Choices))
+	}
+	choice := resp.Choices[0]
+	if choice.Message.Content != "Hello there, how can I help?" || choice.FinishReason != "stop" {
+		t.Fatalf("got %q (%s), want the upstream reply with finish_reason stop", choice.Message.Content, choice.FinishReason)
+	}
+	if resp.Usage.PromptTokens == 0 || resp.Usage.CompletionTokens == 0 {
+		t.Fatalf("usage not reported: %+v", resp.Usage)
+	}
+	requests := env.fake.Requests(fakeclaude.RouteCompletion)
+	if len(requests) != 1 || !strings.Contains(completionPrompt(t, requests[0]), "Hi") {
+		t.Fatalf("upstream did not receive the prompt: %+v", requests)
+	}
+}
+
+func TestCompatUnknownModel(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var openAI, anthropic struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-unknown", chatRequest("Hello", map[string]any{"model": "no-such-model"}), http.StatusNotFound, &openAI)
+	if openAI.Error.Type != "invalid_request_error" {
+		t.Fatalf("got OpenAI error type %q, want invalid_request_error", openAI.Error.Type)
+	}
+	env.doJSON(http.MethodPost, "/v1/messages", "device-unknown", map[string]any{
+		"model":      "no-such-model",
+		"max_tokens": 100,
+		"messages":   []map[string]any{{"role": "user", "content": "Hello"}},
+	}, http.StatusNotFound, &anthropic)
+	if anthropic.Error.Type != "not_found_error" {
+		t.Fatalf("got Anthropic error type %q, want not_found_error", anthropic.Error.Type)
+	}
+	env.doJSON(http.MethodPost, "/api/chat", "device-unknown", map[string]any{
+		"model":    "no-such-model",
+		"stream":   false,
+		"messages": []map[string]any{{"role": "user", "content": "Hello"}},
+	}, http.StatusNotFound, nil)
+	env.doJSON(http.MethodPost, "/api/generate", "device-unknown", map[string]any{"model": "no-such-model", "prompt": "Hello", "stream": false}, http.StatusNotFound, nil)
+	env.doJSON(http.MethodPost, "/api/show", "device-unknown", map[string]any{"model": "no-such-model"}, http.StatusNotFound, nil)
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 0 {
+		t.Fatalf("got %d upstream completions, want 0", got)
+	}
+}
+
+func TestChatCompletionStream(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.ChunkedReply("Hel", "lo ", "there"))
+	resp := env.do(http.MethodPost, "/v1/chat/completions", "device-stream", chatRequest("Hi", map[string]any{
+		"stream":         true,
+		"stream_options": map[string]any{"include_usage": true},
+	}))
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("got status %d (%s), want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := readSSEData(t, resp.Body)
+	if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+		t.Fatalf("stream did not end with [DONE]: %v", events)
+	}
+	var content strings.Builder
+	var finishReason string
+	var usage *OpenAIUsage
+	for _, data := range events[:len(events)-1] {
+		var chunk OpenAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if content.String() != "Hello there" || finishReason != "stop" {
+		t.Fatalf("got %q (%s), want %q (stop)", content.String(), finishReason, "Hello there")
+	}
+	if usage == nil || usage.TotalTokens == 0 {
+		t.Fatalf("usage chunk missing")
+	}
+}
+
+func TestChatCompletionStreamHoldsStopSequence(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.ChunkedReply("Hello EN", "D world"))
+	resp := env.do(http.MethodPost, "/v1/chat/completions", "device-stream-stop", chatRequest("Hi", map[string]any{
+		"stream": true,
+		"stop":   "END",
+	}))
+	var content strings.Builder
+	for _, data := range readSSEData(t, resp.Body) {
+		var chunk OpenAIChunk
+		if json.Unmarshal([]byte(data), &chunk) == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if content.String() != "Hello " {
+		t.Fatalf("streamed %q, want %q", content.String(), "Hello ")
+	}
+}
+
+func TestChatCompletionStopSequence(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.ChunkedReply("one two ", "END three"))
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-stop", chatRequest("Count", map[string]any{
+		"stop": []string{"END"},
+	}), http.StatusOK, &resp)
+	if got := resp.Choices[0]; got.Message.Content != "one two " || got.FinishReason != "stop" {
+		t.Fatalf("got %q (%s), want %q (stop)", got.Message.Content, got.FinishReason, "one two ")
+	}
+	if len(env.fake.Requests(fakeclaude.RouteStopResponse)) != 1 {
+		t.Fatalf("upstream generation was not stopped at the stop sequence")
+	}
+}
+
+func TestChatCompletionMaxTokens(t *testing.T) {
+	env := newTestEnv(t, nil)
+	reply := "alpha beta gamma delta epsilon zeta eta theta iota kappa"
+	env.fake.QueueCompletion(fakeclaude.TextReply(reply))
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-length", chatRequest("Letters", map[string]any{
+		"max_tokens": 4,
+	}), http.StatusOK, &resp)
+	got := resp.Choices[0]
+	if got.FinishReason != "length" || got.Message.Content == "" || !strings.HasPrefix(reply, got.Message.Content) || len(got.Message.Content) >= len(reply) {
+		t.Fatalf("got %q (%s), want a truncated prefix with finish_reason length", got.Message.Content, got.FinishReason)
+	}
+	if tokens := estimateTokens(got.Message.Content); tokens > 4 {
+		t.Fatalf("truncated reply has %d tokens, want at most 4", tokens)
+	}
+}
+
+func TestChatCompletionReusesConversationAfterTruncatedReply(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.ChunkedReply("alpha beta", "delta gamma"))
+	resp := env.do(http.MethodPost, "/v1/chat/completions", "device-reuse", chatRequest("Letters", map[string]any{
+		"stream":     true,
+		"max_tokens": 2,
+	}))
+	var content strings.Builder
+	finishReason := ""
+	for _, data := range readSSEData(t, resp.Body) {
+		var chunk OpenAIChunk
+		if json.Unmarshal([]byte(data), &chunk) == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			if chunk.Choices[0].FinishReason != nil {
+				finishReason = *chunk.Choices[0].FinishReason
+			}
+		}
+	}
+	if finishReason != "length" || !strings.HasPrefix("alpha betadelta gamma", content.String()) {
+		t.Fatalf("streamed %q (%s), want a truncated prefix with finish_reason length", content.String(), finishReason)
+	}
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-reuse", map[string]any{
+		"messages": []map[string]any{
+			{"role": "user", "content": "Letters"},
+			{"role": "assistant", "content": content.String() + "\n"},
+			{"role": "user", "content": "More"},
+		},
+	}, http.StatusOK, nil)
+	requests := env.fake.Requests(fakeclaude.RouteCompletion)
+	if len(requests) != 2 || conversationFromPath(requests[1].Path) != conversationFromPath(requests[0].Path) {
+		t.Fatalf("follow-up did not reuse the upstream conversation: %+v", requests)
+	}
+	if prompt := completionPrompt(t, requests[1]); strings.Contains(prompt, "Letters") {
+		t.Fatalf("follow-up resent the history: %q", prompt)
+	}
+}
+
+func TestChatCompletionSendsSystemPromptOnlyWhenNeeded(t *testing.T) {
+	env := newTestEnv(t, nil)
+	if err := os.WriteFile("src/prompts.txt", []byte("Server rules v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove("src/prompts.txt") })
+	messages := []map[string]any{
+		{"role": "system", "content": "Be terse."},
+		{"role": "user", "content": "Hi"},
+	}
+	send := func(reply string) string {
+		t.Helper()
+		env.fake.QueueCompletion(fakeclaude.TextReply(reply))
+		env.doJSON(http.MethodPost, "/v1/chat/completions", "device-system", map[string]any{"messages": messages}, http.StatusOK, nil)
+		messages = append(messages, map[string]any{"role": "assistant", "content": reply}, map[string]any{"role": "user", "content": "Again"})
+		requests := env.fake.Requests(fakeclaude.RouteCompletion)
+		if conversationFromPath(requests[len(requests)-1].Path) != conversationFromPath(requests[0].Path) {
+			t.Fatalf("request %d did not reuse the upstream conversation", len(requests))
+		}
+		return completionPrompt(t, requests[len(requests)-1])
+	}
+	if prompt := send("One"); !strings.Contains(prompt, "Server rules v1") || !strings.Contains(prompt, "Be terse.") {
+		t.Fatalf("first turn is missing the system prompt: %q", prompt)
+	}
+	if prompt := send("Two"); strings.Contains(prompt, "Server rules") || strings.Contains(prompt, "Be terse.") {
+		t.Fatalf("reused turn resent the system prompt: %q", prompt)
+	}
+	if err := os.WriteFile("src/prompts.txt", []byte("Server rules v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := send("Three"); !strings.Contains(prompt, "Server rules v2") {
+		t.Fatalf("changed system prompt was not sent: %q", prompt)
+	}
+	if prompt := send("Four"); strings.Contains(prompt, "Server rules") {
+		t.Fatalf("unchanged system prompt was resent: %q", prompt)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var resp OpenAIModelList
+	env.doJSON(http.MethodGet, "/v1/models", "", nil, http.StatusOK, &resp)
+	if resp.Object != "list" || len(resp.Data) != len(env.cfg.Models) {
+		t.Fatalf("got %+v, want every configured model", resp)
+	}
+	for i, model := range resp.Data {
+		if model.ID != env.cfg.Models[i].ID || model.Object != "model" {
+			t.Fatalf("model %d is %+v, want %s", i, model, env.cfg.Models[i].ID)
+		}
+	}
+}
@@ -13,16 +13,24 @@ import (
 
 type Config struct {
 	Tokens            TokensConfig         `yaml:"tokens"`
+	Accounts          []AccountConfig      `yaml:"accounts"`
 	Debug             bool                 `yaml:"debug"`
 	PrivateMode       bool                 `yaml:"private_mode"`
 	ThreadNum         int                  `yaml:"thread_num"`
 	ServerPort        int                  `yaml:"server_port"`
 	MinClientVersion  string               `yaml:"min_client_version"`
+	RequireAPIKey     *bool                `yaml:"require_api_key"`
+	AdminSessionSecret string              `yaml:"admin_session_secret"`
+	AdminSessionHours int                  `yaml:"admin_session_hours"`
+	AllowedOrigins    []string             `yaml:"allowed_origins"`
 	APIEndpoint       string               `yaml:"api_endpoint"`
+	UpstreamBaseURL   string               `yaml:"upstream_base_url"`
 	Proxy             ProxyConfig          `yaml:"proxy"`
 	MaxTPM            int                  `yaml:"max_tpm"`
 	MaxRPM            int                  `yaml:"max_rpm"`
 	MaxRPD            int                  `yaml:"max_rpd"`
+	QuotaTiers        []QuotaTierConfig    `yaml:"quota_tiers"`
+	DefaultQuotaTier  string               `yaml:"default_quota_tier"`
 	RequestIntervalMS int                  `yaml:"request_interval_ms"`
 	UsageLimitFiveHour int                 `yaml:"usage_limit_five_hour"`
 	UsageLimitSevenDay int                 `yaml:"usage_limit_seven_day"`
@@ -34,6 +42,11 @@ type Config struct {
 	Models            []ModelConfig        `yaml:"models"`
 	DefaultModel      string               `yaml:"default_model"`
 	DefaultStyle      string               `yaml:"default_style"`
+	Timezone          string               `yaml:"timezone"`
+	Locale            string               `yaml:"locale"`
+	CompatRepairAttempts int               `yaml:"compat_repair_attempts"`
+	CompatCacheTTLMinutes int              `yaml:"compat_cache_ttl_minutes"`
+	CompatCacheMaxEntries int              `yaml:"compat_cache_max_entries"`
 	Styles            []StyleConfig        `yaml:"styles"`
 	MCPConnectors     []MCPConnectorConfig `yaml:"mcp_connectors"`
 	OrganizationID    string               `yaml:"organization_id,omitempty"`
@@ -54,6 +67,12 @@ type TokensConfig struct {
 	IntercomSessionID string `yaml:"intercom_session_id,omitempty"`
 }
 
+type AccountConfig struct {
+	Name   string       `yaml:"name"`
+	Tokens TokensConfig `yaml:",inline"`
+	Cookie string       `yaml:"cookie,omitempty"`
+}
+
 type ProxyConfig struct {
 	Enable bool   `yaml:"enable"`
 	HTTP   string `yaml:"http"`
@@ -61,10 +80,15 @@ type ProxyConfig struct {
 }
 
 type ModelConfig struct {
-	ID      string `yaml:"id" json:"id"`
-	Object  string `yaml:"object" json:"object"`
-	Created int64  `json:"created"`
-	OwnedBy string `json:"owned_by"`
+	ID           string   `yaml:"id" json:"id"`
+	Object       string   `yaml:"object" json:"object"`
+	Created      int64    `json:"created"`
+	OwnedBy      string   `json:"owned_by"`
+	UpstreamID   string   `yaml:"upstream_id" json:"upstream_id,omitempty"`
+	DisplayName  string   `yaml:"display_name" json:"display_name"`
+	Aliases      []string `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	Capabilities []string `yaml:"capabilities" json:"capabilities"`
+	UsageBucket  string   `yaml:"usage_bucket" json:"usage_bucket"`
 }
 
 type StyleConfig struct {
@@ -74,6 +98,14 @@ type StyleConfig struct {
 	Prompt  string `yaml:"prompt"`
 }
 
+type QuotaTierConfig struct {
+	Name          string `yaml:"name" json:"name"`
+	RPM           int    `yaml:"rpm" json:"rpm"`
+	RPD           int    `yaml:"rpd" json:"rpd"`
+	TokensPerDay  int    `yaml:"tokens_per_day" json:"tokens_per_day"`
+	FiveHourShare int    `yaml:"five_hour_share" json:"five_hour_share"`
+}
+
 type MCPConnectorConfig struct {
 	Name    string `yaml:"name"`
 	UUID    string `yaml:"uuid"`
@@ -127,8 +159,8 @@ func createDefaultConfig(path string) (*Config, error) {
 		DBPassword:     "xdfrt123",
 		DBName:         "claude_db",
 		Models: []ModelConfig{
-			{ID: "claude-sonnet-4.5", Object: "model"},
-			{ID: "claude-opus-4.1", Object: "model"},
+			{ID: "sonnet-4.5", Object: "model"},
+			{ID: "opus-4.1", Object: "model"},
 		},
 	}
 	data, _ := yaml.Marshal(template)
@@ -150,6 +182,40 @@ func createDefaultConfig(path string) (*Config, error) {
 }
 
 func (c *Config) Validate() error {
+	if err := c.validateModels(); err != nil {
+		return err
+	}
+	if err := c.validateStyles(); err != nil {
+		return err
+	}
+	if err := c.validateQuotaTiers(); err != nil {
+		return err
+	}
+	if err := c.validateAllowedOrigins(); err != nil {
+		return err
+	}
+	if err := validateLocale(c.Timezone, c.Locale); err != nil {
+		return fmt.Errorf("配置错误: %v", err)
+	}
+	if len(c.Accounts) > 0 {
+		names := make(map[string]bool)
+		for i, account := range c.Accounts {
+			if account.Name == "" {
+				return fmt.Errorf("accounts[%d] 缺少 name", i)
+			}
+			if names[account.Name] {
+				return fmt.Errorf("账号名称重复: %s", account.Name)
+			}
+			names[account.Name] = true
+			if account.Tokens.OrganizationID == "" {
+				return fmt.Errorf("账号 %s 缺少 organization_id", account.Name)
+			}
+			if account.Tokens.SessionKey == "" && account.Cookie == "" {
+				return fmt.Errorf("账号 %s 缺少 session_key", account.Name)
+			}
+		}
+		return nil
+	}
 	orgID := c.GetOrganizationID()
 	if orgID == "你的组织ID" || orgID == "" {
 		return fmt.Errorf("请在配置文件中填入正确的 organization_id\n" +
@@ -199,13 +265,70 @@ func (c *Config) SetDefaults() {
 	if c.DBName == "" {
 		c.DBName = "claude_db"
 	}
+	if c.UpstreamBaseURL == "" {
+		c.UpstreamBaseURL = "https://claude.ai"
+	}
+	if c.Timezone == "" {
+		c.Timezone = defaultTimezone
+	}
+	if c.Locale == "" {
+		c.Locale = defaultLocale
+	}
+	if c.CompatRepairAttempts == 0 {
+		c.CompatRepairAttempts = 2
+	}
+	if c.CompatCacheTTLMinutes == 0 {
+		c.CompatCacheTTLMinutes = 60
+	}
+	if c.CompatCacheMaxEntries <= 0 {
+		c.CompatCacheMaxEntries = 1000
+	}
+	if c.AdminSessionHours <= 0 {
+		c.AdminSessionHours = 24
+	}
+	if c.AllowedOrigins == nil {
+		c.AllowedOrigins = []string{"*"}
+	}
+	c.applyModelDefaults()
 }
 
 func (c *Config) GetServerAddr() string {
 	return fmt.Sprintf(":%d", c.ServerPort)
 }
 
+func (c *Config) UpstreamURL(format string, args ...any) string {
+	return strings.TrimRight(c.UpstreamBaseURL, "/") + fmt.Sprintf(format, args...)
+}
+
+func (c *Config) UpstreamWSURL(format string, args ...any) string {
+	url := c.UpstreamURL(format, args...)
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://")
+}
+
+func (c *Config) GetAccounts() []AccountConfig {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	tokens := c.Tokens
+	tokens.OrganizationID = c.GetOrganizationID()
+	tokens.SessionKey = c.GetSessionKey()
+	return []AccountConfig{{Name: "default", Tokens: tokens, Cookie: c.GetCookie()}}
+}
+
+func (a *AccountConfig) GetCookie() string {
+	if a.Cookie != "" {
+		return a.Cookie
+	}
+	return a.Tokens.BuildCookie()
+}
+
 func (c *Config) GetCookie() string {
+	if len(c.Accounts) > 0 {
+		return c.Accounts[0].GetCookie()
+	}
 	if c.Tokens.SessionKey != "" {
 		return c.Tokens.BuildCookie()
 	}
@@ -219,6 +342,9 @@ func (c *Config) GetCookie() string {
 }
 
 func (c *Config) GetOrganizationID() string {
+	if len(c.Accounts) > 0 {
+		return c.Accounts[0].Tokens.OrganizationID
+	}
 	if c.Tokens.OrganizationID != "" {
 		return c.Tokens.OrganizationID
 	}
@@ -226,6 +352,9 @@ func (c *Config) GetOrganizationID() string {
 }
 
 func (c *Config) GetSessionKey() string {
+	if len(c.Accounts) > 0 {
+		return c.Accounts[0].Tokens.SessionKey
+	}
 	if c.Tokens.SessionKey != "" {
 		return c.Tokens.SessionKey
 	}
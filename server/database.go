@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -17,11 +19,10 @@ type Database struct {
 }
 
 type Stats struct {
-	Processing      int
-	Completed       int
-	Failed          int
-	ServiceShutdown bool
-	ShutdownReason  string
+	Processing int
+	Completed  int
+	Failed     int
+	Service    ServiceState
 }
 
 type CldDevice struct {
@@ -33,8 +34,10 @@ type CldDevice struct {
 	Banned        bool      `gorm:"default:false;not null" json:"banned"`
 	BanReason     *string   `gorm:"type:varchar" json:"ban_reason"`
 	Admin         bool      `gorm:"default:false;not null" json:"admin"`
-	AdminPassword *string   `gorm:"type:varchar;uniqueIndex" json:"-"`
 	Fingerprint   string    `gorm:"type:varchar;not null;uniqueIndex" json:"fingerprint"`
+	Timezone      *string   `gorm:"type:varchar" json:"timezone"`
+	Locale        *string   `gorm:"type:varchar" json:"locale"`
+	QuotaTier     *string   `gorm:"type:varchar" json:"quota_tier"`
 }
 
 func (CldDevice) TableName() string {
@@ -42,9 +45,11 @@ func (CldDevice) TableName() string {
 }
 
 type CldConversation struct {
-	ID       int    `gorm:"primaryKey;autoIncrement" json:"id"`
-	UID      string `gorm:"type:varchar;not null;uniqueIndex" json:"uid"`
-	DeviceID int    `gorm:"not null;index" json:"device_id"`
+	ID                int    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UID               string `gorm:"type:varchar;not null;uniqueIndex" json:"uid"`
+	DeviceID          int    `gorm:"not null;index" json:"device_id"`
+	Account           string `gorm:"type:varchar" json:"account"`
+	CurrentDialogueID *int   `json:"current_dialogue_id"`
 }
 
 func (CldConversation) TableName() string {
@@ -52,18 +57,63 @@ func (CldConversation) TableName() string {
 }
 
 type CldDialogue struct {
-	ID               int        `gorm:"primaryKey;autoIncrement" json:"id"`
-	UID              string     `gorm:"type:varchar;not null;uniqueIndex" json:"uid"`
-	ConversationID   int        `gorm:"not null;index" json:"conversation_id"`
-	Order            int        `gorm:"column:order;default:1;not null" json:"order"`
-	UserMessage      string     `gorm:"type:text;not null" json:"user_message"`
-	AssistantMessage *string    `gorm:"type:text" json:"assistant_message"`
-	CreateTime       time.Time  `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"create_time"`
-	FinishTime       *time.Time `gorm:"type:timestamptz" json:"finish_time"`
-	RequestTime      *time.Time `gorm:"type:timetz" json:"request_time"`
-	Status           string     `gorm:"type:varchar;default:'processing';not null" json:"status"`
-	Duration         *int       `json:"duration"`
-	PromptID         *int       `gorm:"index" json:"prompt_id"`
+	ID                   int             `gorm:"primaryKey;autoIncrement" json:"id"`
+	UID                  string          `gorm:"type:varchar;not null;uniqueIndex" json:"uid"`
+	ConversationID       int             `gorm:"not null;index" json:"conversation_id"`
+	Order                int             `gorm:"column:order;default:1;not null" json:"order"`
+	UserMessage          string          `gorm:"type:text;not null" json:"user_message"`
+	AssistantMessage     *string         `gorm:"type:text" json:"assistant_message"`
+	CreateTime           time.Time       `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"create_time"`
+	FinishTime           *time.Time      `gorm:"type:timestamptz" json:"finish_time"`
+	RequestTime          *time.Time      `gorm:"type:timetz" json:"request_time"`
+	Status               string          `gorm:"type:varchar;default:'processing';not null" json:"status"`
+	Duration             *int            `json:"duration"`
+	PromptID             *int            `gorm:"index" json:"prompt_id"`
+	Thinking             *string         `gorm:"type:text" json:"thinking"`
+	StopReason           *string         `gorm:"type:varchar" json:"stop_reason"`
+	FinishReason         *string         `gorm:"type:varchar" json:"finish_reason"`
+	ContentBlocks        json.RawMessage `gorm:"type:jsonb" json:"content_blocks"`
+	ParentID             *int            `gorm:"index" json:"parent_id"`
+	ParentMessageUUID    *string         `gorm:"type:varchar" json:"parent_message_uuid"`
+	UserMessageUUID      *string         `gorm:"type:varchar" json:"user_message_uuid"`
+	AssistantMessageUUID *string         `gorm:"type:varchar;index" json:"assistant_message_uuid"`
+	Attachments          json.RawMessage `gorm:"type:jsonb" json:"attachments"`
+	InputTokens          int             `gorm:"default:0;not null" json:"input_tokens"`
+	OutputTokens         int             `gorm:"default:0;not null" json:"output_tokens"`
+	APIKeyID             *int            `gorm:"index" json:"api_key_id"`
+}
+
+type CldCompatCache struct {
+	ID               int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Fingerprint      string    `gorm:"type:varchar;not null;uniqueIndex" json:"fingerprint"`
+	DeviceID         int       `gorm:"not null;index" json:"device_id"`
+	ConversationID   int       `gorm:"not null" json:"conversation_id"`
+	MessageUUID      string    `gorm:"type:varchar;not null" json:"message_uuid"`
+	SystemPromptHash string    `gorm:"type:varchar" json:"system_prompt_hash"`
+	CreateTime       time.Time `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"create_time"`
+	ExpireTime       time.Time `gorm:"type:timestamptz;not null;index" json:"expire_time"`
+}
+
+type CldAPIKey struct {
+	ID                int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name              string     `gorm:"type:varchar;not null" json:"name"`
+	Prefix            string     `gorm:"type:varchar;not null" json:"prefix"`
+	KeyHash           string     `gorm:"type:varchar;not null;uniqueIndex" json:"-"`
+	Scopes            string     `gorm:"type:varchar;not null" json:"scopes"`
+	DeviceID          *int       `gorm:"index" json:"device_id"`
+	CreateTime        time.Time  `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"create_time"`
+	LastUsedTime      *time.Time `gorm:"type:timestamptz" json:"last_used_time"`
+	RevokeTime        *time.Time `gorm:"type:timestamptz" json:"revoke_time"`
+	QuotaTier         *string    `gorm:"type:varchar" json:"quota_tier"`
+	DeviceFingerprint string     `gorm:"-" json:"-"`
+}
+
+type CldAdmin struct {
+	ID            int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Username      string     `gorm:"type:varchar;not null;uniqueIndex" json:"username"`
+	PasswordHash  string     `gorm:"type:varchar;not null" json:"-"`
+	CreateTime    time.Time  `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"create_time"`
+	LastLoginTime *time.Time `gorm:"type:timestamptz" json:"last_login_time"`
 }
 
 type CldPrompt struct {
@@ -76,6 +126,18 @@ func (CldDialogue) TableName() string {
 	return "cld_dialogue"
 }
 
+func (CldCompatCache) TableName() string {
+	return "cld_compat_cache"
+}
+
+func (CldAPIKey) TableName() string {
+	return "cld_api_key"
+}
+
+func (CldAdmin) TableName() string {
+	return "cld_admin"
+}
+
 func (CldPrompt) TableName() string {
 	return "cld_prompt"
 }
@@ -94,6 +156,21 @@ func (CldError) TableName() string {
 	return "cld_error"
 }
 
+type CldStyle struct {
+	ID         int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UID        string    `gorm:"type:varchar;not null;uniqueIndex" json:"uid"`
+	Key        string    `gorm:"type:varchar;not null;uniqueIndex" json:"key"`
+	Name       string    `gorm:"type:varchar;not null" json:"name"`
+	Summary    string    `gorm:"type:varchar" json:"summary"`
+	Prompt     string    `gorm:"type:text;not null" json:"prompt"`
+	CreateTime time.Time `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"create_time"`
+	UpdateTime time.Time `gorm:"type:timestamptz;default:CURRENT_TIMESTAMP;not null" json:"update_time"`
+}
+
+func (CldStyle) TableName() string {
+	return "cld_style"
+}
+
 func InitDB(cfg *Config) (*Database, error) {
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
@@ -110,7 +187,7 @@ func InitDB(cfg *Config) (*Database, error) {
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
-	if err := db.AutoMigrate(&CldDevice{}, &CldConversation{}, &CldDialogue{}, &CldError{}, &CldPrompt{}); err != nil {
+	if err := db.AutoMigrate(&CldDevice{}, &CldConversation{}, &CldDialogue{}, &CldError{}, &CldPrompt{}, &CldStyle{}, &CldCompatCache{}, &CldAPIKey{}, &CldAdmin{}); err != nil {
 		return nil, fmt.Errorf("failed to auto migrate: %v", err)
 	}
 	db.Exec(`
@@ -123,9 +200,12 @@ func InitDB(cfg *Config) (*Database, error) {
 	`)
 	db.Exec(`
 		DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'cld_dialogue_check' AND pg_get_constraintdef(oid) NOT LIKE '%cancelled%') THEN
+				ALTER TABLE cld_dialogue DROP CONSTRAINT cld_dialogue_check;
+			END IF;
 			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'cld_dialogue_check') THEN
 				ALTER TABLE cld_dialogue ADD CONSTRAINT cld_dialogue_check
-				CHECK (status IN ('waiting', 'processing', 'replying', 'done', 'send_failed', 'reply_failed'));
+				CHECK (status IN ('waiting', 'processing', 'replying', 'done', 'send_failed', 'reply_failed', 'cancelled'));
 			END IF;
 		END $$;
 	`)
@@ -133,6 +213,9 @@ func InitDB(cfg *Config) (*Database, error) {
 	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cld_dialogue_create_time ON cld_dialogue(create_time DESC)`)
 	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cld_dialogue_status ON cld_dialogue(status)`)
 	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cld_conversation_device_id ON cld_conversation(device_id)`)
+	if err := migrateLegacyAdminPasswords(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate legacy admin passwords: %v", err)
+	}
 	log.Println("Database initialized successfully")
 	return &Database{DB: db}, nil
 }
@@ -150,7 +233,7 @@ func (d *Database) LoadStats() error {
 	if err := d.Model(&CldDialogue{}).Where("status = ?", "done").Count(&completed).Error; err != nil {
 		return err
 	}
-	if err := d.Model(&CldDialogue{}).Where("status NOT IN ?", []string{"done", "processing", "waiting", "replying"}).Count(&failed).Error; err != nil {
+	if err := d.Model(&CldDialogue{}).Where("status NOT IN ?", []string{"done", "processing", "waiting", "replying", "cancelled"}).Count(&failed).Error; err != nil {
 		return err
 	}
 	d.statsMutex.Lock()
@@ -190,17 +273,41 @@ func (d *Database) IncrementFailed() {
 	d.statsMutex.Unlock()
 }
 
-func (d *Database) SetShutdown(reason string) {
+func (d *Database) SetShutdown(cause, reason string, recoverAt *time.Time) bool {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+	changed := !d.stats.Service.Shutdown || d.stats.Service.Cause != cause
+	if changed {
+		now := time.Now()
+		d.stats.Service.Since = &now
+	}
+	d.stats.Service.Shutdown = true
+	d.stats.Service.Cause = cause
+	d.stats.Service.Reason = reason
+	d.stats.Service.RecoverAt = recoverAt
+	return changed
+}
+
+func (d *Database) ClearShutdown() bool {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+	if !d.stats.Service.Shutdown {
+		return false
+	}
+	d.stats.Service = ServiceState{Override: d.stats.Service.Override}
+	return true
+}
+
+func (d *Database) SetServiceOverride(override string) {
 	d.statsMutex.Lock()
-	d.stats.ServiceShutdown = true
-	d.stats.ShutdownReason = reason
+	d.stats.Service.Override = override
 	d.statsMutex.Unlock()
 }
 
 func (d *Database) IsShutdown() bool {
 	d.statsMutex.RLock()
 	defer d.statsMutex.RUnlock()
-	return d.stats.ServiceShutdown
+	return d.stats.Service.Shutdown
 }
 
 func (d *Database) CreateDialogue(dialogue *CldDialogue) error {
@@ -221,6 +328,25 @@ func (d *Database) UpdateDialogue(dialogue *CldDialogue) error {
 	return err
 }
 
+func (d *Database) SaveDialogues(dialogues []*CldDialogue) error {
+	if len(dialogues) == 0 {
+		return nil
+	}
+	err := d.Transaction(func(tx *gorm.DB) error {
+		for _, dialogue := range dialogues {
+			if err := tx.Save(dialogue).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		broadcastHistory()
+		broadcastStats()
+	}
+	return err
+}
+
 func (d *Database) GetDialogueByID(id int) (*CldDialogue, error) {
 	var dialogue CldDialogue
 	err := d.First(&dialogue, id).Error
@@ -271,7 +397,155 @@ func (d *Database) CalculateRates() (tpm, rpm, rpd float64, err error) {
 		return 0, 0, 0, err
 	}
 	rpd = float64(dailyCount)
-	return 0, rpm, rpd, nil
+	var tokenCount int64
+	err = d.Model(&CldDialogue{}).
+		Where("COALESCE(finish_time, create_time) >= ?", oneMinuteAgo).
+		Select("COALESCE(SUM(input_tokens + output_tokens), 0)").
+		Scan(&tokenCount).Error
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	tpm = float64(tokenCount)
+	return tpm, rpm, rpd, nil
+}
+
+func (d *Database) RequestWindowRecovery(window time.Duration, excess int) *time.Time {
+	var createTime time.Time
+	err := d.Model(&CldDialogue{}).
+		Select("create_time").
+		Where("create_time >= ?", time.Now().Add(-window)).
+		Order("create_time ASC").
+		Offset(max(excess-1, 0)).
+		Limit(1).
+		Row().Scan(&createTime)
+	if err != nil {
+		return nil
+	}
+	recoverAt := createTime.Add(window)
+	return &recoverAt
+}
+
+func (d *Database) TokenWindowRecovery(window time.Duration, excess int) *time.Time {
+	var finishTime time.Time
+	err := d.Raw(`SELECT t FROM (
+			SELECT COALESCE(finish_time, create_time) AS t,
+				SUM(input_tokens + output_tokens) OVER (ORDER BY COALESCE(finish_time, create_time)) AS cumulative
+			FROM cld_dialogue
+			WHERE COALESCE(finish_time, create_time) >= ?
+		) w WHERE cumulative >= ? ORDER BY t LIMIT 1`, time.Now().Add(-window), excess).
+		Row().Scan(&finishTime)
+	if err != nil {
+		return nil
+	}
+	recoverAt := finishTime.Add(window)
+	return &recoverAt
+}
+
+func (d *Database) GetCompatCache(fingerprint string) (*CldCompatCache, error) {
+	var entry CldCompatCache
+	err := d.Where("fingerprint = ? AND expire_time > ?", fingerprint, time.Now()).First(&entry).Error
+	return &entry, err
+}
+
+func (d *Database) SaveCompatCache(entry *CldCompatCache, maxEntries int) error {
+	err := d.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "fingerprint"}},
+		UpdateAll: true,
+	}).Create(entry).Error
+	if err != nil {
+		return err
+	}
+	return d.Exec(`DELETE FROM cld_compat_cache WHERE expire_time <= ? OR id NOT IN (
+		SELECT id FROM cld_compat_cache ORDER BY create_time DESC LIMIT ?
+	)`, time.Now(), maxEntries).Error
+}
+
+func (d *Database) DeleteCompatCache(fingerprint string) error {
+	return d.Where("fingerprint = ?", fingerprint).Delete(&CldCompatCache{}).Error
+}
+
+func (d *Database) CreateAPIKey(key *CldAPIKey) error {
+	key.CreateTime = time.Now()
+	return d.Create(key).Error
+}
+
+func (d *Database) GetAPIKeys() ([]CldAPIKey, error) {
+	var keys []CldAPIKey
+	err := d.Order("id ASC").Find(&keys).Error
+	return keys, err
+}
+
+func (d *Database) GetAPIKeyByHash(keyHash string) (*CldAPIKey, error) {
+	var key CldAPIKey
+	err := d.Where("key_hash = ? AND revoke_time IS NULL", keyHash).First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (d *Database) TouchAPIKey(id int) error {
+	return d.Model(&CldAPIKey{}).Where("id = ?", id).Update("last_used_time", time.Now()).Error
+}
+
+func (d *Database) CountAPIKeys() (int64, error) {
+	var count int64
+	err := d.Model(&CldAPIKey{}).Where("revoke_time IS NULL").Count(&count).Error
+	return count, err
+}
+
+func (d *Database) RevokeAPIKey(id int) (bool, error) {
+	result := d.Model(&CldAPIKey{}).Where("id = ? AND revoke_time IS NULL", id).Update("revoke_time", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (d *Database) SetAPIKeyQuotaTier(id int, tier *string) (bool, error) {
+	result := d.Model(&CldAPIKey{}).Where("id = ? AND revoke_time IS NULL", id).Update("quota_tier", tier)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (d *Database) CreateAdmin(admin *CldAdmin) error {
+	admin.CreateTime = time.Now()
+	return d.Create(admin).Error
+}
+
+func (d *Database) GetAdmins() ([]CldAdmin, error) {
+	var admins []CldAdmin
+	err := d.Order("id ASC").Find(&admins).Error
+	return admins, err
+}
+
+func (d *Database) GetAdminByID(id int) (*CldAdmin, error) {
+	var admin CldAdmin
+	err := d.First(&admin, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+func (d *Database) GetAdminByUsername(username string) (*CldAdmin, error) {
+	var admin CldAdmin
+	err := d.Where("LOWER(username) = LOWER(?)", username).First(&admin).Error
+	if err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+func (d *Database) CountAdmins() (int64, error) {
+	var count int64
+	err := d.Model(&CldAdmin{}).Count(&count).Error
+	return count, err
+}
+
+func (d *Database) TouchAdminLogin(id int) error {
+	return d.Model(&CldAdmin{}).Where("id = ?", id).Update("last_login_time", time.Now()).Error
+}
+
+func (d *Database) DeleteAdmin(id int) (bool, error) {
+	result := d.Delete(&CldAdmin{}, id)
+	return result.RowsAffected > 0, result.Error
 }
 
 func (d *Database) GetNextDialogueOrder(conversationID int) (int, error) {
@@ -283,12 +557,42 @@ func (d *Database) GetNextDialogueOrder(conversationID int) (int, error) {
 	return maxOrder + 1, err
 }
 
+func (d *Database) GetParentDialogueID(conversationID int, parentMessageUUID string) *int {
+	if parentMessageUUID == "" || parentMessageUUID == rootMessageUUID {
+		return nil
+	}
+	var dialogue CldDialogue
+	err := d.Where("conversation_id = ? AND assistant_message_uuid = ?", conversationID, parentMessageUUID).
+		Order("id DESC").
+		First(&dialogue).Error
+	if err != nil {
+		return nil
+	}
+	return &dialogue.ID
+}
+
+func (d *Database) SetCurrentDialogue(conversationID, dialogueID int) error {
+	return d.Model(&CldConversation{}).
+		Where("id = ?", conversationID).
+		Update("current_dialogue_id", dialogueID).Error
+}
+
 func (d *Database) GetHistory(limit int) ([]CldDialogue, error) {
 	var dialogues []CldDialogue
 	err := d.Order("create_time DESC").Limit(limit).Find(&dialogues).Error
 	return dialogues, err
 }
 
+func (d *Database) GetDeviceHistory(deviceID, limit int) ([]CldDialogue, error) {
+	var dialogues []CldDialogue
+	err := d.Joins("JOIN cld_conversation conv ON conv.id = cld_dialogue.conversation_id").
+		Where("conv.device_id = ?", deviceID).
+		Order("cld_dialogue.create_time DESC").
+		Limit(limit).
+		Find(&dialogues).Error
+	return dialogues, err
+}
+
 type ConversationInfo struct {
 	ID           int       `json:"id"`
 	DeviceID     int       `json:"device_id"`
@@ -298,17 +602,28 @@ type ConversationInfo struct {
 }
 
 func (d *Database) GetAllConversations() ([]ConversationInfo, error) {
+	return d.GetConversationInfos(nil)
+}
+
+func (d *Database) GetConversationInfos(deviceID *int) ([]ConversationInfo, error) {
 	var results []ConversationInfo
-	err := d.Raw(`
+	query := `
 		SELECT
 			c.id,
 			c.device_id,
 			(SELECT user_message FROM cld_dialogue WHERE conversation_id = c.id ORDER BY "order" DESC LIMIT 1) as last_message,
 			(SELECT create_time FROM cld_dialogue WHERE conversation_id = c.id ORDER BY "order" DESC LIMIT 1) as updated_at,
 			(SELECT COUNT(*) FROM cld_dialogue WHERE conversation_id = c.id) as dialogue_count
-		FROM cld_conversation c
-		ORDER BY updated_at DESC NULLS LAST
-	`).Scan(&results).Error
+		FROM cld_conversation c`
+	var args []any
+	if deviceID != nil {
+		query += `
+		WHERE c.device_id = ?`
+		args = append(args, *deviceID)
+	}
+	query += `
+		ORDER BY updated_at DESC NULLS LAST`
+	err := d.Raw(query, args...).Scan(&results).Error
 	return results, err
 }
 
@@ -324,6 +639,9 @@ func (d *Database) GetAllAPIs() ([]APIInfo, error) {
 		{"/v1/models", "获取可用模型列表", "GET"},
 		{"/api/tags", "Ollama兼容的模型列表", "GET"},
 		{"/api/chat", "Ollama兼容的对话API", "POST"},
+		{"/api/generate", "Ollama兼容的文本生成API", "POST"},
+		{"/api/show", "Ollama兼容的模型详情", "POST"},
+		{"/v1/messages", "Anthropic兼容的消息API", "POST"},
 		{"/health", "健康检查", "GET"},
 		{"/api/stats", "获取统计数据", "GET"},
 		{"/api/records", "获取增量记录", "POST"},
@@ -414,16 +732,128 @@ func (d *Database) GetBannedDevices() ([]CldDevice, error) {
 	return devices, err
 }
 
+func (d *Database) SetDeviceAdmin(deviceID int, admin bool) error {
+	return d.Model(&CldDevice{}).Where("id = ?", deviceID).Update("admin", admin).Error
+}
+
+func (d *Database) SetDeviceQuotaTier(deviceID int, tier *string) error {
+	return d.Model(&CldDevice{}).Where("id = ?", deviceID).Update("quota_tier", tier).Error
+}
+
+type QuotaUsage struct {
+	RequestsMinute      int64      `json:"requests_minute"`
+	RequestsDay         int64      `json:"requests_day"`
+	TokensDay           int64      `json:"tokens_day"`
+	TokensFiveHour      int64      `json:"tokens_five_hour"`
+	FiveHourUtilization float64    `gorm:"-" json:"five_hour_utilization"`
+	OldestMinute        *time.Time `json:"-"`
+	OldestFiveHour      *time.Time `json:"-"`
+	OldestDay           *time.Time `json:"-"`
+}
+
+func (d *Database) quotaUsage(column string, id int) (*QuotaUsage, error) {
+	now := time.Now()
+	oneMinuteAgo := now.Add(-1 * time.Minute)
+	fiveHoursAgo := now.Add(-5 * time.Hour)
+	var usage QuotaUsage
+	err := d.Table("cld_dialogue dlg").
+		Select(`COUNT(*) FILTER (WHERE dlg.create_time >= ?) AS requests_minute,
+			COUNT(*) AS requests_day,
+			COALESCE(SUM(dlg.input_tokens + dlg.output_tokens), 0) AS tokens_day,
+			COALESCE(SUM(dlg.input_tokens + dlg.output_tokens) FILTER (WHERE dlg.create_time >= ?), 0) AS tokens_five_hour,
+			MIN(dlg.create_time) FILTER (WHERE dlg.create_time >= ?) AS oldest_minute,
+			MIN(dlg.create_time) FILTER (WHERE dlg.create_time >= ?) AS oldest_five_hour,
+			MIN(dlg.create_time) AS oldest_day`, oneMinuteAgo, fiveHoursAgo, oneMinuteAgo, fiveHoursAgo).
+		Joins("JOIN cld_conversation conv ON conv.id = dlg.conversation_id").
+		Where(column+" = ?", id).
+		Where("dlg.create_time >= ?", now.Add(-24*time.Hour)).
+		Scan(&usage).Error
+	return &usage, err
+}
+
+func (d *Database) GetDeviceQuotaUsage(deviceID int) (*QuotaUsage, error) {
+	return d.quotaUsage("conv.device_id", deviceID)
+}
+
+func (d *Database) GetAPIKeyQuotaUsage(keyID int) (*QuotaUsage, error) {
+	return d.quotaUsage("dlg.api_key_id", keyID)
+}
+
+func (d *Database) GetFiveHourTokens() (int64, error) {
+	var tokens int64
+	err := d.Model(&CldDialogue{}).
+		Where("create_time >= ?", time.Now().Add(-5*time.Hour)).
+		Select("COALESCE(SUM(input_tokens + output_tokens), 0)").
+		Scan(&tokens).Error
+	return tokens, err
+}
+
+type DeviceFilter struct {
+	Search string
+	Banned *bool
+	Limit  int
+	Offset int
+}
+
+type DeviceSummary struct {
+	CldDevice
+	LastActivity      time.Time `json:"last_activity"`
+	ConversationCount int       `json:"conversation_count"`
+	DialogueCount     int       `json:"dialogue_count"`
+}
+
+func (f DeviceFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Search != "" {
+		like := "%" + f.Search + "%"
+		query = query.Where("dev.fingerprint ILIKE ? OR dev.platform ILIKE ? OR dev.notice ILIKE ? OR dev.ban_reason ILIKE ?", like, like, like, like)
+	}
+	if f.Banned != nil {
+		query = query.Where("dev.banned = ?", *f.Banned)
+	}
+	return query
+}
+
+func (d *Database) SearchDevices(filter DeviceFilter) ([]DeviceSummary, int64, error) {
+	var total int64
+	if err := filter.apply(d.Table("cld_device dev")).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var devices []DeviceSummary
+	err := filter.apply(d.Table("cld_device dev")).
+		Select(`dev.*,
+			GREATEST(dev.update_time, MAX(dlg.create_time)) AS last_activity,
+			COUNT(DISTINCT conv.id) AS conversation_count,
+			COUNT(dlg.id) AS dialogue_count`).
+		Joins("LEFT JOIN cld_conversation conv ON conv.device_id = dev.id").
+		Joins("LEFT JOIN cld_dialogue dlg ON dlg.conversation_id = conv.id").
+		Group("dev.id").
+		Order("last_activity DESC").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Scan(&devices).Error
+	return devices, total, err
+}
+
 func (d *Database) CreateConversation(deviceID int, uid string) (*CldConversation, error) {
 	conv := CldConversation{
 		UID:      uid,
 		DeviceID: deviceID,
 	}
+	if accountPool != nil {
+		conv.Account = accountPool.AccountName(uid)
+	}
 	if err := d.Create(&conv).Error; err != nil {
 		return nil, err
 	}
 	return &conv, nil
 }
+func (d *Database) GetOrCreateConversation(deviceID int, uid string) (*CldConversation, error) {
+	if conv, err := d.GetConversationByUID(uid); err == nil {
+		return conv, nil
+	}
+	return d.CreateConversation(deviceID, uid)
+}
+
 func (d *Database) GetConversationByUID(uid string) (*CldConversation, error) {
 	var conv CldConversation
 	err := d.Where("uid = ?", uid).First(&conv).Error
@@ -438,23 +868,25 @@ func (d *Database) GetConversation(id int) (*CldConversation, error) {
 
 func (d *Database) GetDeviceConversations(deviceID int) ([]CldConversation, error) {
 	var conversations []CldConversation
-	err := d.Where("device_id = ?", deviceID).Find(&conversations).Error
+	err := d.Where("device_id = ?", deviceID).Order("id DESC").Find(&conversations).Error
 	return conversations, err
 }
 
-func (d *Database) IsDeviceAdmin(fingerprint string, password string) bool {
-	var device CldDevice
-	err := d.Where("fingerprint = ?", fingerprint).First(&device).Error
-	if err != nil {
-		return false
-	}
-	return device.Admin && device.AdminPassword != nil && *device.AdminPassword == password
-}
-
 func (d *Database) UpdateDeviceNotice(fingerprint string, notice string) error {
 	return d.Model(&CldDevice{}).Where("fingerprint = ?", fingerprint).Update("notice", notice).Error
 }
 
+func (d *Database) UpdateDeviceLocale(fingerprint string, timezone string, locale string) error {
+	updates := map[string]any{"timezone": nil, "locale": nil}
+	if timezone != "" {
+		updates["timezone"] = timezone
+	}
+	if locale != "" {
+		updates["locale"] = locale
+	}
+	return d.Model(&CldDevice{}).Where("fingerprint = ?", fingerprint).Updates(updates).Error
+}
+
 func (d *Database) GetDialogueWithConversation(dialogueID int) (*CldDialogue, *CldConversation, *CldDevice, error) {
 	var dialogue CldDialogue
 	if err := d.First(&dialogue, dialogueID).Error; err != nil {
@@ -511,3 +943,33 @@ func (d *Database) GetLatestPrompt() (*CldPrompt, error) {
 	}
 	return &prompt, nil
 }
+
+func (d *Database) GetCustomStyles() ([]CldStyle, error) {
+	var styles []CldStyle
+	err := d.Order("id ASC").Find(&styles).Error
+	return styles, err
+}
+
+func (d *Database) GetCustomStyle(key string) (*CldStyle, error) {
+	var style CldStyle
+	err := d.Where("LOWER(key) = LOWER(?)", key).First(&style).Error
+	if err != nil {
+		return nil, err
+	}
+	return &style, nil
+}
+
+func (d *Database) CreateCustomStyle(style *CldStyle) error {
+	style.CreateTime = time.Now()
+	style.UpdateTime = time.Now()
+	return d.Create(style).Error
+}
+
+func (d *Database) UpdateCustomStyle(style *CldStyle) error {
+	style.UpdateTime = time.Now()
+	return d.Save(style).Error
+}
+
+func (d *Database) DeleteCustomStyle(id int) error {
+	return d.Delete(&CldStyle{}, id).Error
+}
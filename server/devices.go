@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+var deviceConns sync.Map
+
+type BanDeviceRequest struct {
+	Reason string `json:"reason"`
+}
+
+type SetDeviceAdminRequest struct {
+	Admin *bool `json:"admin"`
+}
+
+type DeviceBannedError struct {
+	Reason string
+}
+
+func (e *DeviceBannedError) Error() string {
+	if e.Reason == "" {
+		return "Device is banned"
+	}
+	return "Device is banned: " + e.Reason
+}
+
+func requestDevice(c *gin.Context, db *Database) *CldDevice {
+	fingerprint := c.GetHeader("X-Device-ID")
+	if fingerprint == "" {
+		fingerprint = c.Query("device_id")
+	}
+	if fingerprint == "" {
+		return nil
+	}
+	device, err := db.GetDeviceByFingerprint(fingerprint)
+	if err != nil {
+		return nil
+	}
+	return device
+}
+
+func deviceBan(db *Database, access *APIAccess, device *CldDevice) *DeviceBannedError {
+	if device != nil && device.Banned {
+		reason := ""
+		if device.BanReason != nil {
+			reason = *device.BanReason
+		}
+		return &DeviceBannedError{Reason: reason}
+	}
+	if deviceID := access.boundDeviceID(); deviceID != nil && (device == nil || device.ID != *deviceID) {
+		if banned, reason, _ := db.IsDeviceBanned(*deviceID); banned {
+			return &DeviceBannedError{Reason: reason}
+		}
+	}
+	return nil
+}
+
+func DeviceBanMiddleware(db *Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := deviceBan(db, requestAPIAccess(c), requestDevice(c, db)); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":  "Device is banned",
+				"reason": err.Reason,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func disconnectDevice(deviceID int, reason string) int {
+	disconnected := 0
+	deviceConns.Range(func(key, value any) bool {
+		if value.(int) != deviceID {
+			return true
+		}
+		conn := key.(*websocket.Conn)
+		sendWSMessage(conn, "banned", map[string]any{
+			"banned": true,
+			"reason": reason,
+		})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "banned"), time.Now().Add(time.Second))
+		conn.Close()
+		disconnected++
+		return true
+	})
+	return disconnected
+}
+
+func (h *Handler) deviceParam(c *gin.Context) *CldDevice {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return nil
+	}
+	device, err := h.db.GetDeviceByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return nil
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load device"})
+		return nil
+	}
+	return device
+}
+
+func (h *Handler) ListDevices(c *gin.Context) {
+	filter := DeviceFilter{
+		Search: strings.TrimSpace(c.Query("q")),
+		Limit:  50,
+	}
+	if banned, err := strconv.ParseBool(c.Query("banned")); err == nil {
+		filter.Banned = &banned
+	}
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+		filter.Limit = min(limit, 500)
+	}
+	if offset, err := strconv.Atoi(c.Query("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+	devices, total, err := h.db.SearchDevices(filter)
+	if err != nil {
+		log.Printf("Failed to search devices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load devices"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"devices": devices,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+func (h *Handler) GetDeviceConversations(c *gin.Context) {
+	device := h.deviceParam(c)
+	if device == nil {
+		return
+	}
+	conversations, err := h.db.GetDeviceConversations(device.ID)
+	if err != nil {
+		log.Printf("Failed to load device conversations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load conversations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device":        device,
+		"conversations": conversations,
+	})
+}
+
+func (h *Handler) BanDevice(c *gin.Context) {
+	device := h.deviceParam(c)
+	if device == nil {
+		return
+	}
+	var req BanDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ban reason is required"})
+		return
+	}
+	if err := h.db.BanDevice(device.ID, req.Reason); err != nil {
+		log.Printf("Failed to ban device: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ban device"})
+		return
+	}
+	disconnected := disconnectDevice(device.ID, req.Reason)
+	cancelled := h.dialogueManager.CancelDeviceGenerations(device.ID)
+	log.Printf("⛔ %s 已封禁设备 %d (%s): %s，断开 %d 个连接，取消 %d 个生成", requestAPIAccess(c).actor(), device.ID, device.Fingerprint, req.Reason, disconnected, cancelled)
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Device banned successfully",
+		"device_id":    device.ID,
+		"reason":       req.Reason,
+		"disconnected": disconnected,
+		"cancelled":    cancelled,
+	})
+}
+
+func (h *Handler) UnbanDevice(c *gin.Context) {
+	device := h.deviceParam(c)
+	if device == nil {
+		return
+	}
+	if err := h.db.UnbanDevice(device.ID); err != nil {
+		log.Printf("Failed to unban device: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unban device"})
+		return
+	}
+	log.Printf("✓ %s 已解封设备 %d (%s)", requestAPIAccess(c).actor(), device.ID, device.Fingerprint)
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Device unbanned successfully",
+		"device_id": device.ID,
+	})
+}
+
+func (h *Handler) SetDeviceAdmin(c *gin.Context) {
+	device := h.deviceParam(c)
+	if device == nil {
+		return
+	}
+	var req SetDeviceAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Admin == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "admin is required"})
+		return
+	}
+	if err := h.db.SetDeviceAdmin(device.ID, *req.Admin); err != nil {
+		log.Printf("Failed to update device admin flag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+	log.Printf("✓ %s 已将设备 %d (%s) 的管理员标记设为 %t", requestAPIAccess(c).actor(), device.ID, device.Fingerprint, *req.Admin)
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Device updated successfully",
+		"device_id": device.ID,
+		"admin":     *req.Admin,
+	})
+}
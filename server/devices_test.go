@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"claude-server/fakeclaude"
+)
+
+func TestBannedDeviceIsRejectedOnEveryChatRoute(t *testing.T) {
+	env := newTestEnv(t, nil)
+	device, err := env.db.GetOrCreateDevice("device-banned", "windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.BanDevice(device.ID, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	var banned struct {
+		Error  string `json:"error"`
+		Reason string `json:"reason"`
+	}
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-banned", map[string]any{"request": "Hi"}, http.StatusForbidden, &banned)
+	if banned.Reason != "spam" {
+		t.Fatalf("got %+v, want the ban reason", banned)
+	}
+	env.doJSON(http.MethodGet, "/chat/dialogue/event?request=Hi", "device-banned", nil, http.StatusForbidden, nil)
+	env.doJSON(http.MethodGet, "/chat/dialogue/websocket", "device-banned", nil, http.StatusForbidden, nil)
+	var compatError struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-banned", chatRequest("Hi", nil), http.StatusForbidden, &compatError)
+	if compatError.Error.Type != "permission_error" {
+		t.Fatalf("got OpenAI error type %q, want permission_error", compatError.Error.Type)
+	}
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "", chatRequest("Hi", map[string]any{"user": "device-banned"}), http.StatusForbidden, nil)
+	env.doJSON(http.MethodPost, "/v1/messages", "device-banned", map[string]any{
+		"max_tokens": 100,
+		"messages":   []map[string]any{{"role": "user", "content": "Hi"}},
+	}, http.StatusForbidden, &compatError)
+	if compatError.Error.Type != "permission_error" {
+		t.Fatalf("got Anthropic error type %q, want permission_error", compatError.Error.Type)
+	}
+	env.doJSON(http.MethodPost, "/api/chat", "device-banned", map[string]any{
+		"stream":   false,
+		"messages": []map[string]any{{"role": "user", "content": "Hi"}},
+	}, http.StatusForbidden, nil)
+	boundKey, _, err := issueAPIKey(env.db, "bound", scopeChat, &device.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownKey := env.apiKey
+	env.apiKey = boundKey
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "", map[string]any{"request": "Hi"}, http.StatusForbidden, nil)
+	env.apiKey = ownKey
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 0 {
+		t.Fatalf("got %d upstream completions from a banned device, want 0", got)
+	}
+	env.fake.QueueCompletion(fakeclaude.TextReply("Hello"))
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-allowed", chatRequest("Hi", nil), http.StatusOK, nil)
+}
+
+func TestBanCancelsRunningGeneration(t *testing.T) {
+	env := newTestEnv(t, nil)
+	adminKey, _, err := issueAPIKey(env.db, "admin", scopeAdmin, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.fake.QueueCompletion(fakeclaude.SlowReply("one two three four five six seven eight", 200*time.Millisecond))
+	done := make(chan DialogueResponse, 1)
+	go func() {
+		var dialogue DialogueResponse
+		resp := env.do(http.MethodPost, "/chat/dialogue/http", "device-ban-live", map[string]any{"request": "Count slowly"})
+		data, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(data, &dialogue)
+		done <- dialogue
+	}()
+	env.waitForRequests(fakeclaude.RouteCompletion, 1)
+	device, err := env.db.GetDeviceByFingerprint("device-ban-live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.apiKey = adminKey
+	var result struct {
+		Cancelled int `json:"cancelled"`
+	}
+	env.doJSON(http.MethodPost, fmt.Sprintf("/api/admin/devices/%d/ban", device.ID), "", map[string]any{"reason": "abuse"}, http.StatusOK, &result)
+	if result.Cancelled != 1 {
+		t.Fatalf("cancelled %d generations, want 1", result.Cancelled)
+	}
+	select {
+	case dialogue := <-done:
+		if !dialogue.Cancelled {
+			t.Fatalf("got %+v, want a cancelled dialogue", dialogue)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("dialogue request did not return after the ban")
+	}
+}
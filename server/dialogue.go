@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,8 +17,17 @@ type DialogueSession struct {
 	SSEClosed       bool
 }
 
+type Generation struct {
+	ConversationID string
+	DialogueID     int
+	DeviceID       int
+	StartTime      time.Time
+	cancel         context.CancelFunc
+}
+
 type DialogueManager struct {
 	sessions      map[string]*DialogueSession
+	generations   map[string]*Generation
 	mutex         sync.RWMutex
 	cleanupTicker *time.Ticker
 	cleanupDone   chan bool
@@ -25,6 +36,7 @@ type DialogueManager struct {
 func NewDialogueManager() *DialogueManager {
 	dm := &DialogueManager{
 		sessions:      make(map[string]*DialogueSession),
+		generations:   make(map[string]*Generation),
 		cleanupTicker: time.NewTicker(1 * time.Minute),
 		cleanupDone:   make(chan bool),
 	}
@@ -67,7 +79,7 @@ func (dm *DialogueManager) GetOrCreateSession(conversationID string) *DialogueSe
 	}
 	session := &DialogueSession{
 		ConversationID:  conversationID,
-		LastMessageUUID: "00000000-0000-4000-8000-000000000000",
+		LastMessageUUID: rootMessageUUID,
 		LastUsedTime:    time.Now(),
 		IsGenerating:    false,
 		StreamMode:      false,
@@ -142,6 +154,66 @@ func (dm *DialogueManager) MarkSSEClosed(conversationID string) {
 	}
 }
 
+func (dm *DialogueManager) StartGeneration(parent context.Context, conversationID string, dialogueID, deviceID int) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	generation := &Generation{
+		ConversationID: conversationID,
+		DialogueID:     dialogueID,
+		DeviceID:       deviceID,
+		StartTime:      time.Now(),
+		cancel:         cancel,
+	}
+	dm.mutex.Lock()
+	dm.generations[conversationID] = generation
+	dm.mutex.Unlock()
+	return ctx, func() {
+		dm.mutex.Lock()
+		if dm.generations[conversationID] == generation {
+			delete(dm.generations, conversationID)
+		}
+		dm.mutex.Unlock()
+		cancel()
+	}
+}
+
+func (dm *DialogueManager) CancelGeneration(id string, deviceID *int) (*Generation, bool) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	generation, exists := dm.generations[id]
+	if !exists {
+		if dialogueID, err := strconv.Atoi(id); err == nil {
+			for _, g := range dm.generations {
+				if g.DialogueID == dialogueID {
+					generation, exists = g, true
+					break
+				}
+			}
+		}
+	}
+	if !exists || (deviceID != nil && generation.DeviceID != *deviceID) {
+		return nil, false
+	}
+	delete(dm.generations, generation.ConversationID)
+	generation.cancel()
+	DebugLog("Cancelled generation: %s (dialogue %d)", generation.ConversationID, generation.DialogueID)
+	return generation, true
+}
+
+func (dm *DialogueManager) CancelDeviceGenerations(deviceID int) int {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	cancelled := 0
+	for id, generation := range dm.generations {
+		if generation.DeviceID != deviceID {
+			continue
+		}
+		delete(dm.generations, id)
+		generation.cancel()
+		cancelled++
+	}
+	return cancelled
+}
+
 func (dm *DialogueManager) Stop() {
 	dm.cleanupTicker.Stop()
 	dm.cleanupDone <- true
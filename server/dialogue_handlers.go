@@ -1,24 +1,48 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
 )
 
-func checkUsageLimits() (bool, string, string) {
+func checkUsageLimits(model *ModelConfig) (bool, string, string) {
 	usage := getUsage()
 	isBlocked, _ := usage["is_blocked"].(bool)
 	blockReason, _ := usage["block_reason"].(string)
 	blockResetTime, _ := usage["block_reset_time"].(string)
-	return isBlocked, blockReason, blockResetTime
+	if isBlocked || model == nil || model.UsageBucket == "five_hour" || model.UsageBucket == "seven_day" {
+		return isBlocked, blockReason, blockResetTime
+	}
+	if utilization, _ := usage[model.UsageBucket+"_utilization"].(int); utilization >= 100 {
+		resetAt, _ := usage[model.UsageBucket+"_resets_at"].(string)
+		return true, fmt.Sprintf("%s 用量已达 %d%%", model.DisplayName, utilization), resetAt
+	}
+	return false, "", ""
+}
+
+func checkModelFiles(model *ModelConfig, files []RequestFile) error {
+	if model.HasCapability(ModelCapabilityVision) {
+		return nil
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Type, "image/") {
+			return fmt.Errorf("model %s does not support image input", model.ID)
+		}
+	}
+	return nil
 }
 
 func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
@@ -27,7 +51,30 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	if isBlocked, blockReason, blockResetTime := checkUsageLimits(); isBlocked {
+	h.dialogueHTTP(c, req)
+}
+
+func (h *Handler) dialogueHTTP(c *gin.Context, req DialogueRequest) {
+	timezone, locale := requestLocale(c)
+	var output *StructuredOutput
+	model, err := h.config.ResolveModel(req.Model)
+	if err == nil {
+		err = checkModelFiles(model, req.Files)
+	}
+	if err == nil {
+		output, err = req.ResponseFormat.structuredOutput()
+	}
+	if err == nil {
+		_, err = resolveStyle(h.config, req.Style)
+	}
+	if err == nil {
+		err = validateLocale(timezone, locale)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
 		log.Printf("[Usage Limit] Request blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
 		c.JSON(http.StatusTooManyRequests, gin.H{
 			"error":            "Usage limit exceeded",
@@ -37,6 +84,10 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 		})
 		return
 	}
+	if req.ConversationID != "" && !h.conversationAccessible(requestAPIAccess(c), req.ConversationID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
 	if req.KeepAlive {
 		if req.ConversationID == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Conversation ID required for keepalive"})
@@ -50,26 +101,30 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 		})
 		return
 	}
+	branch, err := h.branchFrom(requestAPIAccess(c), req.ReplaceDialogueID, &req.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if req.Request == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Request cannot be empty"})
 		return
 	}
-	if globalMCPSessionManager != nil {
-		if err := globalMCPSessionManager.EnsureInitialized(); err != nil {
-			log.Printf("MCP initialization failed (continuing without MCP): %v", err)
-		}
-	}
-	cookie := h.config.GetCookie()
+	ctx := c.Request.Context()
 	var conversationID string
 	var parentMessageUUID string
-	if req.ConversationID != "" {
+	if branch != nil {
+		conversationID = branch.ConversationID
+		parentMessageUUID = branch.ParentMessageUUID
+		h.dialogueManager.GetOrCreateSession(conversationID)
+	} else if req.ConversationID != "" {
 		conversationID = req.ConversationID
 		session := h.dialogueManager.GetOrCreateSession(conversationID)
 		session.GeneratingMutex.RLock()
 		parentMessageUUID = session.LastMessageUUID
 		session.GeneratingMutex.RUnlock()
-		if parentMessageUUID == "00000000-0000-4000-8000-000000000000" {
-			newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
+		if parentMessageUUID == rootMessageUUID {
+			newParentUUID, err := h.lastMessageUUID(ctx, conversationID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get conversation history"})
 				return
@@ -79,12 +134,12 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 		}
 	} else {
 		var err error
-		conversationID, err = createConversation(h.config.GetOrganizationID(), cookie, true)
+		conversationID, err = h.client.CreateConversation(ctx, true)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
 			return
 		}
-		parentMessageUUID = "00000000-0000-4000-8000-000000000000"
+		parentMessageUUID = rootMessageUUID
 		h.dialogueManager.GetOrCreateSession(conversationID)
 	}
 	devicePassword := c.GetHeader("X-Device-ID")
@@ -93,17 +148,22 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 		platform = "windows"
 	}
 	device, _ := h.db.GetOrCreateDevice(devicePassword, platform)
-	conv, _ := h.db.CreateConversation(device.ID, conversationID)
+	timezone, locale = resolveLocale(h.config, device, timezone, locale)
+	conv, _ := h.db.GetOrCreateConversation(device.ID, conversationID)
 	dialogueOrder, _ := h.db.GetNextDialogueOrder(conv.ID)
 	dialogueUID := uuid.New().String()
 	dialogue := &CldDialogue{
-		UID:            dialogueUID,
-		ConversationID: conv.ID,
-		Order:          dialogueOrder,
-		UserMessage:    req.Request,
-		CreateTime:     time.Now(),
-		Status:         "processing",
-		PromptID:       h.db.GetCurrentPromptID(),
+		UID:               dialogueUID,
+		ConversationID:    conv.ID,
+		Order:             dialogueOrder,
+		UserMessage:       req.Request,
+		InputTokens:       estimateInputTokens(output.systemPrompt(LoadSystemPrompt()), req.Request, req.Files),
+		CreateTime:        time.Now(),
+		Status:            "processing",
+		PromptID:          h.db.GetCurrentPromptID(),
+		ParentID:          h.db.GetParentDialogueID(conv.ID, parentMessageUUID),
+		ParentMessageUUID: &parentMessageUUID,
+		APIKeyID:          requestAPIAccess(c).keyID(),
 	}
 	h.db.CreateDialogue(dialogue)
 	session := h.dialogueManager.GetOrCreateSession(conversationID)
@@ -113,6 +173,8 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 	broadcastDialogues()
 	select {
 	case h.semaphore <- struct{}{}:
+		ctx, finishGeneration := h.dialogueManager.StartGeneration(ctx, conversationID, dialogue.ID, conv.DeviceID)
+		defer finishGeneration()
 		defer func() {
 			<-h.semaphore
 			session.GeneratingMutex.Lock()
@@ -137,7 +199,7 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File decode error: %v", err)})
 					return
 				}
-				uploadResp, err := uploadFile(h.config.GetOrganizationID(), conversationID, cookie, &file)
+				uploadResp, err := h.client.UploadFile(ctx, conversationID, &file)
 				if err != nil {
 					dialogueStreamMutex.Lock()
 					delete(dialogueStreams, conversationID)
@@ -155,21 +217,27 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 				})
 			}
 		}
-		response, err := sendDialogueMessageWithFiles(
-			h.config.GetOrganizationID(),
-			conversationID,
-			cookie,
-			req.Request,
-			parentMessageUUID,
-			req.Model,
-			req.Style,
-			attachments,
-			func(chunk string) {
+		if len(attachments) > 0 {
+			dialogue.Attachments, _ = json.Marshal(attachments)
+		}
+		result, first, err := h.streamRepairedCompletion(ctx, CompletionRequest{
+			ConversationID:    conversationID,
+			Prompt:            req.Request,
+			ParentMessageUUID: parentMessageUUID,
+			Model:             model.ID,
+			Style:             req.Style,
+			Timezone:          timezone,
+			Locale:            locale,
+			SystemPrompt:      output.systemPrompt(LoadSystemPrompt()),
+			Attachments:       attachments,
+		}, output.validator(), func(ev StreamEvent, result *StreamResult) {
+			if ev.IsTextDelta() {
 				dialogueStreamMutex.Lock()
-				dialogueStreams[conversationID] = chunk
+				dialogueStreams[conversationID] = result.Text
 				dialogueStreamMutex.Unlock()
-			},
-		)
+			}
+		})
+		response := output.reply(result.Text)
 		dialogueStreamMutex.Lock()
 		delete(dialogueStreams, conversationID)
 		dialogueStreamMutex.Unlock()
@@ -177,24 +245,44 @@ func (h *Handler) DialogueChatEnhanced(c *gin.Context) {
 		dialogue.FinishTime = &finishTime
 		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
 		dialogue.Duration = &duration
+		applyRepairedResult(dialogue, first, result)
+		if errors.Is(err, context.Canceled) {
+			h.saveCancelledDialogue(dialogue, conversationID, result)
+			c.JSON(http.StatusOK, DialogueResponse{
+				ConversationID: conversationID,
+				DialogueID:     dialogue.ID,
+				ParentID:       dialogue.ParentID,
+				Response:       response,
+				Thinking:       result.Thinking,
+				ContentBlocks:  result.StructuredBlocks(),
+				Citations:      result.Citations,
+				MessageLimit:   result.MessageLimit,
+				Cancelled:      true,
+			})
+			return
+		}
 		if err != nil {
 			dialogue.Status = "send_failed"
 			h.db.UpdateDialogue(dialogue)
 			LogExchange(req.Request, err.Error(), true)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message: " + err.Error()})
+			c.JSON(compatErrorStatus(err), gin.H{"error": "Failed to send message: " + err.Error()})
 			return
 		}
-		newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
-		if err == nil {
-			h.dialogueManager.UpdateSession(conversationID, newParentUUID)
-		}
+		h.advanceConversation(ctx, conversationID, dialogue, result)
 		dialogue.AssistantMessage = &response
 		dialogue.Status = "done"
 		h.db.UpdateDialogue(dialogue)
 		LogExchange(req.Request, response, false)
 		c.JSON(http.StatusOK, DialogueResponse{
 			ConversationID: conversationID,
+			DialogueID:     dialogue.ID,
+			ParentID:       dialogue.ParentID,
 			Response:       response,
+			Thinking:       result.Thinking,
+			ContentBlocks:  result.StructuredBlocks(),
+			Citations:      result.Citations,
+			StopReason:     result.StopReason,
+			MessageLimit:   result.MessageLimit,
 		})
 	default:
 		dialogue.Status = "send_failed"
@@ -227,11 +315,45 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 		sendWSError(conn, "Invalid request format")
 		return
 	}
+	if req.ConversationID != "" && !h.conversationAccessible(requestAPIAccess(c), req.ConversationID) {
+		sendWSError(conn, "Conversation not found")
+		return
+	}
+	branch, err := h.branchFrom(requestAPIAccess(c), req.ReplaceDialogueID, &req.Request)
+	if err != nil {
+		sendWSError(conn, err.Error())
+		return
+	}
 	if req.Request == "" {
 		sendWSError(conn, "Request cannot be empty")
 		return
 	}
-	if isBlocked, blockReason, blockResetTime := checkUsageLimits(); isBlocked {
+	timezone, locale := requestLocale(c)
+	if req.Timezone != "" {
+		timezone = req.Timezone
+	}
+	if req.Locale != "" {
+		locale = req.Locale
+	}
+	var output *StructuredOutput
+	model, err := h.config.ResolveModel(req.Model)
+	if err == nil {
+		err = checkModelFiles(model, req.Files)
+	}
+	if err == nil {
+		output, err = req.ResponseFormat.structuredOutput()
+	}
+	if err == nil {
+		_, err = resolveStyle(h.config, req.Style)
+	}
+	if err == nil {
+		err = validateLocale(timezone, locale)
+	}
+	if err != nil {
+		sendWSError(conn, err.Error())
+		return
+	}
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
 		log.Printf("[Usage Limit] WebSocket request blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
 		sendWSMessage(conn, "usage_blocked", map[string]any{
 			"error":            "Usage limit exceeded",
@@ -241,23 +363,24 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 		})
 		return
 	}
-	if globalMCPSessionManager != nil {
-		if err := globalMCPSessionManager.EnsureInitialized(); err != nil {
-			log.Printf("MCP initialization failed (continuing without MCP): %v", err)
-		}
-	}
-	cookie := h.config.GetCookie()
+	ctx, cancel := watchWSCancel(context.Background(), conn)
+	defer cancel()
 	var conversationID string
 	var parentMessageUUID string
-	if req.ConversationID != "" {
+	if branch != nil {
+		conversationID = branch.ConversationID
+		parentMessageUUID = branch.ParentMessageUUID
+		h.dialogueManager.GetOrCreateSession(conversationID)
+		h.dialogueManager.SetStreamMode(conversationID, true)
+	} else if req.ConversationID != "" {
 		conversationID = req.ConversationID
 		session := h.dialogueManager.GetOrCreateSession(conversationID)
 		h.dialogueManager.SetStreamMode(conversationID, true)
 		session.GeneratingMutex.RLock()
 		parentMessageUUID = session.LastMessageUUID
 		session.GeneratingMutex.RUnlock()
-		if parentMessageUUID == "00000000-0000-4000-8000-000000000000" {
-			newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
+		if parentMessageUUID == rootMessageUUID {
+			newParentUUID, err := h.lastMessageUUID(ctx, conversationID)
 			if err != nil {
 				sendWSError(conn, "Failed to get conversation history")
 				return
@@ -267,12 +390,12 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 		}
 	} else {
 		var err error
-		conversationID, err = createConversation(h.config.GetOrganizationID(), cookie, true)
+		conversationID, err = h.client.CreateConversation(ctx, true)
 		if err != nil {
 			sendWSError(conn, "Failed to create conversation")
 			return
 		}
-		parentMessageUUID = "00000000-0000-4000-8000-000000000000"
+		parentMessageUUID = rootMessageUUID
 		h.dialogueManager.GetOrCreateSession(conversationID)
 		h.dialogueManager.SetStreamMode(conversationID, true)
 	}
@@ -283,17 +406,22 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 		platform = "windows"
 	}
 	device, _ := h.db.GetOrCreateDevice(devicePassword, platform)
-	conv, _ := h.db.CreateConversation(device.ID, conversationID)
+	timezone, locale = resolveLocale(h.config, device, timezone, locale)
+	conv, _ := h.db.GetOrCreateConversation(device.ID, conversationID)
 	dialogueOrder, _ := h.db.GetNextDialogueOrder(conv.ID)
 	dialogueUID := uuid.New().String()
 	dialogue := &CldDialogue{
-		UID:            dialogueUID,
-		ConversationID: conv.ID,
-		Order:          dialogueOrder,
-		UserMessage:    req.Request,
-		CreateTime:     time.Now(),
-		Status:         "processing",
-		PromptID:       h.db.GetCurrentPromptID(),
+		UID:               dialogueUID,
+		ConversationID:    conv.ID,
+		Order:             dialogueOrder,
+		UserMessage:       req.Request,
+		InputTokens:       estimateInputTokens(output.systemPrompt(LoadSystemPrompt()), req.Request, req.Files),
+		CreateTime:        time.Now(),
+		Status:            "processing",
+		PromptID:          h.db.GetCurrentPromptID(),
+		ParentID:          h.db.GetParentDialogueID(conv.ID, parentMessageUUID),
+		ParentMessageUUID: &parentMessageUUID,
+		APIKeyID:          requestAPIAccess(c).keyID(),
 	}
 	h.db.CreateDialogue(dialogue)
 	session := h.dialogueManager.GetOrCreateSession(conversationID)
@@ -311,6 +439,8 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 	select {
 	case h.semaphore <- struct{}{}:
 		defer func() { <-h.semaphore }()
+		ctx, finishGeneration := h.dialogueManager.StartGeneration(ctx, conversationID, dialogue.ID, conv.DeviceID)
+		defer finishGeneration()
 		requestTime := time.Now()
 		dialogue.RequestTime = &requestTime
 		var attachments []FileAttachment
@@ -322,7 +452,7 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 					sendWSError(conn, fmt.Sprintf("File decode error: %v", err))
 					return
 				}
-				uploadResp, err := uploadFile(h.config.GetOrganizationID(), conversationID, cookie, &file)
+				uploadResp, err := h.client.UploadFile(ctx, conversationID, &file)
 				if err != nil {
 					dialogue.Status = "send_failed"
 					h.db.UpdateDialogue(dialogue)
@@ -337,28 +467,44 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 				})
 			}
 		}
-		response, err := sendDialogueMessageWithFiles(
-			h.config.GetOrganizationID(),
-			conversationID,
-			cookie,
-			req.Request,
-			parentMessageUUID,
-			req.Model,
-			req.Style,
-			attachments,
-			func(chunk string) {
-				if err := sendWSMessage(conn, "content", map[string]string{
-					"delta": chunk,
-					"text":  chunk,
-				}); err != nil {
-					log.Printf("发送流式内容失败: %v", err)
-				}
-			},
-		)
+		if len(attachments) > 0 {
+			dialogue.Attachments, _ = json.Marshal(attachments)
+		}
+		result, first, err := h.streamRepairedCompletion(ctx, CompletionRequest{
+			ConversationID:    conversationID,
+			Prompt:            req.Request,
+			ParentMessageUUID: parentMessageUUID,
+			Model:             model.ID,
+			Style:             req.Style,
+			Timezone:          timezone,
+			Locale:            locale,
+			SystemPrompt:      output.systemPrompt(LoadSystemPrompt()),
+			Attachments:       attachments,
+		}, output.validator(), func(ev StreamEvent, result *StreamResult) {
+			if !ev.IsTextDelta() {
+				forwardStreamEvent(ev, func(msgType string, data any) {
+					sendWSMessage(conn, msgType, data)
+				})
+				return
+			}
+			if err := sendWSMessage(conn, "content", map[string]string{
+				"delta": result.Text,
+				"text":  result.Text,
+			}); err != nil {
+				log.Printf("发送流式内容失败: %v", err)
+			}
+		})
+		response := output.reply(result.Text)
 		finishTime := time.Now()
 		dialogue.FinishTime = &finishTime
 		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
 		dialogue.Duration = &duration
+		applyRepairedResult(dialogue, first, result)
+		if errors.Is(err, context.Canceled) {
+			h.saveCancelledDialogue(dialogue, conversationID, result)
+			sendWSMessage(conn, "cancelled", cancelledPayload(conversationID, dialogue, result))
+			return
+		}
 		if err != nil {
 			dialogue.Status = "send_failed"
 			h.db.UpdateDialogue(dialogue)
@@ -366,17 +512,21 @@ func (h *Handler) DialogueStream(c *gin.Context) {
 			sendWSError(conn, "Failed to send message: "+err.Error())
 			return
 		}
-		newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
-		if err == nil {
-			h.dialogueManager.UpdateSession(conversationID, newParentUUID)
-		}
+		h.advanceConversation(ctx, conversationID, dialogue, result)
 		dialogue.AssistantMessage = &response
 		dialogue.Status = "done"
 		h.db.UpdateDialogue(dialogue)
 		LogExchange(req.Request, response, false)
 		sendWSMessage(conn, "done", map[string]any{
 			"conversation_id": conversationID,
+			"dialogue_id":     dialogue.ID,
+			"parent_id":       dialogue.ParentID,
 			"response":        response,
+			"thinking":        result.Thinking,
+			"content_blocks":  result.StructuredBlocks(),
+			"citations":       result.Citations,
+			"stop_reason":     result.StopReason,
+			"message_limit":   result.MessageLimit,
 			"done":            true,
 		})
 	default:
@@ -392,6 +542,10 @@ func (h *Handler) KeepAlive(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing conversation ID"})
 		return
 	}
+	if !h.conversationAccessible(requestAPIAccess(c), conversationID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
 	h.dialogueManager.TouchSession(conversationID)
 	c.JSON(http.StatusOK, gin.H{
 		"conversation_id": conversationID,
@@ -400,6 +554,107 @@ func (h *Handler) KeepAlive(c *gin.Context) {
 	})
 }
 
+func (h *Handler) CancelGeneration(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing dialogue ID"})
+		return
+	}
+	fingerprint := c.GetHeader("X-Device-ID")
+	if fingerprint == "" {
+		fingerprint = c.Query("device_id")
+	}
+	deviceID, ok := h.generationOwner(requestAPIAccess(c), fingerprint)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
+		return
+	}
+	generation, ok := h.dialogueManager.CancelGeneration(id, deviceID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active generation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"conversation_id": generation.ConversationID,
+		"dialogue_id":     generation.DialogueID,
+		"message":         "Generation cancelled",
+	})
+}
+
+func (h *Handler) conversationAccessible(access *APIAccess, uid string) bool {
+	if access.boundDeviceID() == nil {
+		return true
+	}
+	conv, err := h.db.GetConversationByUID(uid)
+	return errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && access.ownsConversation(conv))
+}
+
+func (h *Handler) generationOwner(access *APIAccess, fingerprint string) (*int, bool) {
+	if access.Admin != nil {
+		return nil, true
+	}
+	if deviceID := access.boundDeviceID(); deviceID != nil {
+		return deviceID, true
+	}
+	if fingerprint == "" {
+		return nil, false
+	}
+	deviceID := 0
+	if device, err := h.db.GetDeviceByFingerprint(fingerprint); err == nil {
+		deviceID = device.ID
+	}
+	return &deviceID, true
+}
+
+func (h *Handler) saveCancelledDialogue(dialogue *CldDialogue, conversationID string, result *StreamResult) {
+	response := result.Text
+	finishReason := "cancelled"
+	dialogue.AssistantMessage = &response
+	dialogue.FinishReason = &finishReason
+	dialogue.Status = "cancelled"
+	h.db.UpdateDialogue(dialogue)
+	if result.MessageUUID != "" {
+		h.dialogueManager.UpdateSession(conversationID, result.MessageUUID)
+		h.db.SetCurrentDialogue(dialogue.ConversationID, dialogue.ID)
+	} else {
+		h.dialogueManager.UpdateSession(conversationID, rootMessageUUID)
+	}
+	log.Printf("⏹ 对话生成已取消: %s (dialogue: %d, 已生成 %d 字符)", conversationID, dialogue.ID, len([]rune(response)))
+}
+
+func cancelledPayload(conversationID string, dialogue *CldDialogue, result *StreamResult) map[string]any {
+	return map[string]any{
+		"conversation_id": conversationID,
+		"dialogue_id":     dialogue.ID,
+		"parent_id":       dialogue.ParentID,
+		"response":        result.Text,
+		"thinking":        result.Thinking,
+		"content_blocks":  result.StructuredBlocks(),
+		"citations":       result.Citations,
+		"cancelled":       true,
+		"done":            true,
+	}
+}
+
+func watchWSCancel(parent context.Context, conn *websocket.Conn) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		defer cancel()
+		for {
+			var msg WSMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "cancel" {
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+var wsWriteLocks sync.Map
+
 func sendWSMessage(conn *websocket.Conn, msgType string, data any) error {
 	msg := WSMessage{
 		Type: msgType,
@@ -408,6 +663,10 @@ func sendWSMessage(conn *websocket.Conn, msgType string, data any) error {
 	if msgType != "content" {
 		DebugLogResponse(msgType, data)
 	}
+	if lock, ok := wsWriteLocks.Load(conn); ok {
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+	}
 	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
 	return conn.WriteJSON(msg)
 }
@@ -438,13 +697,35 @@ func (h *Handler) DialogueEvent(c *gin.Context) {
 	}
 	request := c.Query("request")
 	conversationID := c.Query("conversation_id")
-	model := c.Query("model")
+	modelName := c.Query("model")
 	style := c.Query("style")
+	replaceDialogueID, _ := strconv.Atoi(c.Query("replace_dialogue_id"))
+	if conversationID != "" && !h.conversationAccessible(requestAPIAccess(c), conversationID) {
+		sendSSEError(c.Writer, flusher, "Conversation not found")
+		return
+	}
+	branch, err := h.branchFrom(requestAPIAccess(c), replaceDialogueID, &request)
+	if err != nil {
+		sendSSEError(c.Writer, flusher, err.Error())
+		return
+	}
 	if request == "" {
 		sendSSEError(c.Writer, flusher, "Request cannot be empty")
 		return
 	}
-	if isBlocked, blockReason, blockResetTime := checkUsageLimits(); isBlocked {
+	timezone, locale := requestLocale(c)
+	model, err := h.config.ResolveModel(modelName)
+	if err == nil {
+		_, err = resolveStyle(h.config, style)
+	}
+	if err == nil {
+		err = validateLocale(timezone, locale)
+	}
+	if err != nil {
+		sendSSEError(c.Writer, flusher, err.Error())
+		return
+	}
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
 		log.Printf("[Usage Limit] SSE request blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
 		sendSSEEvent(c.Writer, flusher, "usage_blocked", map[string]any{
 			"error":            "Usage limit exceeded",
@@ -454,21 +735,21 @@ func (h *Handler) DialogueEvent(c *gin.Context) {
 		})
 		return
 	}
-	if globalMCPSessionManager != nil {
-		if err := globalMCPSessionManager.EnsureInitialized(); err != nil {
-			log.Printf("MCP initialization failed (continuing without MCP): %v", err)
-		}
-	}
-	cookie := h.config.GetCookie()
+	ctx := c.Request.Context()
 	var parentMessageUUID string
-	if conversationID != "" {
+	if branch != nil {
+		conversationID = branch.ConversationID
+		parentMessageUUID = branch.ParentMessageUUID
+		h.dialogueManager.GetOrCreateSession(conversationID)
+		h.dialogueManager.SetStreamMode(conversationID, true)
+	} else if conversationID != "" {
 		session := h.dialogueManager.GetOrCreateSession(conversationID)
 		h.dialogueManager.SetStreamMode(conversationID, true)
 		session.GeneratingMutex.RLock()
 		parentMessageUUID = session.LastMessageUUID
 		session.GeneratingMutex.RUnlock()
-		if parentMessageUUID == "00000000-0000-4000-8000-000000000000" {
-			newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
+		if parentMessageUUID == rootMessageUUID {
+			newParentUUID, err := h.lastMessageUUID(ctx, conversationID)
 			if err != nil {
 				sendSSEError(c.Writer, flusher, "Failed to get conversation history")
 				return
@@ -478,12 +759,12 @@ func (h *Handler) DialogueEvent(c *gin.Context) {
 		}
 	} else {
 		var err error
-		conversationID, err = createConversation(h.config.GetOrganizationID(), cookie, true)
+		conversationID, err = h.client.CreateConversation(ctx, true)
 		if err != nil {
 			sendSSEError(c.Writer, flusher, "Failed to create conversation")
 			return
 		}
-		parentMessageUUID = "00000000-0000-4000-8000-000000000000"
+		parentMessageUUID = rootMessageUUID
 		h.dialogueManager.GetOrCreateSession(conversationID)
 		h.dialogueManager.SetStreamMode(conversationID, true)
 	}
@@ -494,17 +775,22 @@ func (h *Handler) DialogueEvent(c *gin.Context) {
 		platform = "windows"
 	}
 	device, _ := h.db.GetOrCreateDevice(devicePassword, platform)
-	conv, _ := h.db.CreateConversation(device.ID, conversationID)
+	timezone, locale = resolveLocale(h.config, device, timezone, locale)
+	conv, _ := h.db.GetOrCreateConversation(device.ID, conversationID)
 	dialogueOrder, _ := h.db.GetNextDialogueOrder(conv.ID)
 	dialogueUID := uuid.New().String()
 	dialogue := &CldDialogue{
-		UID:            dialogueUID,
-		ConversationID: conv.ID,
-		Order:          dialogueOrder,
-		UserMessage:    request,
-		CreateTime:     time.Now(),
-		Status:         "processing",
-		PromptID:       h.db.GetCurrentPromptID(),
+		UID:               dialogueUID,
+		ConversationID:    conv.ID,
+		Order:             dialogueOrder,
+		UserMessage:       request,
+		InputTokens:       estimateInputTokens(LoadSystemPrompt(), request, nil),
+		CreateTime:        time.Now(),
+		Status:            "processing",
+		PromptID:          h.db.GetCurrentPromptID(),
+		ParentID:          h.db.GetParentDialogueID(conv.ID, parentMessageUUID),
+		ParentMessageUUID: &parentMessageUUID,
+		APIKeyID:          requestAPIAccess(c).keyID(),
 	}
 	h.db.CreateDialogue(dialogue)
 	session := h.dialogueManager.GetOrCreateSession(conversationID)
@@ -522,28 +808,42 @@ func (h *Handler) DialogueEvent(c *gin.Context) {
 	select {
 	case h.semaphore <- struct{}{}:
 		defer func() { <-h.semaphore }()
+		ctx, finishGeneration := h.dialogueManager.StartGeneration(ctx, conversationID, dialogue.ID, conv.DeviceID)
+		defer finishGeneration()
 		requestTime := time.Now()
 		dialogue.RequestTime = &requestTime
-		response, err := sendDialogueMessageWithFiles(
-			h.config.GetOrganizationID(),
-			conversationID,
-			cookie,
-			request,
-			parentMessageUUID,
-			model,
-			style,
-			nil,
-			func(chunk string) {
-				sendSSEEvent(c.Writer, flusher, "content", map[string]string{
-					"delta": chunk,
-					"text":  chunk,
+		result, err := streamCompletion(ctx, h.client, CompletionRequest{
+			ConversationID:    conversationID,
+			Prompt:            request,
+			ParentMessageUUID: parentMessageUUID,
+			Model:             model.ID,
+			Style:             style,
+			Timezone:          timezone,
+			Locale:            locale,
+			SystemPrompt:      LoadSystemPrompt(),
+		}, func(ev StreamEvent, result *StreamResult) {
+			if !ev.IsTextDelta() {
+				forwardStreamEvent(ev, func(msgType string, data any) {
+					sendSSEEvent(c.Writer, flusher, msgType, data)
 				})
-			},
-		)
+				return
+			}
+			sendSSEEvent(c.Writer, flusher, "content", map[string]string{
+				"delta": result.Text,
+				"text":  result.Text,
+			})
+		})
+		response := result.Text
 		finishTime := time.Now()
 		dialogue.FinishTime = &finishTime
 		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
 		dialogue.Duration = &duration
+		dialogue.ApplyStreamResult(result)
+		if errors.Is(err, context.Canceled) {
+			h.saveCancelledDialogue(dialogue, conversationID, result)
+			sendSSEEvent(c.Writer, flusher, "cancelled", cancelledPayload(conversationID, dialogue, result))
+			return
+		}
 		if err != nil {
 			dialogue.Status = "send_failed"
 			h.db.UpdateDialogue(dialogue)
@@ -551,17 +851,21 @@ func (h *Handler) DialogueEvent(c *gin.Context) {
 			sendSSEError(c.Writer, flusher, "Failed to send message: "+err.Error())
 			return
 		}
-		newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
-		if err == nil {
-			h.dialogueManager.UpdateSession(conversationID, newParentUUID)
-		}
+		h.advanceConversation(ctx, conversationID, dialogue, result)
 		dialogue.AssistantMessage = &response
 		dialogue.Status = "done"
 		h.db.UpdateDialogue(dialogue)
 		LogExchange(request, response, false)
 		sendSSEEvent(c.Writer, flusher, "done", map[string]any{
 			"conversation_id": conversationID,
+			"dialogue_id":     dialogue.ID,
+			"parent_id":       dialogue.ParentID,
 			"response":        response,
+			"thinking":        result.Thinking,
+			"content_blocks":  result.StructuredBlocks(),
+			"citations":       result.Citations,
+			"stop_reason":     result.StopReason,
+			"message_limit":   result.MessageLimit,
 			"done":            true,
 		})
 	default:
@@ -620,14 +924,21 @@ func (h *Handler) PersistentWebSocket(c *gin.Context) {
 		}
 	}
 	c.Set("device_id", devicePassword)
+	access := requestAPIAccess(c)
+	wsWriteLocks.Store(conn, &sync.Mutex{})
+	defer wsWriteLocks.Delete(conn)
+	if deviceID != 0 {
+		deviceConns.Store(conn, deviceID)
+		defer deviceConns.Delete(conn)
+	}
+	ctx := context.WithValue(context.Background(), apiAccessContextKey, access)
 	conn.SetPongHandler(func(appData string) error {
 		conn.SetReadDeadline(time.Now().Add(120 * time.Second))
 		return nil
 	})
 	conn.SetPingHandler(func(appData string) error {
 		conn.SetReadDeadline(time.Now().Add(120 * time.Second))
-		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-		return conn.WriteMessage(websocket.PongMessage, []byte(appData))
+		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(10*time.Second))
 	})
 	sendWSMessage(conn, "connected", map[string]string{
 		"status":  "connected",
@@ -644,8 +955,7 @@ func (h *Handler) PersistentWebSocket(c *gin.Context) {
 			case <-done:
 				return
 			case <-ticker.C:
-				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+				if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second)); err != nil {
 					log.Printf("WebSocket ping 发送失败: %v", err)
 					return
 				}
@@ -669,13 +979,21 @@ func (h *Handler) PersistentWebSocket(c *gin.Context) {
 			continue
 		}
 		DebugLogRequest(msgType, msg)
+		if err := access.authorizeWSMessage(msgType, msg); err != nil {
+			sendWSError(conn, err.Error())
+			continue
+		}
 		switch msgType {
 		case "dialogue":
-			h.handleWSDialogueRequest(conn, msg)
+			go h.handleWSDialogueRequest(ctx, conn, msg)
+		case "regenerate", "edit":
+			go h.handleWSBranchRequest(ctx, conn, msg, msgType == "regenerate")
+		case "cancel":
+			h.handleWSCancel(ctx, conn, msg, devicePassword)
 		case "keepalive":
 			h.handleWSKeepalive(conn, msg)
 		case "api_request":
-			h.handleWSAPIRequest(conn, msg)
+			h.handleWSAPIRequest(access, conn, msg)
 		case "ping":
 			sendWSMessage(conn, "pong", map[string]string{"timestamp": time.Now().Format(time.RFC3339)})
 		case "ack":
@@ -686,7 +1004,20 @@ func (h *Handler) PersistentWebSocket(c *gin.Context) {
 	}
 }
 
-func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]any) {
+func wsResponseFormat(data map[string]any) (*StructuredOutput, error) {
+	raw, ok := data["response_format"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	encoded, _ := json.Marshal(raw)
+	var format ResponseFormat
+	if err := json.Unmarshal(encoded, &format); err != nil {
+		return nil, fmt.Errorf("invalid response_format: %v", err)
+	}
+	return format.structuredOutput()
+}
+
+func (h *Handler) handleWSDialogueRequest(ctx context.Context, conn *websocket.Conn, msg map[string]any) {
 	data, ok := msg["data"].(map[string]any)
 	if !ok {
 		sendWSError(conn, "Invalid dialogue request: missing data field")
@@ -694,18 +1025,56 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 	}
 	request, _ := data["request"].(string)
 	conversationID, _ := data["conversation_id"].(string)
-	model, _ := data["model"].(string)
+	modelName, _ := data["model"].(string)
 	style, _ := data["style"].(string)
 	devicePassword, _ := data["device_id"].(string)
 	if devicePassword == "" {
 		sendWSError(conn, "Device ID is required")
 		return
 	}
+	if state := serviceUnavailable(h.config, h.db); state != nil {
+		log.Printf("[Service] Persistent WebSocket request blocked - Reason: %s", state.Reason)
+		sendWSMessage(conn, "service_unavailable", map[string]any{
+			"error":       "Service temporarily unavailable",
+			"reason":      state.Reason,
+			"cause":       state.Cause,
+			"recover_at":  state.RecoverAt,
+			"retry_after": state.retryAfter(),
+		})
+		return
+	}
+	if conversationID != "" && !h.conversationAccessible(requestAPIAccess(ctx), conversationID) {
+		sendWSError(conn, "Conversation not found")
+		return
+	}
+	replaceDialogueID, _ := data["replace_dialogue_id"].(float64)
+	branch, err := h.branchFrom(requestAPIAccess(ctx), int(replaceDialogueID), &request)
+	if err != nil {
+		sendWSError(conn, err.Error())
+		return
+	}
 	if request == "" {
 		sendWSError(conn, "Request cannot be empty")
 		return
 	}
-	if isBlocked, blockReason, blockResetTime := checkUsageLimits(); isBlocked {
+	timezone, _ := data["timezone"].(string)
+	locale, _ := data["locale"].(string)
+	var output *StructuredOutput
+	model, err := h.config.ResolveModel(modelName)
+	if err == nil {
+		output, err = wsResponseFormat(data)
+	}
+	if err == nil {
+		_, err = resolveStyle(h.config, style)
+	}
+	if err == nil {
+		err = validateLocale(timezone, locale)
+	}
+	if err != nil {
+		sendWSError(conn, err.Error())
+		return
+	}
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
 		log.Printf("[Usage Limit] Persistent WebSocket request blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
 		sendWSMessage(conn, "usage_blocked", map[string]any{
 			"error":            "Usage limit exceeded",
@@ -727,6 +1096,7 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 		sendWSError(conn, "Device creation failed")
 		return
 	}
+	timezone, locale = resolveLocale(h.config, device, timezone, locale)
 	isBanned, banReason, _ := h.db.IsDeviceBanned(device.ID)
 	if isBanned {
 		sendWSMessage(conn, "banned", map[string]any{
@@ -735,21 +1105,25 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 		})
 		return
 	}
-	if globalMCPSessionManager != nil {
-		if err := globalMCPSessionManager.EnsureInitialized(); err != nil {
-			log.Printf("MCP initialization failed (continuing without MCP): %v", err)
-		}
+	if status := quotaExceeded(h.config, h.db, requestAPIAccess(ctx), device); status != nil {
+		log.Printf("[Quota] Persistent WebSocket request blocked - Reason: %s, Retry after: %ds", status.Reason, status.RetryAfter)
+		sendWSMessage(conn, "quota_exceeded", status)
+		return
 	}
-	cookie := h.config.GetCookie()
 	var parentMessageUUID string
-	if conversationID != "" {
+	if branch != nil {
+		conversationID = branch.ConversationID
+		parentMessageUUID = branch.ParentMessageUUID
+		h.dialogueManager.GetOrCreateSession(conversationID)
+		h.dialogueManager.SetStreamMode(conversationID, true)
+	} else if conversationID != "" {
 		session := h.dialogueManager.GetOrCreateSession(conversationID)
 		h.dialogueManager.SetStreamMode(conversationID, true)
 		session.GeneratingMutex.RLock()
 		parentMessageUUID = session.LastMessageUUID
 		session.GeneratingMutex.RUnlock()
-		if parentMessageUUID == "00000000-0000-4000-8000-000000000000" {
-			newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
+		if parentMessageUUID == rootMessageUUID {
+			newParentUUID, err := h.lastMessageUUID(ctx, conversationID)
 			if err != nil {
 				sendWSError(conn, "Failed to get conversation history")
 				return
@@ -759,35 +1133,36 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 		}
 	} else {
 		var err error
-		conversationID, err = createConversation(h.config.GetOrganizationID(), cookie, true)
+		conversationID, err = h.client.CreateConversation(ctx, true)
 		if err != nil {
 			sendWSError(conn, "Failed to create conversation")
 			return
 		}
-		parentMessageUUID = "00000000-0000-4000-8000-000000000000"
+		parentMessageUUID = rootMessageUUID
 		h.dialogueManager.GetOrCreateSession(conversationID)
 		h.dialogueManager.SetStreamMode(conversationID, true)
 	}
 	sendWSMessage(conn, "conversation_id", map[string]string{"conversation_id": conversationID})
-	conv, err := h.db.CreateConversation(device.ID, conversationID)
+	conv, err := h.db.GetOrCreateConversation(device.ID, conversationID)
 	if err != nil || conv == nil {
-		conv, err = h.db.GetConversationByUID(conversationID)
-		if err != nil || conv == nil {
-			log.Printf("无法创建或获取对话: %v", err)
-			sendWSMessage(conn, "error", map[string]string{"error": "无法创建对话"})
-			return
-		}
+		log.Printf("无法创建或获取对话: %v", err)
+		sendWSMessage(conn, "error", map[string]string{"error": "无法创建对话"})
+		return
 	}
 	dialogueOrder, _ := h.db.GetNextDialogueOrder(conv.ID)
 	dialogueUID := uuid.New().String()
 	dialogue := &CldDialogue{
-		UID:            dialogueUID,
-		ConversationID: conv.ID,
-		Order:          dialogueOrder,
-		UserMessage:    request,
-		CreateTime:     time.Now(),
-		Status:         "processing",
-		PromptID:       h.db.GetCurrentPromptID(),
+		UID:               dialogueUID,
+		ConversationID:    conv.ID,
+		Order:             dialogueOrder,
+		UserMessage:       request,
+		InputTokens:       estimateInputTokens(output.systemPrompt(LoadSystemPrompt()), request, nil),
+		CreateTime:        time.Now(),
+		Status:            "processing",
+		PromptID:          h.db.GetCurrentPromptID(),
+		ParentID:          h.db.GetParentDialogueID(conv.ID, parentMessageUUID),
+		ParentMessageUUID: &parentMessageUUID,
+		APIKeyID:          requestAPIAccess(ctx).keyID(),
 	}
 	h.db.CreateDialogue(dialogue)
 	session := h.dialogueManager.GetOrCreateSession(conversationID)
@@ -804,30 +1179,44 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 	select {
 	case h.semaphore <- struct{}{}:
 		defer func() { <-h.semaphore }()
+		ctx, finishGeneration := h.dialogueManager.StartGeneration(ctx, conversationID, dialogue.ID, conv.DeviceID)
+		defer finishGeneration()
 		requestTime := time.Now()
 		dialogue.RequestTime = &requestTime
-		response, err := sendDialogueMessageWithFiles(
-			h.config.GetOrganizationID(),
-			conversationID,
-			cookie,
-			request,
-			parentMessageUUID,
-			model,
-			style,
-			nil,
-			func(chunk string) {
-				if err := sendWSMessage(conn, "content", map[string]string{
-					"delta": chunk,
-					"text":  chunk,
-				}); err != nil {
-					log.Printf("发送流式内容失败: %v", err)
-				}
-			},
-		)
+		result, first, err := h.streamRepairedCompletion(ctx, CompletionRequest{
+			ConversationID:    conversationID,
+			Prompt:            request,
+			ParentMessageUUID: parentMessageUUID,
+			Model:             model.ID,
+			Style:             style,
+			Timezone:          timezone,
+			Locale:            locale,
+			SystemPrompt:      output.systemPrompt(LoadSystemPrompt()),
+		}, output.validator(), func(ev StreamEvent, result *StreamResult) {
+			if !ev.IsTextDelta() {
+				forwardStreamEvent(ev, func(msgType string, data any) {
+					sendWSMessage(conn, msgType, data)
+				})
+				return
+			}
+			if err := sendWSMessage(conn, "content", map[string]string{
+				"delta": result.Text,
+				"text":  result.Text,
+			}); err != nil {
+				log.Printf("发送流式内容失败: %v", err)
+			}
+		})
+		response := output.reply(result.Text)
 		finishTime := time.Now()
 		dialogue.FinishTime = &finishTime
 		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
 		dialogue.Duration = &duration
+		applyRepairedResult(dialogue, first, result)
+		if errors.Is(err, context.Canceled) {
+			h.saveCancelledDialogue(dialogue, conversationID, result)
+			sendWSMessage(conn, "cancelled", cancelledPayload(conversationID, dialogue, result))
+			return
+		}
 		if err != nil {
 			dialogue.Status = "send_failed"
 			h.db.UpdateDialogue(dialogue)
@@ -835,10 +1224,7 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 			sendWSError(conn, "Failed to send message: "+err.Error())
 			return
 		}
-		newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), conversationID, cookie)
-		if err == nil {
-			h.dialogueManager.UpdateSession(conversationID, newParentUUID)
-		}
+		h.advanceConversation(ctx, conversationID, dialogue, result)
 		dialogue.AssistantMessage = &response
 		dialogue.Status = "replying"
 		h.db.UpdateDialogue(dialogue)
@@ -848,7 +1234,13 @@ func (h *Handler) handleWSDialogueRequest(conn *websocket.Conn, msg map[string]a
 		sendWSMessage(conn, "done", map[string]any{
 			"conversation_id": conversationID,
 			"dialogue_id":     dialogue.ID,
+			"parent_id":       dialogue.ParentID,
 			"response":        response,
+			"thinking":        result.Thinking,
+			"content_blocks":  result.StructuredBlocks(),
+			"citations":       result.Citations,
+			"stop_reason":     result.StopReason,
+			"message_limit":   result.MessageLimit,
 			"done":            true,
 		})
 		go func(dialogueID int) {
@@ -890,6 +1282,40 @@ func (h *Handler) handleWSKeepalive(conn *websocket.Conn, msg map[string]any) {
 	})
 }
 
+func (h *Handler) handleWSCancel(ctx context.Context, conn *websocket.Conn, msg map[string]any, devicePassword string) {
+	data, ok := msg["data"].(map[string]any)
+	if !ok {
+		sendWSError(conn, "Invalid cancel request: missing data field")
+		return
+	}
+	id, _ := data["conversation_id"].(string)
+	if dialogueID, ok := data["dialogue_id"].(float64); ok && id == "" {
+		id = fmt.Sprintf("%d", int(dialogueID))
+	}
+	if id == "" {
+		sendWSError(conn, "Invalid cancel request: missing conversation_id or dialogue_id")
+		return
+	}
+	if fingerprint, _ := data["device_id"].(string); fingerprint != "" {
+		devicePassword = fingerprint
+	}
+	deviceID, ok := h.generationOwner(requestAPIAccess(ctx), devicePassword)
+	if !ok {
+		sendWSError(conn, "Device ID is required")
+		return
+	}
+	generation, ok := h.dialogueManager.CancelGeneration(id, deviceID)
+	if !ok {
+		sendWSError(conn, "No active generation")
+		return
+	}
+	sendWSMessage(conn, "cancel_received", map[string]any{
+		"conversation_id": generation.ConversationID,
+		"dialogue_id":     generation.DialogueID,
+		"status":          "ok",
+	})
+}
+
 func (h *Handler) handleWSAck(conn *websocket.Conn, msg map[string]any) {
 	data, ok := msg["data"].(map[string]any)
 	if !ok {
@@ -921,7 +1347,7 @@ func (h *Handler) handleWSAck(conn *websocket.Conn, msg map[string]any) {
 	})
 }
 
-func (h *Handler) handleWSAPIRequest(conn *websocket.Conn, msg map[string]any) {
+func (h *Handler) handleWSAPIRequest(access *APIAccess, conn *websocket.Conn, msg map[string]any) {
 	data, ok := msg["data"].(map[string]any)
 	if !ok {
 		sendWSError(conn, "Invalid API request: missing data field")
@@ -946,10 +1372,21 @@ func (h *Handler) handleWSAPIRequest(conn *websocket.Conn, msg map[string]any) {
 	} else if strings.HasPrefix(endpoint, "/api/dialogues/") && strings.HasSuffix(endpoint, "/history") {
 		dialogueID = strings.TrimSuffix(strings.TrimPrefix(endpoint, "/api/dialogues/"), "/history")
 		endpoint = "/api/dialogues/:id/history"
+	} else if strings.HasPrefix(endpoint, "/api/dialogues/") && strings.HasSuffix(endpoint, "/sync") {
+		dialogueID = strings.TrimSuffix(strings.TrimPrefix(endpoint, "/api/dialogues/"), "/sync")
+		endpoint = "/api/dialogues/:id/sync"
 	} else if strings.HasPrefix(endpoint, "/api/dialogues/") && !strings.Contains(strings.TrimPrefix(endpoint, "/api/dialogues/"), "/") {
 		dialogueID = strings.TrimPrefix(endpoint, "/api/dialogues/")
 		endpoint = "/api/dialogues/:id"
 	}
+	if (endpoint == "/api/dialogues/:id/sync" || endpoint == "/api/dialogues/:id") && !access.allows(scopeChat) {
+		sendWSMessage(conn, "error", map[string]any{
+			"request_id": requestID,
+			"error":      fmt.Sprintf("API key lacks required scope: %s", scopeChat),
+		})
+		return
+	}
+	deviceID := access.boundDeviceID()
 	switch endpoint {
 	case "/api/stats":
 		stats := h.db.GetStats()
@@ -961,13 +1398,18 @@ func (h *Handler) handleWSAPIRequest(conn *websocket.Conn, msg map[string]any) {
 			"tpm":              tpm,
 			"rpm":              rpm,
 			"rpd":              rpd,
-			"service_shutdown": stats.ServiceShutdown,
-			"shutdown_reason":  stats.ShutdownReason,
+			"tokens_estimated": true,
+			"service_shutdown": stats.Service.Shutdown,
+			"shutdown_reason":  stats.Service.Reason,
+			"shutdown_cause":   stats.Service.Cause,
+			"shutdown_since":   stats.Service.Since,
+			"recover_at":       stats.Service.RecoverAt,
+			"service_override": stats.Service.Override,
 		}
 	case "/api/usage":
 		responseData = getUsage()
 	case "/api/dialogues":
-		conversations, err := h.db.GetAllConversations()
+		conversations, err := h.db.GetConversationInfos(deviceID)
 		if err != nil {
 			responseData = map[string]any{"conversations": []any{}}
 		} else {
@@ -976,25 +1418,70 @@ func (h *Handler) handleWSAPIRequest(conn *websocket.Conn, msg map[string]any) {
 	case "/api/dialogues/:id/history":
 		var convID int
 		fmt.Sscanf(dialogueID, "%d", &convID)
-		dialogues, err := h.db.GetConversationDialogues(convID)
-		if err != nil {
+		var dialogues []CldDialogue
+		conv, err := h.db.GetConversation(convID)
+		if err == nil && access.ownsConversation(conv) {
+			dialogues, err = h.db.GetConversationDialogues(conv.ID)
+		}
+		if err != nil || dialogues == nil {
 			responseData = map[string]any{"messages": []any{}}
 		} else {
 			responseData = map[string]any{"messages": dialogues}
 		}
+	case "/api/dialogues/:id/sync":
+		var convID int
+		fmt.Sscanf(dialogueID, "%d", &convID)
+		conv, err := h.db.GetConversation(convID)
+		if err != nil || !access.ownsConversation(conv) {
+			sendWSMessage(conn, "error", map[string]any{
+				"request_id": requestID,
+				"error":      "Conversation not found",
+			})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		result, err := h.syncConversation(ctx, conv)
+		cancel()
+		if err != nil {
+			sendWSMessage(conn, "error", map[string]any{
+				"request_id": requestID,
+				"error":      "Failed to sync conversation: " + err.Error(),
+			})
+			return
+		}
+		broadcastDialogues()
+		responseData = map[string]any{
+			"conversation_id":     result.ConversationID,
+			"messages":            result.Messages,
+			"created":             result.Created,
+			"updated":             result.Updated,
+			"current_dialogue_id": result.CurrentDialogueID,
+		}
 	case "/api/dialogues/:id":
+		if deviceID != nil {
+			if conv, err := h.db.GetConversationByUID(dialogueID); err != nil || !access.ownsConversation(conv) {
+				sendWSMessage(conn, "error", map[string]any{
+					"request_id": requestID,
+					"error":      "Conversation not found",
+				})
+				return
+			}
+		}
 		h.dialogueManager.DeleteSession(dialogueID)
 		broadcastDialogues()
 		responseData = map[string]any{"message": "Dialogue deleted successfully"}
 	case "/api/record/:id":
 		var msgID int
 		fmt.Sscanf(recordID, "%d", &msgID)
-		dialogue, err := h.db.GetDialogueByID(msgID)
-		if err == nil {
-			responseData = dialogue
-		} else {
-			err = fmt.Errorf("Record not found")
+		dialogue, conv, _, err := h.db.GetDialogueWithConversation(msgID)
+		if err != nil || !access.ownsConversation(conv) {
+			sendWSMessage(conn, "error", map[string]any{
+				"request_id": requestID,
+				"error":      "Record not found",
+			})
+			return
 		}
+		responseData = dialogue
 	case "/api/records":
 		body, _ := data["body"].(map[string]any)
 		limit := 100
@@ -1003,7 +1490,13 @@ func (h *Handler) handleWSAPIRequest(conn *websocket.Conn, msg map[string]any) {
 				limit = int(l)
 			}
 		}
-		messages, err := h.db.GetHistory(limit)
+		var messages []CldDialogue
+		var err error
+		if deviceID != nil {
+			messages, err = h.db.GetDeviceHistory(*deviceID, limit)
+		} else {
+			messages, err = h.db.GetHistory(limit)
+		}
 		if err != nil {
 			responseData = map[string]any{"messages": []any{}}
 		} else {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"claude-server/fakeclaude"
+)
+
+func TestCancelGeneration(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.SlowReply("one two three four five six seven eight", 200*time.Millisecond))
+	done := make(chan DialogueResponse, 1)
+	go func() {
+		var dialogue DialogueResponse
+		resp := env.do(http.MethodPost, "/chat/dialogue/http", "device-cancel", map[string]any{"request": "Count slowly"})
+		data, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(data, &dialogue)
+		done <- dialogue
+	}()
+	requests := env.waitForRequests(fakeclaude.RouteCompletion, 1)
+	conversationID := conversationFromPath(requests[0].Path)
+	path := "/chat/dialogue/" + conversationID + "/generation"
+	env.doJSON(http.MethodDelete, path, "", nil, http.StatusBadRequest, nil)
+	env.doJSON(http.MethodDelete, path, "device-other", nil, http.StatusNotFound, nil)
+	var cancelled struct {
+		ConversationID string `json:"conversation_id"`
+		DialogueID     int    `json:"dialogue_id"`
+	}
+	env.doJSON(http.MethodDelete, path, "device-cancel", nil, http.StatusOK, &cancelled)
+	if cancelled.ConversationID != conversationID {
+		t.Fatalf("cancelled %s, want %s", cancelled.ConversationID, conversationID)
+	}
+	var dialogue DialogueResponse
+	select {
+	case dialogue = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("dialogue request did not return after cancel")
+	}
+	if !dialogue.Cancelled || dialogue.DialogueID != cancelled.DialogueID {
+		t.Fatalf("got %+v, want a cancelled response for dialogue %d", dialogue, cancelled.DialogueID)
+	}
+	stored, err := env.db.GetDialogueByID(dialogue.DialogueID)
+	if err != nil || stored.Status != "cancelled" {
+		t.Fatalf("stored dialogue %+v (%v), want status cancelled", stored, err)
+	}
+	env.waitForRequests(fakeclaude.RouteStopResponse, 1)
+	env.doJSON(http.MethodDelete, path, "device-cancel", nil, http.StatusNotFound, nil)
+}
+
+func TestBoundKeyCannotUseOtherDeviceConversation(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("Hello"))
+	var first DialogueResponse
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-owner", map[string]any{"request": "Hi"}, http.StatusOK, &first)
+	owner, _ := env.db.GetDeviceByFingerprint("device-owner")
+	other, _ := env.db.GetOrCreateDevice("device-other", "windows")
+	otherKey, _, err := issueAPIKey(env.db, "other", scopeChat, &other.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, _, err := issueAPIKey(env.db, "owner", scopeChat, &owner.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.apiKey = otherKey
+	continueRequest := map[string]any{"request": "Again", "conversation_id": first.ConversationID}
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-other", continueRequest, http.StatusNotFound, nil)
+	env.doJSON(http.MethodPost, "/chat/dialogue/keepalive/"+first.ConversationID, "device-other", nil, http.StatusNotFound, nil)
+	env.doJSON(http.MethodDelete, "/chat/dialogue/"+first.ConversationID, "device-other", nil, http.StatusNotFound, nil)
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 1 {
+		t.Fatalf("got %d upstream completions, want only the owner's", got)
+	}
+	env.apiKey = ownerKey
+	env.fake.QueueCompletion(fakeclaude.TextReply("Hello again"))
+	var second DialogueResponse
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-owner", continueRequest, http.StatusOK, &second)
+	if second.ConversationID != first.ConversationID || second.Response != "Hello again" {
+		t.Fatalf("got %+v, want the owner to continue %s", second, first.ConversationID)
+	}
+	env.doJSON(http.MethodDelete, "/chat/dialogue/"+first.ConversationID, "device-owner", nil, http.StatusOK, nil)
+}
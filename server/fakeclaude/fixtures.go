@@ -0,0 +1,191 @@
+package fakeclaude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type Event struct {
+	Name  string
+	Data  any
+	Delay time.Duration
+}
+
+type Fixture struct {
+	Status  int
+	Headers map[string]string
+	Body    string
+	Events  []Event
+}
+
+func TextReply(text string) Fixture {
+	return Fixture{Events: textEvents(splitChunks(text, 16), 0, "end_turn")}
+}
+
+func ChunkedReply(chunks ...string) Fixture {
+	return Fixture{Events: textEvents(chunks, 0, "end_turn")}
+}
+
+func SlowReply(text string, delay time.Duration) Fixture {
+	return Fixture{Events: textEvents(splitChunks(text, 4), delay, "end_turn")}
+}
+
+func ErrorReply(partial, errType, message string) Fixture {
+	events := textEvents(splitChunks(partial, 16), 0, "")
+	events = events[:len(events)-4]
+	events = append(events, Event{
+		Name: "error",
+		Data: map[string]any{
+			"type": "error",
+			"error": map[string]any{
+				"type":    errType,
+				"message": message,
+			},
+		},
+	})
+	return Fixture{Events: events}
+}
+
+func RateLimited(retryAfter time.Duration) Fixture {
+	resetsAt := time.Now().Add(retryAfter).Unix()
+	return Fixture{
+		Status: http.StatusTooManyRequests,
+		Headers: map[string]string{
+			"Retry-After": strconv.Itoa(int(retryAfter.Seconds())),
+		},
+		Body: fmt.Sprintf(`{"type":"error","error":{"type":"rate_limit_error","message":"{\"type\":\"exceeded_limit\",\"resetsAt\":%d}"}}`, resetsAt),
+	}
+}
+
+func ThinkingReply(thinking, text string) Fixture {
+	events := []Event{
+		{Name: "content_block_start", Data: map[string]any{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": map[string]any{"type": "thinking", "thinking": ""},
+		}},
+	}
+	for _, chunk := range splitChunks(thinking, 16) {
+		events = append(events, Event{Name: "content_block_delta", Data: map[string]any{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]any{"type": "thinking_delta", "thinking": chunk},
+		}})
+	}
+	events = append(events, Event{Name: "content_block_stop", Data: map[string]any{"type": "content_block_stop", "index": 0}})
+	return Fixture{Events: append(events, shiftIndex(textEvents(splitChunks(text, 16), 0, "end_turn"), 1)...)}
+}
+
+func ToolUseReply(name string, input map[string]any, result, text string) Fixture {
+	toolUseID := "toolu_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	inputJSON, _ := json.Marshal(input)
+	events := []Event{
+		{Name: "content_block_start", Data: map[string]any{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": map[string]any{"type": "tool_use", "id": toolUseID, "name": name, "input": map[string]any{}},
+		}},
+		{Name: "content_block_delta", Data: map[string]any{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]any{"type": "input_json_delta", "partial_json": string(inputJSON)},
+		}},
+		{Name: "content_block_stop", Data: map[string]any{"type": "content_block_stop", "index": 0}},
+		{Name: "content_block_start", Data: map[string]any{
+			"type":  "content_block_start",
+			"index": 1,
+			"content_block": map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": toolUseID,
+				"name":        name,
+				"content":     []map[string]any{{"type": "text", "text": result}},
+				"is_error":    false,
+			},
+		}},
+		{Name: "content_block_stop", Data: map[string]any{"type": "content_block_stop", "index": 1}},
+	}
+	return Fixture{Events: append(events, shiftIndex(textEvents(splitChunks(text, 16), 0, "end_turn"), 2)...)}
+}
+
+func CitationReply(text, url, title string) Fixture {
+	events := textEvents(splitChunks(text, 16), 0, "end_turn")
+	citation := Event{Name: "content_block_delta", Data: map[string]any{
+		"type":  "content_block_delta",
+		"index": 0,
+		"delta": map[string]any{
+			"type": "citation_start_delta",
+			"citation": map[string]any{
+				"uuid":        "citation-1",
+				"url":         url,
+				"title":       title,
+				"start_index": 0,
+				"end_index":   len(text),
+			},
+		},
+	}}
+	return Fixture{Events: append(events[:1], append([]Event{citation}, events[1:]...)...)}
+}
+
+func StatusReply(status int, body string) Fixture {
+	return Fixture{Status: status, Body: body}
+}
+
+func textEvents(chunks []string, delay time.Duration, stopReason string) []Event {
+	events := []Event{
+		{Name: "content_block_start", Data: map[string]any{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": map[string]any{"type": "text", "text": ""},
+		}},
+	}
+	for _, chunk := range chunks {
+		events = append(events, Event{
+			Name:  "content_block_delta",
+			Delay: delay,
+			Data: map[string]any{
+				"type":  "content_block_delta",
+				"index": 0,
+				"delta": map[string]any{"type": "text_delta", "text": chunk},
+			},
+		})
+	}
+	return append(events,
+		Event{Name: "content_block_stop", Data: map[string]any{"type": "content_block_stop", "index": 0}},
+		Event{Name: "message_delta", Data: map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+		}},
+		Event{Name: "message_limit", Data: map[string]any{
+			"type":          "message_limit",
+			"message_limit": map[string]any{"type": "within_limit", "resetsAt": nil, "remaining": nil},
+		}},
+		Event{Name: "message_stop", Data: map[string]any{"type": "message_stop"}},
+	)
+}
+
+func shiftIndex(events []Event, offset int) []Event {
+	for _, ev := range events {
+		if data, ok := ev.Data.(map[string]any); ok {
+			if index, ok := data["index"].(int); ok {
+				data["index"] = index + offset
+			}
+		}
+	}
+	return events
+}
+
+func splitChunks(text string, size int) []string {
+	runes := []rune(text)
+	chunks := make([]string, 0, len(runes)/size+1)
+	for len(runes) > size {
+		chunks = append(chunks, string(runes[:size]))
+		runes = runes[size:]
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
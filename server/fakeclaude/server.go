@@ -0,0 +1,643 @@
+package fakeclaude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	RouteCreateConversation = "create_conversation"
+	RouteGetConversation    = "get_conversation"
+	RouteCompletion         = "completion"
+	RouteStopResponse       = "stop_response"
+	RouteUploadFile         = "upload_file"
+	RouteUsage              = "usage"
+	RouteBootstrap          = "mcp_bootstrap"
+	RouteRemoteServers      = "mcp_remote_servers"
+	RouteAccountSettings    = "account_settings"
+	RouteMCPWebSocket       = "mcp_websocket"
+)
+
+const rootMessageUUID = "00000000-0000-4000-8000-000000000000"
+
+type File struct {
+	FileUUID string `json:"file_uuid"`
+	FileName string `json:"file_name"`
+	FileKind string `json:"file_kind"`
+}
+
+type Attachment struct {
+	ID               string `json:"id"`
+	FileName         string `json:"file_name"`
+	FileType         string `json:"file_type"`
+	FileSize         int64  `json:"file_size"`
+	ExtractedContent string `json:"extracted_content"`
+}
+
+type Message struct {
+	UUID              string       `json:"uuid"`
+	ParentMessageUUID string       `json:"parent_message_uuid"`
+	Sender            string       `json:"sender"`
+	Text              string       `json:"text"`
+	Index             int          `json:"index"`
+	CreatedAt         string       `json:"created_at"`
+	Attachments       []Attachment `json:"attachments"`
+	Files             []File       `json:"files"`
+}
+
+type Conversation struct {
+	UUID                   string    `json:"uuid"`
+	Name                   string    `json:"name"`
+	IsTemporary            bool      `json:"is_temporary"`
+	CreatedAt              string    `json:"created_at"`
+	CurrentLeafMessageUUID string    `json:"current_leaf_message_uuid"`
+	Messages               []Message `json:"chat_messages"`
+}
+
+type Request struct {
+	Route  string
+	Method string
+	Path   string
+	Body   string
+	Header http.Header
+}
+
+type MCPServer struct {
+	UUID  string
+	Name  string
+	URL   string
+	Tools []map[string]any
+}
+
+type Server struct {
+	OrgIDs []string
+
+	mu            sync.Mutex
+	mux           *http.ServeMux
+	httpServer    *http.Server
+	conversations map[string]*Conversation
+	uploads       map[string]File
+	completions   []Fixture
+	failures      map[string][]Fixture
+	usage         map[string]any
+	mcpServers    []MCPServer
+	requests      []Request
+	streams       map[string]context.CancelFunc
+}
+
+func New(orgIDs ...string) *Server {
+	s := &Server{
+		OrgIDs:        orgIDs,
+		mux:           http.NewServeMux(),
+		conversations: make(map[string]*Conversation),
+		uploads:       make(map[string]File),
+		failures:      make(map[string][]Fixture),
+		streams:       make(map[string]context.CancelFunc),
+		usage: map[string]any{
+			"five_hour": map[string]any{"utilization": 0.0, "resets_at": nil},
+			"seven_day": map[string]any{"utilization": 0.0, "resets_at": nil},
+		},
+		mcpServers: []MCPServer{
+			{
+				UUID: "fake-mcp-server",
+				Name: "Fake MCP",
+				URL:  "https://mcp.example.invalid/sse",
+				Tools: []map[string]any{
+					{
+						"name":        "echo",
+						"description": "Echo the given text back",
+						"inputSchema": map[string]any{
+							"type":       "object",
+							"properties": map[string]any{"text": map[string]any{"type": "string"}},
+							"required":   []string{"text"},
+						},
+					},
+				},
+			},
+		},
+	}
+	org := "/api/organizations/{org}"
+	s.handle("POST "+org+"/chat_conversations", RouteCreateConversation, s.createConversation)
+	s.handle("GET "+org+"/chat_conversations/{id}", RouteGetConversation, s.getConversation)
+	s.handle("POST "+org+"/chat_conversations/{id}/completion", RouteCompletion, s.completion)
+	s.handle("POST "+org+"/chat_conversations/{id}/stop_response", RouteStopResponse, s.stopResponse)
+	s.handle("POST "+org+"/conversations/{id}/wiggle/upload-file", RouteUploadFile, s.uploadFile)
+	s.handle("GET "+org+"/usage", RouteUsage, s.getUsage)
+	s.handle("GET "+org+"/mcp/v2/bootstrap", RouteBootstrap, s.bootstrap)
+	s.handle("GET "+org+"/mcp/remote_servers", RouteRemoteServers, s.remoteServers)
+	s.handle("PATCH /api/account/settings", RouteAccountSettings, s.accountSettings)
+	s.handle("GET /api/ws/organizations/{org}/mcp/servers/{server}/", RouteMCPWebSocket, s.mcpWebSocket)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) Start(addr string) (string, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("listen failed: %v", err)
+	}
+	s.mu.Lock()
+	s.httpServer = &http.Server{Handler: s}
+	s.mu.Unlock()
+	go s.httpServer.Serve(listener)
+	return "http://" + listener.Addr().String(), nil
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Close()
+}
+
+func (s *Server) QueueCompletion(fixtures ...Fixture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completions = append(s.completions, fixtures...)
+}
+
+func (s *Server) FailNext(route string, fixture Fixture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[route] = append(s.failures[route], fixture)
+}
+
+func (s *Server) SetUsage(fiveHour, sevenDay float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resetsAt := time.Now().Add(5 * time.Hour).UTC().Format(time.RFC3339)
+	s.usage = map[string]any{
+		"five_hour": map[string]any{"utilization": fiveHour, "resets_at": resetsAt},
+		"seven_day": map[string]any{"utilization": sevenDay, "resets_at": resetsAt},
+	}
+}
+
+func (s *Server) SetMCPServers(servers ...MCPServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mcpServers = servers
+}
+
+func (s *Server) Requests(route string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]Request, 0)
+	for _, req := range s.requests {
+		if route == "" || req.Route == route {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+func (s *Server) Conversation(id string) (Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[id]
+	if !ok {
+		return Conversation{}, false
+	}
+	copied := *conv
+	copied.Messages = append([]Message(nil), conv.Messages...)
+	return copied, true
+}
+
+func (s *Server) Upload(fileUUID string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.uploads[fileUUID]
+	return file, ok
+}
+
+func (s *Server) AppendExchange(conversationID, prompt, reply string) (Message, Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv, ok := s.conversations[conversationID]
+	if !ok {
+		return Message{}, Message{}, false
+	}
+	parent := conv.CurrentLeafMessageUUID
+	if parent == "" {
+		parent = rootMessageUUID
+	}
+	createdAt := time.Now().UTC().Format(time.RFC3339Nano)
+	human := Message{UUID: uuid.New().String(), ParentMessageUUID: parent, Sender: "human", Text: prompt, Index: len(conv.Messages), CreatedAt: createdAt, Attachments: []Attachment{}, Files: []File{}}
+	assistant := Message{UUID: uuid.New().String(), ParentMessageUUID: human.UUID, Sender: "assistant", Text: reply, Index: human.Index + 1, CreatedAt: createdAt, Attachments: []Attachment{}, Files: []File{}}
+	conv.Messages = append(conv.Messages, human, assistant)
+	conv.CurrentLeafMessageUUID = assistant.UUID
+	return human, assistant, true
+}
+
+func (s *Server) handle(pattern, route string, handler func(http.ResponseWriter, *http.Request, []byte)) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil && route != RouteMCPWebSocket {
+			body, _ = io.ReadAll(r.Body)
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Route:  route,
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Body:   string(body),
+			Header: r.Header.Clone(),
+		})
+		var failure *Fixture
+		if queued := s.failures[route]; len(queued) > 0 {
+			failure = &queued[0]
+			s.failures[route] = queued[1:]
+		}
+		s.mu.Unlock()
+		if failure != nil {
+			writeFixture(w, r, *failure, nil)
+			return
+		}
+		if org := r.PathValue("org"); org != "" && !s.knownOrg(org) {
+			writeError(w, http.StatusForbidden, "permission_error", "unknown organization")
+			return
+		}
+		handler(w, r, body)
+	})
+}
+
+func (s *Server) knownOrg(org string) bool {
+	if len(s.OrgIDs) == 0 {
+		return true
+	}
+	for _, id := range s.OrgIDs {
+		if id == org {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) createConversation(w http.ResponseWriter, _ *http.Request, body []byte) {
+	var req struct {
+		UUID        string `json:"uuid"`
+		Name        string `json:"name"`
+		IsTemporary bool   `json:"is_temporary"`
+	}
+	json.Unmarshal(body, &req)
+	if req.UUID == "" {
+		req.UUID = uuid.New().String()
+	}
+	conv := &Conversation{
+		UUID:        req.UUID,
+		Name:        req.Name,
+		IsTemporary: req.IsTemporary,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		Messages:    []Message{},
+	}
+	s.mu.Lock()
+	s.conversations[conv.UUID] = conv
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, conv)
+}
+
+func (s *Server) getConversation(w http.ResponseWriter, r *http.Request, _ []byte) {
+	conv, ok := s.Conversation(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "conversation not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, conv)
+}
+
+func (s *Server) completion(w http.ResponseWriter, r *http.Request, body []byte) {
+	conversationID := r.PathValue("id")
+	var req struct {
+		Prompt            string   `json:"prompt"`
+		ParentMessageUUID string   `json:"parent_message_uuid"`
+		Model             string   `json:"model"`
+		Files             []string `json:"files"`
+		Attachments       []struct {
+			FileUUID         string `json:"file_uuid"`
+			FileName         string `json:"file_name"`
+			FileType         string `json:"file_type"`
+			FileSize         int64  `json:"file_size"`
+			ExtractedContent string `json:"extracted_content"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid json body")
+		return
+	}
+	human := Message{UUID: uuid.New().String(), Sender: "human", Text: req.Prompt, Attachments: []Attachment{}, Files: []File{}}
+	for _, att := range req.Attachments {
+		id := att.FileUUID
+		if id == "" {
+			id = uuid.New().String()
+		}
+		human.Attachments = append(human.Attachments, Attachment{ID: id, FileName: att.FileName, FileType: att.FileType, FileSize: att.FileSize, ExtractedContent: att.ExtractedContent})
+	}
+	for _, fileUUID := range req.Files {
+		file, ok := s.Upload(fileUUID)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "file not found: "+fileUUID)
+			return
+		}
+		human.Files = append(human.Files, file)
+	}
+	s.mu.Lock()
+	conv, ok := s.conversations[conversationID]
+	var fixture Fixture
+	if len(s.completions) > 0 {
+		fixture = s.completions[0]
+		s.completions = s.completions[1:]
+	} else {
+		fixture = TextReply("Echo: " + req.Prompt)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "conversation not found")
+		return
+	}
+	if fixture.Status != 0 && fixture.Status != http.StatusOK {
+		writeFixture(w, r, fixture, nil)
+		return
+	}
+	parent := req.ParentMessageUUID
+	if parent == "" {
+		parent = rootMessageUUID
+	}
+	human.ParentMessageUUID = parent
+	assistant := Message{UUID: uuid.New().String(), ParentMessageUUID: human.UUID, Sender: "assistant", Attachments: []Attachment{}, Files: []File{}}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	s.mu.Lock()
+	s.streams[conversationID] = cancel
+	s.mu.Unlock()
+	start := Event{Name: "message_start", Data: map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":                  "chatcompl_" + assistant.UUID,
+			"type":                "message",
+			"role":                "assistant",
+			"model":               req.Model,
+			"parent_uuid":         human.UUID,
+			"uuid":                assistant.UUID,
+			"content":             []any{},
+			"stop_reason":         nil,
+			"trace_id":            uuid.New().String(),
+			"request_id":          uuid.New().String(),
+			"stop_sequence":       nil,
+			"conversation_uuid":   conversationID,
+			"parent_message_uuid": parent,
+		},
+	}}
+	fixture.Events = append([]Event{start}, fixture.Events...)
+	var reply strings.Builder
+	writeFixture(w, r.WithContext(ctx), fixture, &reply)
+	s.mu.Lock()
+	delete(s.streams, conversationID)
+	human.Index = len(conv.Messages)
+	human.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	assistant.Index = human.Index + 1
+	assistant.CreatedAt = human.CreatedAt
+	assistant.Text = reply.String()
+	conv.Messages = append(conv.Messages, human, assistant)
+	conv.CurrentLeafMessageUUID = assistant.UUID
+	s.mu.Unlock()
+}
+
+func (s *Server) stopResponse(w http.ResponseWriter, r *http.Request, _ []byte) {
+	s.mu.Lock()
+	cancel, ok := s.streams[r.PathValue("id")]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request, body []byte) {
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid multipart body")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "missing file field")
+		return
+	}
+	defer file.Close()
+	content, _ := io.ReadAll(file)
+	fileKind := "document"
+	contentType := header.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "image/") {
+		fileKind = "image"
+	}
+	fileUUID := uuid.New().String()
+	s.mu.Lock()
+	s.uploads[fileUUID] = File{FileUUID: fileUUID, FileName: header.Filename, FileKind: fileKind}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":           true,
+		"file_uuid":         fileUUID,
+		"file_name":         header.Filename,
+		"file_kind":         fileKind,
+		"file_size":         len(content),
+		"sanitized_name":    header.Filename,
+		"size_bytes":        len(content),
+		"created_at":        time.Now().UTC().Format(time.RFC3339Nano),
+		"thumbnail_url":     "",
+		"preview_url":       "",
+		"extracted_content": string(content),
+		"path":              "/mnt/user-data/uploads/" + header.Filename,
+	})
+}
+
+func (s *Server) getUsage(w http.ResponseWriter, _ *http.Request, _ []byte) {
+	s.mu.Lock()
+	usage := s.usage
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, usage)
+}
+
+func (s *Server) remoteServers(w http.ResponseWriter, _ *http.Request, _ []byte) {
+	s.mu.Lock()
+	servers := make([]map[string]any, 0, len(s.mcpServers))
+	for _, server := range s.mcpServers {
+		servers = append(servers, map[string]any{
+			"uuid":                         server.UUID,
+			"name":                         server.Name,
+			"url":                          server.URL,
+			"created_at":                   "2025-01-01T00:00:00Z",
+			"updated_at":                   "2025-01-01T00:00:00Z",
+			"custom_oauth_client_id":       "",
+			"has_custom_oauth_credentials": false,
+			"is_authenticated":             true,
+		})
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, servers)
+}
+
+func (s *Server) bootstrap(w http.ResponseWriter, r *http.Request, _ []byte) {
+	s.mu.Lock()
+	servers := append([]MCPServer(nil), s.mcpServers...)
+	s.mu.Unlock()
+	events := make([]Event, 0, len(servers)*2+1)
+	for _, server := range servers {
+		events = append(events,
+			Event{Name: "server_base", Data: map[string]any{"uuid": server.UUID, "name": server.Name, "url": server.URL}},
+			Event{Name: "tools", Data: map[string]any{"server_uuid": server.UUID, "tools": server.Tools}},
+		)
+	}
+	events = append(events, Event{Name: "completed", Data: map[string]any{}})
+	writeFixture(w, r, Fixture{Events: events}, nil)
+}
+
+func (s *Server) accountSettings(w http.ResponseWriter, _ *http.Request, body []byte) {
+	var settings map[string]any
+	if err := json.Unmarshal(body, &settings); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid json body")
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{"mcp"},
+	CheckOrigin:  func(r *http.Request) bool { return true },
+}
+
+func (s *Server) mcpWebSocket(w http.ResponseWriter, r *http.Request, _ []byte) {
+	serverID := r.PathValue("server")
+	var server *MCPServer
+	s.mu.Lock()
+	for i := range s.mcpServers {
+		if s.mcpServers[i].UUID == serverID {
+			copied := s.mcpServers[i]
+			server = &copied
+		}
+	}
+	s.mu.Unlock()
+	if server == nil {
+		writeError(w, http.StatusNotFound, "not_found_error", "mcp server not found")
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		var msg struct {
+			ID     any             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		reply := map[string]any{"jsonrpc": "2.0", "id": msg.ID}
+		switch msg.Method {
+		case "initialize":
+			if err := conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": "connected"}); err != nil {
+				return
+			}
+			reply["result"] = map[string]any{
+				"protocolVersion": "2024-11-05",
+				"capabilities":    map[string]any{"tools": map[string]any{}},
+				"serverInfo":      map[string]any{"name": server.Name, "version": "0.0.1"},
+			}
+		case "notifications/initialized":
+			continue
+		case "tools/list":
+			reply["result"] = map[string]any{"tools": server.Tools}
+		case "tools/call":
+			var params struct {
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			arguments, _ := json.Marshal(params.Arguments)
+			reply["result"] = map[string]any{
+				"content": []map[string]any{{"type": "text", "text": fmt.Sprintf("%s %s", params.Name, arguments)}},
+				"isError": false,
+			}
+		default:
+			reply["error"] = map[string]any{"code": -32601, "message": "method not found: " + msg.Method}
+		}
+		if err := conn.WriteJSON(reply); err != nil {
+			return
+		}
+	}
+}
+
+func writeFixture(w http.ResponseWriter, r *http.Request, fixture Fixture, reply *strings.Builder) {
+	for key, value := range fixture.Headers {
+		w.Header().Set(key, value)
+	}
+	if len(fixture.Events) == 0 {
+		status := fixture.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, fixture.Body)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for _, ev := range fixture.Events {
+		if ev.Delay > 0 {
+			select {
+			case <-time.After(ev.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+		data, _ := json.Marshal(ev.Data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if reply != nil && ev.Name == "content_block_delta" {
+			if payload, ok := ev.Data.(map[string]any); ok {
+				if delta, ok := payload["delta"].(map[string]any); ok {
+					if text, ok := delta["text"].(string); ok {
+						reply.WriteString(text)
+					}
+				}
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]any{
+		"type":  "error",
+		"error": map[string]any{"type": errType, "message": message},
+	})
+}
@@ -0,0 +1,237 @@
+package fakeclaude
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testOrg = "org-test"
+
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	fake := New(testOrg)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL + "/api/organizations/" + testOrg
+}
+
+func post(t *testing.T, url string, body any) *http.Response {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func createConversation(t *testing.T, base string) string {
+	t.Helper()
+	resp := post(t, base+"/chat_conversations", map[string]any{"name": ""})
+	var conv Conversation
+	if err := json.NewDecoder(resp.Body).Decode(&conv); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create conversation: status %d, err %v", resp.StatusCode, err)
+	}
+	return conv.UUID
+}
+
+func readText(t *testing.T, body io.Reader) (string, []string) {
+	t.Helper()
+	var text strings.Builder
+	var names []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Delta struct {
+				Text string `json:"text"`
+			} `json:"delta"`
+		}
+		json.Unmarshal([]byte(data), &event)
+		text.WriteString(event.Delta.Text)
+	}
+	return text.String(), names
+}
+
+func TestCompletionStreamsFixtureAndRecordsHistory(t *testing.T) {
+	fake, base := newTestServer(t)
+	conversationID := createConversation(t, base)
+	fake.QueueCompletion(ChunkedReply("Hel", "lo"))
+	resp := post(t, base+"/chat_conversations/"+conversationID+"/completion", map[string]any{"prompt": "Hi"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d (%s), want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	text, names := readText(t, resp.Body)
+	if text != "Hello" || names[0] != "message_start" || names[len(names)-1] != "message_stop" {
+		t.Fatalf("streamed %q with events %v", text, names)
+	}
+	conv, ok := fake.Conversation(conversationID)
+	if !ok || len(conv.Messages) != 2 || conv.Messages[0].Text != "Hi" || conv.Messages[1].Text != "Hello" {
+		t.Fatalf("got conversation %+v, want the Hi/Hello exchange", conv)
+	}
+	if conv.CurrentLeafMessageUUID != conv.Messages[1].UUID || conv.Messages[1].ParentMessageUUID != conv.Messages[0].UUID {
+		t.Fatalf("messages are not chained: %+v", conv)
+	}
+	resp = post(t, base+"/chat_conversations/"+conversationID+"/completion", map[string]any{"prompt": "Again", "parent_message_uuid": conv.Messages[1].UUID})
+	if text, _ := readText(t, resp.Body); text != "Echo: Again" {
+		t.Fatalf("got %q, want the default echo reply", text)
+	}
+	if got := len(fake.Requests(RouteCompletion)); got != 2 {
+		t.Fatalf("recorded %d completion requests, want 2", got)
+	}
+}
+
+func TestUnknownOrganizationIsRejected(t *testing.T) {
+	fake := New(testOrg)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/organizations/other-org/usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestFailNextAppliesOnce(t *testing.T) {
+	fake, base := newTestServer(t)
+	conversationID := createConversation(t, base)
+	fake.FailNext(RouteCompletion, RateLimited(30*time.Second))
+	url := base + "/chat_conversations/" + conversationID + "/completion"
+	resp := post(t, url, map[string]any{"prompt": "Hi"})
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "30" {
+		t.Fatalf("got status %d with Retry-After %q, want 429 and 30", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp = post(t, url, map[string]any{"prompt": "Hi"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d after the queued failure, want 200", resp.StatusCode)
+	}
+}
+
+func TestStopResponseCancelsStream(t *testing.T) {
+	fake, base := newTestServer(t)
+	conversationID := createConversation(t, base)
+	fake.QueueCompletion(SlowReply("one two three four five six", 100*time.Millisecond))
+	resp := post(t, base+"/chat_conversations/"+conversationID+"/completion", map[string]any{"prompt": "Count"})
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("stream did not start: %v", err)
+	}
+	post(t, base+"/chat_conversations/"+conversationID+"/stop_response", nil)
+	text, names := readText(t, reader)
+	if strings.Contains(strings.Join(names, ","), "message_stop") || strings.HasSuffix(text, "six") {
+		t.Fatalf("stream was not cut short: %q %v", text, names)
+	}
+	conv, _ := fake.Conversation(conversationID)
+	if len(conv.Messages) != 2 || !strings.HasPrefix("one two three four five six", conv.Messages[1].Text) {
+		t.Fatalf("got %+v, want the partial reply stored", conv.Messages)
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	fake, base := newTestServer(t)
+	conversationID := createConversation(t, base)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="dot.png"`)
+	header.Set("Content-Type", "image/png")
+	part, _ := writer.CreatePart(header)
+	part.Write([]byte("png-bytes"))
+	writer.Close()
+	resp, err := http.Post(base+"/conversations/"+conversationID+"/wiggle/upload-file", writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var uploaded struct {
+		FileUUID  string `json:"file_uuid"`
+		FileKind  string `json:"file_kind"`
+		SizeBytes int    `json:"size_bytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil || uploaded.FileUUID == "" {
+		t.Fatalf("invalid upload response %+v: %v", uploaded, err)
+	}
+	if uploaded.FileKind != "image" || uploaded.SizeBytes != len("png-bytes") {
+		t.Fatalf("got %+v, want a 9 byte image", uploaded)
+	}
+	if got := len(fake.Requests(RouteUploadFile)); got != 1 {
+		t.Fatalf("recorded %d uploads, want 1", got)
+	}
+	url := base + "/chat_conversations/" + conversationID + "/completion"
+	if resp := post(t, url, map[string]any{"prompt": "Look", "files": []string{"missing"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d for an unknown file, want 400", resp.StatusCode)
+	}
+	resp = post(t, url, map[string]any{
+		"prompt":      "Look",
+		"files":       []string{uploaded.FileUUID},
+		"attachments": []map[string]any{{"file_name": "notes.txt", "file_type": "text/plain", "file_size": 5, "extracted_content": "notes"}},
+	})
+	readText(t, resp.Body)
+	conv, _ := fake.Conversation(conversationID)
+	human := conv.Messages[len(conv.Messages)-2]
+	if len(human.Files) != 1 || human.Files[0].FileUUID != uploaded.FileUUID || human.Files[0].FileName != "dot.png" {
+		t.Fatalf("got files %+v, want the uploaded image", human.Files)
+	}
+	if len(human.Attachments) != 1 || human.Attachments[0].ExtractedContent != "notes" || human.Attachments[0].ID == "" {
+		t.Fatalf("got attachments %+v, want the notes attachment", human.Attachments)
+	}
+}
+
+func TestMCPWebSocketListsAndCallsTools(t *testing.T) {
+	fake := New(testOrg)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws/organizations/" + testOrg + "/mcp/servers/fake-mcp-server/"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	call := func(id int, method string, params any) map[string]any {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			var reply map[string]any
+			if err := conn.ReadJSON(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply["id"] != nil {
+				return reply
+			}
+		}
+	}
+	call(1, "initialize", map[string]any{})
+	tools := call(2, "tools/list", nil)["result"].(map[string]any)["tools"].([]any)
+	if len(tools) != 1 || tools[0].(map[string]any)["name"] != "echo" {
+		t.Fatalf("got tools %v, want echo", tools)
+	}
+	result := call(3, "tools/call", map[string]any{"name": "echo", "arguments": map[string]any{"text": "hi"}})["result"].(map[string]any)
+	content := result["content"].([]any)[0].(map[string]any)["text"]
+	if content != `echo {"text":"hi"}` {
+		t.Fatalf("got tool result %v", content)
+	}
+}
@@ -27,218 +27,29 @@ type ProcessingRequest struct {
 	UserMessage string
 }
 
-func (h *Handler) ChatCompletion(c *gin.Context) {
-	var req OpenAIChatRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
-	if len(req.Messages) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Messages cannot be empty"})
-		return
-	}
-	devicePassword := c.GetHeader("X-Device-ID")
-	if devicePassword == "" {
-		devicePassword = uuid.New().String()
-	}
-	platform := c.GetHeader("X-Platform")
-	if platform == "" {
-		platform = "windows"
-	}
-	device, err := h.db.GetOrCreateDevice(devicePassword, platform)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
-		return
-	}
-	convUID := uuid.New().String()
-	conv, err := h.db.CreateConversation(device.ID, convUID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
-		return
-	}
-	userMessage := ""
-	for _, m := range req.Messages {
-		if m.Role == "user" {
-			userMessage = m.Content
-			break
-		}
-	}
-	dialogueUID := uuid.New().String()
-	dialogue := &CldDialogue{
-		UID:            dialogueUID,
-		ConversationID: conv.ID,
-		Order:          1,
-		UserMessage:    userMessage,
-		CreateTime:     time.Now(),
-		Status:         "processing",
-		PromptID:       h.db.GetCurrentPromptID(),
-	}
-	h.db.CreateDialogue(dialogue)
-	h.db.IncrementProcessing()
-	requestID := uuid.New().String()
-	processingMutex.Lock()
-	processingRequests[requestID] = &ProcessingRequest{
-		ID:          requestID,
-		SubmitTime:  time.Now(),
-		InputTokens: 0,
-		UserMessage: userMessage,
-	}
-	processingMutex.Unlock()
-	select {
-	case h.semaphore <- struct{}{}:
-		defer func() { <-h.semaphore }()
-		h.db.DecrementProcessing()
-		processingMutex.Lock()
-		delete(processingRequests, requestID)
-		processingMutex.Unlock()
-		requestTime := time.Now()
-		dialogue.RequestTime = &requestTime
-		cookie := h.config.GetCookie()
-		response, err := sendChatCompletion(h.config.GetOrganizationID(), cookie, req.Messages, req.Model, req.Stream)
-		finishTime := time.Now()
-		dialogue.FinishTime = &finishTime
-		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
-		dialogue.Duration = &duration
-		if err != nil {
-			dialogue.Status = "send_failed"
-			h.db.UpdateDialogue(dialogue)
-			h.db.IncrementFailed()
-			LogExchange(userMessage, err.Error(), true)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		assistantMsg := response.Choices[0].Message.Content
-		dialogue.AssistantMessage = &assistantMsg
-		dialogue.Status = "done"
-		h.db.UpdateDialogue(dialogue)
-		h.db.IncrementCompleted()
-		LogExchange(userMessage, assistantMsg, false)
-		c.JSON(http.StatusOK, response)
-	default:
-		dialogue.Status = "send_failed"
-		h.db.UpdateDialogue(dialogue)
-		h.db.IncrementFailed()
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
-	}
-}
-
-func (h *Handler) OllamaChat(c *gin.Context) {
-	var req OllamaChatRequest
+func (h *Handler) DialogueChat(c *gin.Context) {
+	var req DialogueRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	if len(req.Messages) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Messages cannot be empty"})
+	if req.Request == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request cannot be empty"})
 		return
 	}
-	devicePassword := c.GetHeader("X-Device-ID")
-	if devicePassword == "" {
-		devicePassword = uuid.New().String()
-	}
-	platform := c.GetHeader("X-Platform")
-	if platform == "" {
-		platform = "windows"
+	timezone, locale := requestLocale(c)
+	model, err := h.config.ResolveModel(req.Model)
+	if err == nil {
+		_, err = resolveStyle(h.config, req.Style)
 	}
-	device, err := h.db.GetOrCreateDevice(devicePassword, platform)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
-		return
+	if err == nil {
+		err = validateLocale(timezone, locale)
 	}
-	convUID := uuid.New().String()
-	conv, err := h.db.CreateConversation(device.ID, convUID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
-		return
-	}
-	userMessage := ""
-	for _, m := range req.Messages {
-		if m.Role == "user" {
-			userMessage = m.Content
-			break
-		}
-	}
-	dialogueUID := uuid.New().String()
-	dialogue := &CldDialogue{
-		UID:            dialogueUID,
-		ConversationID: conv.ID,
-		Order:          1,
-		UserMessage:    userMessage,
-		CreateTime:     time.Now(),
-		Status:         "processing",
-		PromptID:       h.db.GetCurrentPromptID(),
-	}
-	h.db.CreateDialogue(dialogue)
-	h.db.IncrementProcessing()
-	requestID := uuid.New().String()
-	processingMutex.Lock()
-	processingRequests[requestID] = &ProcessingRequest{
-		ID:          requestID,
-		SubmitTime:  time.Now(),
-		InputTokens: 0,
-		UserMessage: userMessage,
-	}
-	processingMutex.Unlock()
-	select {
-	case h.semaphore <- struct{}{}:
-		defer func() { <-h.semaphore }()
-		h.db.DecrementProcessing()
-		processingMutex.Lock()
-		delete(processingRequests, requestID)
-		processingMutex.Unlock()
-		requestTime := time.Now()
-		dialogue.RequestTime = &requestTime
-		cookie := h.config.GetCookie()
-		openaiReq := OpenAIChatRequest{
-			Model:    req.Model,
-			Messages: req.Messages,
-			Stream:   req.Stream,
-		}
-		response, err := sendChatCompletion(h.config.GetOrganizationID(), cookie, openaiReq.Messages, openaiReq.Model, openaiReq.Stream)
-		finishTime := time.Now()
-		dialogue.FinishTime = &finishTime
-		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
-		dialogue.Duration = &duration
-		if err != nil {
-			dialogue.Status = "send_failed"
-			h.db.UpdateDialogue(dialogue)
-			h.db.IncrementFailed()
-			LogExchange(userMessage, err.Error(), true)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		assistantMsg := response.Choices[0].Message.Content
-		dialogue.AssistantMessage = &assistantMsg
-		dialogue.Status = "done"
-		h.db.UpdateDialogue(dialogue)
-		h.db.IncrementCompleted()
-		LogExchange(userMessage, assistantMsg, false)
-		ollamaResponse := OllamaChatResponse{
-			Model:     req.Model,
-			CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
-			Message:   response.Choices[0].Message,
-			Done:      true,
-		}
-		c.JSON(http.StatusOK, ollamaResponse)
-	default:
-		dialogue.Status = "send_failed"
-		h.db.UpdateDialogue(dialogue)
-		h.db.IncrementFailed()
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
-	}
-}
-
-func (h *Handler) DialogueChat(c *gin.Context) {
-	var req DialogueRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
-	if req.Request == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Request cannot be empty"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	cookie := h.config.GetCookie()
+	ctx := c.Request.Context()
 	var claudeConversationID string
 	var parentMessageUUID string
 	if req.ConversationID != "" {
@@ -247,8 +58,8 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 		session.GeneratingMutex.RLock()
 		parentMessageUUID = session.LastMessageUUID
 		session.GeneratingMutex.RUnlock()
-		if parentMessageUUID == "00000000-0000-4000-8000-000000000000" {
-			newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), claudeConversationID, cookie)
+		if parentMessageUUID == rootMessageUUID {
+			newParentUUID, err := h.client.GetLastMessageUUID(ctx, claudeConversationID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get conversation history"})
 				return
@@ -258,12 +69,12 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 		}
 	} else {
 		var err error
-		claudeConversationID, err = createConversation(h.config.GetOrganizationID(), cookie, true)
+		claudeConversationID, err = h.client.CreateConversation(ctx, true)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
 			return
 		}
-		parentMessageUUID = "00000000-0000-4000-8000-000000000000"
+		parentMessageUUID = rootMessageUUID
 		h.dialogueManager.GetOrCreateSession(claudeConversationID)
 	}
 	devicePassword := c.GetHeader("X-Device-ID")
@@ -275,6 +86,7 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 		platform = "windows"
 	}
 	device, _ := h.db.GetOrCreateDevice(devicePassword, platform)
+	timezone, locale = resolveLocale(h.config, device, timezone, locale)
 	conv, _ := h.db.CreateConversation(device.ID, claudeConversationID)
 	dialogueOrder, _ := h.db.GetNextDialogueOrder(conv.ID)
 	dialogueUID := uuid.New().String()
@@ -283,6 +95,7 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 		ConversationID: conv.ID,
 		Order:          dialogueOrder,
 		UserMessage:    req.Request,
+		InputTokens:    estimateInputTokens(LoadSystemPrompt(), req.Request, nil),
 		CreateTime:     time.Now(),
 		Status:         "waiting",
 		PromptID:       h.db.GetCurrentPromptID(),
@@ -309,20 +122,20 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 		dialogueStreamMutex.Lock()
 		dialogueStreams[claudeConversationID] = ""
 		dialogueStreamMutex.Unlock()
-		response, err := sendDialogueMessageWithOptions(
-			h.config.GetOrganizationID(),
-			claudeConversationID,
-			cookie,
-			req.Request,
-			parentMessageUUID,
-			req.Model,
-			req.Style,
-			func(chunk string) {
-				dialogueStreamMutex.Lock()
-				dialogueStreams[claudeConversationID] = chunk
-				dialogueStreamMutex.Unlock()
-			},
-		)
+		response, err := completeText(ctx, h.client, CompletionRequest{
+			ConversationID:    claudeConversationID,
+			Prompt:            req.Request,
+			ParentMessageUUID: parentMessageUUID,
+			Model:             model.ID,
+			Style:             req.Style,
+			Timezone:          timezone,
+			Locale:            locale,
+			SystemPrompt:      LoadSystemPrompt(),
+		}, func(chunk string) {
+			dialogueStreamMutex.Lock()
+			dialogueStreams[claudeConversationID] = chunk
+			dialogueStreamMutex.Unlock()
+		})
 		dialogueStreamMutex.Lock()
 		delete(dialogueStreams, claudeConversationID)
 		dialogueStreamMutex.Unlock()
@@ -330,6 +143,7 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 		dialogue.FinishTime = &finishTime
 		duration := int(finishTime.Sub(dialogue.CreateTime).Milliseconds())
 		dialogue.Duration = &duration
+		dialogue.OutputTokens = estimateTokens(response)
 		if err != nil {
 			dialogue.Status = "send_failed"
 			h.db.UpdateDialogue(dialogue)
@@ -337,7 +151,7 @@ func (h *Handler) DialogueChat(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message: " + err.Error()})
 			return
 		}
-		newParentUUID, err := getConversationHistory(h.config.GetOrganizationID(), claudeConversationID, cookie)
+		newParentUUID, err := h.client.GetLastMessageUUID(ctx, claudeConversationID)
 		if err == nil {
 			h.dialogueManager.UpdateSession(claudeConversationID, newParentUUID)
 		}
@@ -447,8 +261,8 @@ func (h *Handler) GetStats(c *gin.Context) {
 		TPM:             tpm,
 		RPM:             rpm,
 		RPD:             rpd,
-		ServiceShutdown: stats.ServiceShutdown,
-		ShutdownReason:  stats.ShutdownReason,
+		TokensEstimated: true,
+		ServiceState:    stats.Service,
 	})
 }
 
@@ -548,7 +362,7 @@ func (h *Handler) StreamProcessingRequest(c *gin.Context) {
 			if err != nil {
 				continue
 			}
-			if dialogue.Status == "done" || dialogue.Status == "send_failed" || dialogue.Status == "reply_failed" {
+			if dialogue.Status == "done" || dialogue.Status == "send_failed" || dialogue.Status == "reply_failed" || dialogue.Status == "cancelled" {
 				response := ""
 				if dialogue.AssistantMessage != nil {
 					response = *dialogue.AssistantMessage
@@ -686,6 +500,12 @@ func (h *Handler) DeleteDialogue(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing dialogue ID"})
 		return
 	}
+	if access := requestAPIAccess(c); access.boundDeviceID() != nil {
+		if conv, err := h.db.GetConversationByUID(id); err != nil || !access.ownsConversation(conv) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+	}
 	h.dialogueManager.DeleteSession(id)
 	broadcastDialogues()
 	c.JSON(http.StatusOK, gin.H{"message": "Dialogue deleted successfully"})
@@ -723,74 +543,54 @@ func (h *Handler) CheckDeviceStatus(c *gin.Context) {
 }
 
 func getUsage() map[string]any {
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/usage", globalConfig.GetOrganizationID())
+	return accountPool.Usage()
+}
+
+func emptyUsage() map[string]any {
+	return map[string]any{
+		"five_hour_utilization":      0,
+		"five_hour_resets_at":        nil,
+		"seven_day_utilization":      0,
+		"seven_day_resets_at":        nil,
+		"seven_day_opus_utilization": 0,
+		"seven_day_opus_resets_at":   nil,
+	}
+}
+
+func fetchUsage(orgID, cookie string) (map[string]any, error) {
+	url := globalConfig.UpstreamURL("/api/organizations/%s/usage", orgID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return map[string]any{
-			"five_hour_utilization":      0,
-			"five_hour_resets_at":        nil,
-			"seven_day_utilization":      0,
-			"seven_day_resets_at":        nil,
-			"seven_day_opus_utilization": 0,
-			"seven_day_opus_resets_at":   nil,
-		}
+		return nil, fmt.Errorf("create request failed: %v", err)
 	}
-	req.Header.Set("Cookie", globalConfig.GetCookie())
+	req.Header.Set("Cookie", cookie)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	req.Header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("anthropic-client-platform", "web_claude_ai")
 	req.Header.Set("anthropic-client-version", "1.0.0")
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Pragma", "no-cache")
-	req.Header.Set("Referer", "https://claude.ai/")
+	req.Header.Set("Referer", globalConfig.UpstreamURL("/"))
 	client := globalConfig.CreateHTTPClient(10 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
-		return map[string]any{
-			"five_hour_utilization":      0,
-			"five_hour_resets_at":        nil,
-			"seven_day_utilization":      0,
-			"seven_day_resets_at":        nil,
-			"seven_day_opus_utilization": 0,
-			"seven_day_opus_resets_at":   nil,
-		}
+		return nil, err
 	}
 	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return map[string]any{
-			"five_hour_utilization":      0,
-			"five_hour_resets_at":        nil,
-			"seven_day_utilization":      0,
-			"seven_day_resets_at":        nil,
-			"seven_day_opus_utilization": 0,
-			"seven_day_opus_resets_at":   nil,
-		}
+		return nil, newUpstreamError(resp, body)
 	}
-	body, _ := io.ReadAll(resp.Body)
 	log.Printf("[Usage API] Raw response: %s", string(body))
 	var rawData map[string]any
 	if err := json.Unmarshal(body, &rawData); err != nil {
 		log.Printf("[Usage API] Failed to parse as map: %v", err)
-		return map[string]any{
-			"five_hour_utilization":      0,
-			"five_hour_resets_at":        nil,
-			"seven_day_utilization":      0,
-			"seven_day_resets_at":        nil,
-			"seven_day_opus_utilization": 0,
-			"seven_day_opus_resets_at":   nil,
-		}
+		return emptyUsage(), nil
 	}
 	log.Printf("[Usage API] Parsed data keys: %v", getMapKeys(rawData))
-	result := map[string]any{
-		"five_hour_utilization":      0,
-		"five_hour_resets_at":        nil,
-		"seven_day_utilization":      0,
-		"seven_day_resets_at":        nil,
-		"seven_day_opus_utilization": 0,
-		"seven_day_opus_resets_at":   nil,
-	}
+	result := emptyUsage()
 	if daily, ok := rawData["daily"].(map[string]any); ok {
 		if val, ok := daily["usage"].(float64); ok {
 			result["five_hour_utilization"] = int(val * 100)
@@ -850,6 +650,10 @@ func getUsage() map[string]any {
 		}
 	}
 	log.Printf("[Usage API] Final result: %v", result)
+	return result, nil
+}
+
+func applyUsageLimits(result map[string]any) {
 	fiveHourUtil := 0
 	sevenDayUtil := 0
 	if val, ok := result["five_hour_utilization"].(int); ok {
@@ -890,7 +694,6 @@ func getUsage() map[string]any {
 	if isBlocked {
 		log.Printf("[Usage API] User is BLOCKED - Reason: %s, Reset at: %s", blockReason, blockResetTime)
 	}
-	return result
 }
 
 func getMapKeys(m map[string]any) []string {
@@ -911,8 +714,13 @@ func getStats() map[string]any {
 		"tpm":              tpm,
 		"rpm":              rpm,
 		"rpd":              rpd,
-		"service_shutdown": stats.ServiceShutdown,
-		"shutdown_reason":  stats.ShutdownReason,
+		"tokens_estimated": true,
+		"service_shutdown": stats.Service.Shutdown,
+		"shutdown_reason":  stats.Service.Reason,
+		"shutdown_cause":   stats.Service.Cause,
+		"shutdown_since":   stats.Service.Since,
+		"recover_at":       stats.Service.RecoverAt,
+		"service_override": stats.Service.Override,
 	}
 }
 
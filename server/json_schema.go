@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxSchemaDepth = 64
+
+var unsupportedSchemaKeywords = []string{
+	"if", "then", "else", "dependentRequired", "dependentSchemas", "dependencies",
+	"unevaluatedProperties", "unevaluatedItems", "$dynamicRef", "$recursiveRef",
+}
+
+func schemaTypes(value any) []string {
+	switch t := value.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesSchemaType(value any, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func schemaNumber(schema map[string]any, keyword string) (float64, bool) {
+	n, ok := schema[keyword].(float64)
+	return n, ok
+}
+
+func schemaCount(schema map[string]any, keyword string) (int, bool) {
+	n, ok := schema[keyword].(float64)
+	return int(n), ok
+}
+
+func formatSchemaNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func resolveSchemaRef(root map[string]any, ref string) (map[string]any, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("$ref %q is not supported, only local references such as #/$defs/name are allowed", ref)
+	}
+	var node any = root
+	if pointer != "" {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("$ref %q is not a valid JSON pointer", ref)
+		}
+		for _, token := range strings.Split(pointer[1:], "/") {
+			if unescaped, err := url.PathUnescape(token); err == nil {
+				token = unescaped
+			}
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			switch current := node.(type) {
+			case map[string]any:
+				next, ok := current[token]
+				if !ok {
+					return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+				}
+				node = next
+			case []any:
+				index, err := strconv.Atoi(token)
+				if err != nil || index < 0 || index >= len(current) {
+					return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+				}
+				node = current[index]
+			default:
+				return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+			}
+		}
+	}
+	schema, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("$ref %q does not point to a schema object", ref)
+	}
+	return schema, nil
+}
+
+func checkJSONSchema(schema json.RawMessage, path string) error {
+	var root map[string]any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return fmt.Errorf("%s must be a JSON schema object", path)
+	}
+	return checkSchemaNode(root, root, path, 0)
+}
+
+func checkSchemaNode(root, schema map[string]any, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema nesting is too deep", path)
+	}
+	for _, keyword := range unsupportedSchemaKeywords {
+		if _, ok := schema[keyword]; ok {
+			return fmt.Errorf("%s: JSON schema keyword %q is not supported", path, keyword)
+		}
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if _, err := resolveSchemaRef(root, ref); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s.pattern: invalid regular expression: %v", path, err)
+		}
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "$defs", "definitions"} {
+		children, _ := schema[keyword].(map[string]any)
+		names := make([]string, 0, len(children))
+		for name := range children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if keyword == "patternProperties" {
+				if _, err := regexp.Compile(name); err != nil {
+					return fmt.Errorf("%s.patternProperties: invalid regular expression %q: %v", path, name, err)
+				}
+			}
+			if child, ok := children[name].(map[string]any); ok {
+				if err := checkSchemaNode(root, child, path+"."+keyword+"."+name, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"items", "additionalProperties", "contains", "propertyNames", "not"} {
+		if child, ok := schema[keyword].(map[string]any); ok {
+			if err := checkSchemaNode(root, child, path+"."+keyword, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems", "items"} {
+		branches, _ := schema[keyword].([]any)
+		for i, branch := range branches {
+			if child, ok := branch.(map[string]any); ok {
+				if err := checkSchemaNode(root, child, fmt.Sprintf("%s.%s[%d]", path, keyword, i), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateJSONSchema(schema json.RawMessage, value any, path string) []string {
+	if len(schema) == 0 {
+		return nil
+	}
+	var node map[string]any
+	if err := json.Unmarshal(schema, &node); err != nil {
+		return nil
+	}
+	return validateSchemaNode(node, node, value, path, 0)
+}
+
+func matchesSchemaNode(root map[string]any, branch any, value any, path string, depth int) bool {
+	node, ok := branch.(map[string]any)
+	return ok && len(validateSchemaNode(root, node, value, path, depth)) == 0
+}
+
+func validateSchemaNode(root, schema map[string]any, value any, path string, depth int) []string {
+	if depth > maxSchemaDepth {
+		return []string{fmt.Sprintf("%s: schema nesting is too deep", path)}
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := resolveSchemaRef(root, ref)
+		if err != nil {
+			return []string{fmt.Sprintf("%s: %v", path, err)}
+		}
+		if problems := validateSchemaNode(root, target, value, path, depth+1); len(problems) > 0 {
+			return problems
+		}
+	}
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, schemaType := range types {
+			if matchesSchemaType(value, schemaType) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return []string{fmt.Sprintf("%s: expected %s", path, strings.Join(types, " or "))}
+		}
+	}
+	var problems []string
+	if enum, ok := schema["enum"].([]any); ok {
+		allowed := false
+		for _, candidate := range enum {
+			if reflect.DeepEqual(candidate, value) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			values, _ := json.Marshal(enum)
+			problems = append(problems, fmt.Sprintf("%s: must be one of %s", path, values))
+		}
+	}
+	if expected, ok := schema["const"]; ok && !reflect.DeepEqual(expected, value) {
+		encoded, _ := json.Marshal(expected)
+		problems = append(problems, fmt.Sprintf("%s: must be %s", path, encoded))
+	}
+	if branches, ok := schema["allOf"].([]any); ok {
+		for _, branch := range branches {
+			if node, ok := branch.(map[string]any); ok {
+				problems = append(problems, validateSchemaNode(root, node, value, path, depth+1)...)
+			}
+		}
+	}
+	if branches, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, branch := range branches {
+			if matchesSchemaNode(root, branch, value, path, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			problems = append(problems, fmt.Sprintf("%s: does not match any allowed schema", path))
+		}
+	}
+	if branches, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, branch := range branches {
+			if matchesSchemaNode(root, branch, value, path, depth+1) {
+				matches++
+			}
+		}
+		switch {
+		case matches == 0:
+			problems = append(problems, fmt.Sprintf("%s: does not match any allowed schema", path))
+		case matches > 1:
+			problems = append(problems, fmt.Sprintf("%s: matches %d schemas but must match exactly one", path, matches))
+		}
+	}
+	if branch, ok := schema["not"]; ok && matchesSchemaNode(root, branch, value, path, depth+1) {
+		problems = append(problems, fmt.Sprintf("%s: must not match the excluded schema", path))
+	}
+	switch v := value.(type) {
+	case float64:
+		if minimum, ok := schemaNumber(schema, "minimum"); ok && v < minimum {
+			problems = append(problems, fmt.Sprintf("%s: must be >= %s", path, formatSchemaNumber(minimum)))
+		}
+		if maximum, ok := schemaNumber(schema, "maximum"); ok && v > maximum {
+			problems = append(problems, fmt.Sprintf("%s: must be <= %s", path, formatSchemaNumber(maximum)))
+		}
+		if minimum, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= minimum {
+			problems = append(problems, fmt.Sprintf("%s: must be > %s", path, formatSchemaNumber(minimum)))
+		}
+		if maximum, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= maximum {
+			problems = append(problems, fmt.Sprintf("%s: must be < %s", path, formatSchemaNumber(maximum)))
+		}
+		if divisor, ok := schemaNumber(schema, "multipleOf"); ok && divisor > 0 {
+			if quotient := v / divisor; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+				problems = append(problems, fmt.Sprintf("%s: must be a multiple of %s", path, formatSchemaNumber(divisor)))
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if minLength, ok := schemaCount(schema, "minLength"); ok && length < minLength {
+			problems = append(problems, fmt.Sprintf("%s: must be at least %d characters", path, minLength))
+		}
+		if maxLength, ok := schemaCount(schema, "maxLength"); ok && length > maxLength {
+			problems = append(problems, fmt.Sprintf("%s: must be at most %d characters", path, maxLength))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				problems = append(problems, fmt.Sprintf("%s: must match pattern %q", path, pattern))
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		patternProperties, _ := schema["patternProperties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				key, _ := name.(string)
+				if _, present := v[key]; key != "" && !present {
+					problems = append(problems, fmt.Sprintf("%s.%s: is required", path, key))
+				}
+			}
+		}
+		if minProperties, ok := schemaCount(schema, "minProperties"); ok && len(v) < minProperties {
+			problems = append(problems, fmt.Sprintf("%s: must have at least %d properties", path, minProperties))
+		}
+		if maxProperties, ok := schemaCount(schema, "maxProperties"); ok && len(v) > maxProperties {
+			problems = append(problems, fmt.Sprintf("%s: must have at most %d properties", path, maxProperties))
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if names, ok := schema["propertyNames"].(map[string]any); ok {
+				problems = append(problems, validateSchemaNode(root, names, key, path+"."+key+" (name)", depth+1)...)
+			}
+			matched := false
+			if node, ok := properties[key].(map[string]any); ok {
+				problems = append(problems, validateSchemaNode(root, node, v[key], path+"."+key, depth+1)...)
+				matched = true
+			}
+			for pattern, branch := range patternProperties {
+				re, err := regexp.Compile(pattern)
+				if err != nil || !re.MatchString(key) {
+					continue
+				}
+				if node, ok := branch.(map[string]any); ok {
+					problems = append(problems, validateSchemaNode(root, node, v[key], path+"."+key, depth+1)...)
+				}
+				matched = true
+			}
+			if matched {
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("%s.%s: is not allowed", path, key))
+				}
+			case map[string]any:
+				problems = append(problems, validateSchemaNode(root, additional, v[key], path+"."+key, depth+1)...)
+			}
+		}
+	case []any:
+		if minItems, ok := schemaCount(schema, "minItems"); ok && len(v) < minItems {
+			problems = append(problems, fmt.Sprintf("%s: must have at least %d items", path, minItems))
+		}
+		if maxItems, ok := schemaCount(schema, "maxItems"); ok && len(v) > maxItems {
+			problems = append(problems, fmt.Sprintf("%s: must have at most %d items", path, maxItems))
+		}
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+			for i := 1; i < len(v); i++ {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						problems = append(problems, fmt.Sprintf("%s[%d]: duplicates item %d", path, i, j))
+					}
+				}
+			}
+		}
+		prefix, _ := schema["prefixItems"].([]any)
+		if tuple, ok := schema["items"].([]any); ok && prefix == nil {
+			prefix = tuple
+		}
+		for i, item := range v {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if i < len(prefix) {
+				if node, ok := prefix[i].(map[string]any); ok {
+					problems = append(problems, validateSchemaNode(root, node, item, itemPath, depth+1)...)
+				}
+				continue
+			}
+			if node, ok := schema["items"].(map[string]any); ok {
+				problems = append(problems, validateSchemaNode(root, node, item, itemPath, depth+1)...)
+			}
+		}
+		if contains, ok := schema["contains"]; ok {
+			matched := false
+			for i, item := range v {
+				if matchesSchemaNode(root, contains, item, fmt.Sprintf("%s[%d]", path, i), depth+1) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				problems = append(problems, fmt.Sprintf("%s: must contain at least one matching item", path))
+			}
+		}
+	}
+	return problems
+}
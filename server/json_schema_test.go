@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   string
+	}{
+		{"ref to defs", `{"$defs":{"id":{"type":"integer"}},"properties":{"id":{"$ref":"#/$defs/id"}}}`, `{"id":"x"}`, "$.id: expected integer"},
+		{"ref to definitions", `{"definitions":{"id":{"minimum":1}},"$ref":"#/definitions/id"}`, `0`, "$: must be >= 1"},
+		{"recursive ref", `{"type":"object","properties":{"child":{"$ref":"#"}},"additionalProperties":false}`, `{"child":{"child":{"extra":1}}}`, "$.child.child.extra: is not allowed"},
+		{"allOf", `{"allOf":[{"required":["a"]},{"required":["b"]}]}`, `{"a":1}`, "$.b: is required"},
+		{"oneOf none", `{"oneOf":[{"type":"string"},{"type":"integer"}]}`, `true`, "does not match any allowed schema"},
+		{"oneOf several", `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, `3`, "must match exactly one"},
+		{"oneOf exactly one", `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, `3.5`, ""},
+		{"const", `{"const":"v1"}`, `"v2"`, `$: must be "v1"`},
+		{"not", `{"not":{"type":"null"}}`, `null`, "must not match"},
+		{"minimum", `{"minimum":0}`, `-1`, "$: must be >= 0"},
+		{"maximum", `{"maximum":10}`, `11`, "$: must be <= 10"},
+		{"exclusiveMaximum", `{"exclusiveMaximum":10}`, `10`, "$: must be < 10"},
+		{"multipleOf", `{"multipleOf":0.5}`, `1.25`, "multiple of 0.5"},
+		{"minLength counts runes", `{"minLength":3}`, `"日本"`, "at least 3 characters"},
+		{"maxLength", `{"maxLength":2}`, `"abc"`, "at most 2 characters"},
+		{"pattern", `{"pattern":"^[a-z]+$"}`, `"ABC"`, "must match pattern"},
+		{"pattern ok", `{"pattern":"^[a-z]+$"}`, `"abc"`, ""},
+		{"minItems", `{"minItems":2}`, `[1]`, "at least 2 items"},
+		{"maxItems", `{"maxItems":1}`, `[1,2]`, "at most 1 items"},
+		{"uniqueItems", `{"uniqueItems":true}`, `[1,2,1]`, "$[2]: duplicates item 0"},
+		{"prefixItems", `{"prefixItems":[{"type":"string"}],"items":{"type":"integer"}}`, `["a","b"]`, "$[1]: expected integer"},
+		{"contains", `{"contains":{"const":3}}`, `[1,2]`, "at least one matching item"},
+		{"patternProperties", `{"patternProperties":{"^x-":{"type":"string"}},"additionalProperties":false}`, `{"x-a":1}`, "$.x-a: expected string"},
+		{"propertyNames", `{"propertyNames":{"maxLength":3}}`, `{"long":1}`, "at most 3 characters"},
+		{"minProperties", `{"minProperties":1}`, `{}`, "at least 1 properties"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			problems := strings.Join(validateJSONSchema(json.RawMessage(tt.schema), value, "$"), "; ")
+			if tt.want == "" && problems != "" {
+				t.Fatalf("got %q, want no problems", problems)
+			}
+			if !strings.Contains(problems, tt.want) {
+				t.Fatalf("got %q, want %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"valid", `{"type":"object","$defs":{"a":{"type":"string","pattern":"^a"}},"properties":{"a":{"$ref":"#/$defs/a"}}}`, ""},
+		{"not an object", `[]`, "must be a JSON schema object"},
+		{"remote ref", `{"$ref":"https://example.com/schema.json"}`, "only local references"},
+		{"missing ref", `{"properties":{"a":{"$ref":"#/$defs/missing"}}}`, "cannot be resolved"},
+		{"bad pattern", `{"properties":{"a":{"pattern":"(?=x)"}}}`, "schema.properties.a.pattern: invalid regular expression"},
+		{"unsupported keyword", `{"items":[{"if":{"type":"string"}}]}`, `keyword "if" is not supported`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkJSONSchema(json.RawMessage(tt.schema), "schema")
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v, want a valid schema", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
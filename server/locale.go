@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultTimezone = "Asia/Shanghai"
+	defaultLocale   = "zh-CN"
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+type UpdateDeviceLocaleRequest struct {
+	DeviceID string `json:"device_id"`
+	Timezone string `json:"timezone"`
+	Locale   string `json:"locale"`
+}
+
+func validateLocale(timezone, locale string) error {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", timezone)
+		}
+	}
+	if locale != "" && !localePattern.MatchString(locale) {
+		return fmt.Errorf("invalid locale %q", locale)
+	}
+	return nil
+}
+
+func resolveLocale(cfg *Config, device *CldDevice, timezone, locale string) (string, string) {
+	if timezone == "" && device != nil && device.Timezone != nil {
+		timezone = *device.Timezone
+	}
+	if locale == "" && device != nil && device.Locale != nil {
+		locale = *device.Locale
+	}
+	if timezone == "" {
+		timezone = cfg.Timezone
+	}
+	if locale == "" {
+		locale = cfg.Locale
+	}
+	return timezone, locale
+}
+
+func requestLocale(c *gin.Context) (string, string) {
+	timezone := strings.TrimSpace(c.GetHeader("X-Timezone"))
+	if timezone == "" {
+		timezone = c.Query("timezone")
+	}
+	locale := strings.TrimSpace(c.GetHeader("X-Locale"))
+	if locale == "" {
+		locale = c.Query("locale")
+	}
+	return timezone, locale
+}
+
+func acceptLanguage(locale string) string {
+	if locale == "" {
+		locale = defaultLocale
+	}
+	parts := []string{locale}
+	language := strings.ToLower(strings.SplitN(locale, "-", 2)[0])
+	if language != locale {
+		parts = append(parts, language+";q=0.9")
+	}
+	if language != "en" {
+		parts = append(parts, "en;q=0.8")
+	}
+	return strings.Join(parts, ",")
+}
+
+func (h *Handler) UpdateDeviceLocale(c *gin.Context) {
+	var req UpdateDeviceLocaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.DeviceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id is required"})
+		return
+	}
+	if err := validateLocale(req.Timezone, req.Locale); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.UpdateDeviceLocale(req.DeviceID, req.Timezone, req.Locale); err != nil {
+		log.Printf("Failed to update device locale: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update locale"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Locale updated successfully"})
+}
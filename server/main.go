@@ -6,6 +6,7 @@ import (
 	"os"
 	"sync"
 
+	"claude-server/fakeclaude"
 	"github.com/gin-gonic/gin"
 )
 
@@ -52,6 +53,7 @@ var (
 )
 
 func main() {
+	fakeUpstream := false
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "--diagnose-mcp", "-d":
@@ -60,7 +62,10 @@ func main() {
 				log.Fatal("配置加载失败:", err)
 			}
 			globalConfig = config
-			DiagnoseMCP(config)
+			InitAccountPool(config)
+			for _, account := range accountPool.accounts {
+				DiagnoseMCP(config, account)
+			}
 			return
 		case "--test-mcp-client", "-t":
 			config, err := LoadConfig("src/config.yaml")
@@ -81,29 +86,25 @@ func main() {
 				log.Println("    enabled: true")
 				return
 			}
+			InitAccountPool(config)
 			successCount := 0
 			failCount := 0
-			for _, connector := range config.MCPConnectors {
-				if !connector.Enabled {
-					log.Printf("\n⏭️  Skipping disabled connector: %s", connector.Name)
-					continue
-				}
-				log.Printf("\n======================================================================")
-				log.Printf("Testing MCP Connector: %s", connector.Name)
-				log.Printf("======================================================================")
-				err := TestMCPConnection(
-					config,
-					config.GetOrganizationID(),
-					config.GetSessionKey(),
-					config.GetCookie(),
-					connector.UUID,
-				)
-				if err != nil {
-					log.Printf("❌ MCP connector '%s' test failed: %v\n", connector.Name, err)
-					failCount++
-				} else {
-					log.Printf("✅ MCP connector '%s' test succeeded!\n", connector.Name)
-					successCount++
+			for _, account := range accountPool.accounts {
+				for _, connector := range config.MCPConnectors {
+					if !connector.Enabled {
+						log.Printf("\n⏭️  Skipping disabled connector: %s", connector.Name)
+						continue
+					}
+					log.Printf("\n======================================================================")
+					log.Printf("Testing MCP Connector: %s (account %s)", connector.Name, account.Name)
+					log.Printf("======================================================================")
+					if err := TestMCPConnection(config, account, connector.UUID); err != nil {
+						log.Printf("❌ MCP connector '%s' test failed on account %s: %v\n", connector.Name, account.Name, err)
+						failCount++
+					} else {
+						log.Printf("✅ MCP connector '%s' test succeeded on account %s!\n", connector.Name, account.Name)
+						successCount++
+					}
 				}
 			}
 			log.Println("\n======================================================================")
@@ -113,12 +114,35 @@ func main() {
 				os.Exit(1)
 			}
 			return
+		case "--create-api-key", "-k":
+			config, err := LoadConfig("src/config.yaml")
+			if err != nil {
+				log.Fatal("配置加载失败:", err)
+			}
+			if err := CreateAPIKeyCommand(config, os.Args[2:]); err != nil {
+				log.Fatal("创建 API 密钥失败:", err)
+			}
+			return
+		case "--create-admin", "-a":
+			config, err := LoadConfig("src/config.yaml")
+			if err != nil {
+				log.Fatal("配置加载失败:", err)
+			}
+			if err := CreateAdminCommand(config, os.Args[2:]); err != nil {
+				log.Fatal("创建管理员失败:", err)
+			}
+			return
+		case "--fake-upstream", "-f":
+			fakeUpstream = true
 		case "--help", "-h":
 			fmt.Println("Claude Adapter - MCP Integration Tool")
 			fmt.Println("\nUsage:")
 			fmt.Println("  claude-adapter                启动HTTP服务器")
 			fmt.Println("  claude-adapter -d             运行MCP诊断")
 			fmt.Println("  claude-adapter -t             测试MCP客户端（模拟Claude前端）")
+			fmt.Println("  claude-adapter -f             使用本地模拟上游启动HTTP服务器")
+			fmt.Println("  claude-adapter -k <名称> [权限] [设备ID]  创建API密钥（权限: chat,read-history,admin）")
+			fmt.Println("  claude-adapter -a <用户名> [密码]  创建管理员账号（省略密码时从标准输入读取）")
 			fmt.Println("  claude-adapter --help         显示帮助信息")
 			return
 		}
@@ -127,6 +151,22 @@ func main() {
 	if err != nil {
 		log.Fatal("配置加载失败:", err)
 	}
+	if fakeUpstream {
+		var orgIDs []string
+		for _, account := range config.GetAccounts() {
+			if account.Tokens.OrganizationID != "" {
+				orgIDs = append(orgIDs, account.Tokens.OrganizationID)
+			}
+		}
+		fake := fakeclaude.New(orgIDs...)
+		baseURL, err := fake.Start("127.0.0.1:0")
+		if err != nil {
+			log.Fatal("模拟上游启动失败:", err)
+		}
+		defer fake.Close()
+		config.UpstreamBaseURL = baseURL
+		log.Printf("✓ 模拟上游已启动: %s", baseURL)
+	}
 	globalConfig = config
 	db, err = InitDB(config)
 	if err != nil {
@@ -134,6 +174,14 @@ func main() {
 	}
 	defer db.Close()
 	InitRequestLimiter(config.RequestIntervalMS)
+	InitAdminSessions(config)
+	if count, err := db.CountAdmins(); err == nil && count == 0 {
+		log.Println("⚠ 尚未创建管理员账号，监控面板无法登录，请使用 ./claude-adapter -a <用户名> 创建")
+	}
+	if count, err := db.CountAPIKeys(); err == nil && count == 0 && config.apiKeyRequired() {
+		log.Println("⚠ 已开启 require_api_key 但尚未创建 API 密钥，对话接口将拒绝所有请求，请使用 ./claude-adapter -k <名称> 创建")
+	}
+	InitAccountPool(config)
 	if err := db.LoadStats(); err != nil {
 		log.Printf("加载统计信息失败: %v", err)
 	}
@@ -148,6 +196,7 @@ func main() {
 	}
 	go MonitorPromptChanges()
 	go MonitorUsage(config, db)
+	go MonitorServiceState(config, db)
 	r := SetupRouter(config, db)
 	if err := r.Run(config.GetServerAddr()); err != nil {
 		log.Fatal("服务器启动失败:", err)
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-server/fakeclaude"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testOrgID = "test-org"
+
+type testEnv struct {
+	t      *testing.T
+	cfg    *Config
+	db     *Database
+	fake   *fakeclaude.Server
+	server *httptest.Server
+	apiKey string
+}
+
+func newTestEnv(t *testing.T, configure func(*Config)) *testEnv {
+	t.Helper()
+	if os.Getenv("TEST_DB_NAME") == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DB_NAME must be set in CI")
+		}
+		t.Skip("TEST_DB_NAME is not set, skipping handler test")
+	}
+	gin.SetMode(gin.TestMode)
+	fake := fakeclaude.New(testOrgID)
+	upstream := httptest.NewServer(fake)
+	t.Cleanup(upstream.Close)
+	port, _ := strconv.Atoi(os.Getenv("TEST_DB_PORT"))
+	cfg := &Config{
+		Accounts: []AccountConfig{{
+			Name:   "test",
+			Tokens: TokensConfig{OrganizationID: testOrgID, SessionKey: "sk-ant-test"},
+		}},
+		UpstreamBaseURL: upstream.URL,
+		DBHost:          os.Getenv("TEST_DB_HOST"),
+		DBPort:          port,
+		DBUser:          os.Getenv("TEST_DB_USER"),
+		DBPassword:      os.Getenv("TEST_DB_PASSWORD"),
+		DBName:          os.Getenv("TEST_DB_NAME"),
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	cfg.SetDefaults()
+	database, err := InitDB(cfg)
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Exec("TRUNCATE cld_dialogue, cld_conversation, cld_compat_cache, cld_api_key, cld_admin, cld_device RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("failed to reset test database: %v", err)
+	}
+	globalConfig = cfg
+	db = database
+	InitAccountPool(cfg)
+	server := httptest.NewServer(SetupRouter(cfg, database))
+	t.Cleanup(server.Close)
+	apiKey, _, err := issueAPIKey(database, "test", scopeChat+","+scopeReadHistory, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+	return &testEnv{t: t, cfg: cfg, db: database, fake: fake, server: server, apiKey: apiKey}
+}
+
+func (e *testEnv) do(method, path, deviceID string, body any) *http.Response {
+	e.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			e.t.Fatalf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, e.server.URL+path, reader)
+	if err != nil {
+		e.t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	if deviceID != "" {
+		req.Header.Set("X-Device-ID", deviceID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		e.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	e.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (e *testEnv) doJSON(method, path, deviceID string, body any, wantStatus int, out any) *http.Response {
+	e.t.Helper()
+	resp := e.do(method, path, deviceID, body)
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		e.t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, wantStatus, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			e.t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+func (e *testEnv) waitForRequests(route string, count int) []fakeclaude.Request {
+	e.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		requests := e.fake.Requests(route)
+		if len(requests) >= count {
+			return requests
+		}
+		if time.Now().After(deadline) {
+			e.t.Fatalf("timed out waiting for %d %s request(s), got %d", count, route, len(requests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func chatRequest(content string, extra map[string]any) map[string]any {
+	req := map[string]any{
+		"messages": []map[string]any{{"role": "user", "content": content}},
+	}
+	for key, value := range extra {
+		req[key] = value
+	}
+	return req
+}
+
+func readSSEData(t *testing.T, body io.Reader) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read event stream: %v", err)
+	}
+	return events
+}
+
+func completionPrompt(t *testing.T, request fakeclaude.Request) string {
+	t.Helper()
+	var body struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		t.Fatalf("invalid completion request body %q: %v", request.Body, err)
+	}
+	return body.Prompt
+}
+
+func conversationFromPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if part == "chat_conversations" && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
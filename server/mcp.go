@@ -75,18 +75,18 @@ func (m *MCPManager) Connect(serverID string) error {
 	return nil
 }
 
-func (m *MCPManager) autoEnableAllTools(serverID string) {
+func (m *MCPManager) autoEnableAllTools(account *Account, serverID string) {
 	if m.config.Debug {
 		DebugLog("Auto-enabling all tools for MCP connector: %s", serverID)
 	}
-	tools := m.getAllToolsForServer(serverID)
+	tools := m.getAllToolsForServer(account, serverID)
 	if len(tools) == 0 {
 		if m.config.Debug {
 			DebugLog("No specific tools found for server %s, using wildcard enablement", serverID)
 		}
 		tools = m.getDefaultToolsForServer(serverID)
 	}
-	if err := m.enableToolsInClaudeAPI(tools); err != nil {
+	if err := m.enableToolsInClaudeAPI(account, tools); err != nil {
 		log.Printf("Failed to auto-enable tools for MCP connector %s: %v", serverID, err)
 	} else {
 		if m.config.Debug {
@@ -96,8 +96,8 @@ func (m *MCPManager) autoEnableAllTools(serverID string) {
 	}
 }
 
-func (m *MCPManager) getAllToolsForServer(serverID string) []MCPTool {
-	servers, err := getMCPServers(m.config.GetOrganizationID(), m.config.GetCookie())
+func (m *MCPManager) getAllToolsForServer(account *Account, serverID string) []MCPTool {
+	servers, err := getMCPServers(account.config.Tokens.OrganizationID, account.config.GetCookie())
 	if err != nil {
 		if m.config.Debug {
 			DebugLog("Failed to get MCP servers: %v", err)
@@ -139,7 +139,7 @@ func (m *MCPManager) getDefaultToolsForServer(serverID string) []MCPTool {
 	return tools
 }
 
-func (m *MCPManager) enableToolsInClaudeAPI(tools []MCPTool) error {
+func (m *MCPManager) enableToolsInClaudeAPI(account *Account, tools []MCPTool) error {
 	if len(tools) == 0 {
 		return nil
 	}
@@ -150,7 +150,7 @@ func (m *MCPManager) enableToolsInClaudeAPI(tools []MCPTool) error {
 	request := MCPToolEnableRequest{
 		EnabledMCPTools: enabledTools,
 	}
-	return patchAccountSettings(m.config.GetOrganizationID(), m.config.GetCookie(), request)
+	return patchAccountSettings(account.config.Tokens.OrganizationID, account.config.GetCookie(), request)
 }
 
 func (m *MCPManager) EnsureAllMCPToolsEnabled() error {
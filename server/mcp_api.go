@@ -10,7 +10,7 @@ import (
 )
 
 func patchAccountSettings(_, cookie string, request MCPToolEnableRequest) error {
-	url := "https://claude.ai/api/account/settings"
+	url := globalConfig.UpstreamURL("/api/account/settings")
 	jsonData, err := json.Marshal(request)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %v", err)
@@ -24,9 +24,9 @@ func patchAccountSettings(_, cookie string, request MCPToolEnableRequest) error
 	req.Header.Set("Cookie", cookie)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
-	req.Header.Set("Origin", "https://claude.ai")
-	req.Header.Set("Referer", "https://claude.ai/")
+	req.Header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
+	req.Header.Set("Origin", globalConfig.UpstreamURL(""))
+	req.Header.Set("Referer", globalConfig.UpstreamURL("/"))
 	req.Header.Set("Sec-Ch-Ua", `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`)
 	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
 	req.Header.Set("Sec-Ch-Ua-Platform", `"Windows"`)
@@ -50,7 +50,7 @@ func patchAccountSettings(_, cookie string, request MCPToolEnableRequest) error
 }
 
 func getMCPServers(organizationID, cookie string) ([]MCPRemoteServer, error) {
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/mcp/remote_servers", organizationID)
+	url := globalConfig.UpstreamURL("/api/organizations/%s/mcp/remote_servers", organizationID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
@@ -58,9 +58,9 @@ func getMCPServers(organizationID, cookie string) ([]MCPRemoteServer, error) {
 	req.Header.Set("Cookie", cookie)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
-	req.Header.Set("Origin", "https://claude.ai")
-	req.Header.Set("Referer", "https://claude.ai/")
+	req.Header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
+	req.Header.Set("Origin", globalConfig.UpstreamURL(""))
+	req.Header.Set("Referer", globalConfig.UpstreamURL("/"))
 	req.Header.Set("Sec-Ch-Ua", `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`)
 	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
 	req.Header.Set("Sec-Ch-Ua-Platform", `"Windows"`)
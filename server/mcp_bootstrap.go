@@ -24,8 +24,13 @@ func HandleMCPBootstrap(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported"})
 		return
 	}
-	log.Printf("Starting MCP bootstrap stream...")
-	tools, err := GetRemoteMCPToolsViaBootstrap(globalConfig)
+	account := accountPool.forOrganization(orgID)
+	if account == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available account"})
+		return
+	}
+	log.Printf("Starting MCP bootstrap stream for account %s...", account.Name)
+	tools, err := GetRemoteMCPToolsViaBootstrap(globalConfig, account)
 	if err != nil {
 		log.Printf("Failed to get remote MCP tools: %v", err)
 		fmt.Fprintf(c.Writer, "event: error\n")
@@ -110,7 +115,12 @@ func HandleMCPRemoteServersAdapter(c *gin.Context) {
 	if globalConfig.Debug {
 		DebugLog("MCP Remote Servers request")
 	}
-	servers, err := GetRemoteMCPServers(globalConfig)
+	account := accountPool.forOrganization(c.Param("org_id"))
+	if account == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available account"})
+		return
+	}
+	servers, err := GetRemoteMCPServers(globalConfig, account)
 	if err != nil {
 		log.Printf("Failed to get remote MCP servers: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
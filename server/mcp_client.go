@@ -44,12 +44,12 @@ type MCPConnection struct {
 	mu         sync.Mutex
 }
 
-func NewMCPClient(config *Config, orgID, sessionKey, cookie string) *MCPClient {
+func NewMCPClient(config *Config, account *Account) *MCPClient {
 	return &MCPClient{
 		config:      config,
-		orgID:       orgID,
-		sessionKey:  sessionKey,
-		cookie:      cookie,
+		orgID:       account.config.Tokens.OrganizationID,
+		sessionKey:  account.config.Tokens.SessionKey,
+		cookie:      account.config.GetCookie(),
 		deviceID:    "bf4867fd-1cbf-4778-933f-c5d8eb8aa670",
 		anonymousID: "claudeai.v1.d5a52e83-fad9-435c-8c21-6d4e1fe76116",
 		httpClient:  config.CreateHTTPClient(30 * time.Second),
@@ -57,7 +57,7 @@ func NewMCPClient(config *Config, orgID, sessionKey, cookie string) *MCPClient {
 }
 
 func (c *MCPClient) GetRemoteServers() ([]MCPServerInfo, error) {
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/mcp/remote_servers", c.orgID)
+	url := c.config.UpstreamURL("/api/organizations/%s/mcp/remote_servers", c.orgID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request failed: %v", err)
@@ -98,7 +98,7 @@ func (c *MCPClient) ConnectToServer(serverUUID string) (*MCPConnection, error) {
 	if serverInfo == nil {
 		return nil, fmt.Errorf("server %s not found", serverUUID)
 	}
-	wsURL := fmt.Sprintf("wss://claude.ai/api/ws/organizations/%s/mcp/servers/%s/",
+	wsURL := c.config.UpstreamWSURL("/api/ws/organizations/%s/mcp/servers/%s/",
 		c.orgID, serverUUID)
 	log.Printf("Connecting to MCP server via WebSocket...")
 	log.Printf("   Server: %s (%s)", serverInfo.Name, serverUUID)
@@ -140,13 +140,13 @@ func (c *MCPClient) ConnectToServer(serverUUID string) (*MCPConnection, error) {
 		}
 	}
 	header := http.Header{}
-	header.Set("Origin", "https://claude.ai")
+	header.Set("Origin", c.config.UpstreamURL(""))
 	header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36")
 	header.Set("Cookie", fullCookie)
 	header.Set("Sec-WebSocket-Protocol", "mcp")
 	header.Set("Cache-Control", "no-cache")
 	header.Set("Pragma", "no-cache")
-	header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
 	if c.config.Debug {
 		DebugLog("WebSocket Request Headers:")
 		for key, values := range header {
@@ -256,15 +256,15 @@ func (c *MCPClient) ConnectToServer(serverUUID string) (*MCPConnection, error) {
 
 func (c *MCPClient) setCommonHeaders(req *http.Request) {
 	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	req.Header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
 	req.Header.Set("anthropic-client-platform", "web_claude_ai")
 	req.Header.Set("anthropic-client-version", "1.0.0")
 	req.Header.Set("anthropic-device-id", c.deviceID)
 	req.Header.Set("anthropic-anonymous-id", c.anonymousID)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
 	req.Header.Set("Cookie", c.cookie)
-	req.Header.Set("Referer", "https://claude.ai/")
-	req.Header.Set("Origin", "https://claude.ai")
+	req.Header.Set("Referer", c.config.UpstreamURL("/"))
+	req.Header.Set("Origin", c.config.UpstreamURL(""))
 }
 
 func (conn *MCPConnection) Initialize() error {
@@ -507,11 +507,12 @@ func (conn *MCPConnection) Close() error {
 	return nil
 }
 
-func TestMCPConnection(config *Config, orgID, sessionKey, cookie, serverUUID string) error {
+func TestMCPConnection(config *Config, account *Account, serverUUID string) error {
 	log.Printf("Starting MCP connection test...")
-	log.Printf("   Organization: %s", orgID)
+	log.Printf("   Account: %s", account.Name)
+	log.Printf("   Organization: %s", account.config.Tokens.OrganizationID)
 	log.Printf("   Server UUID: %s", serverUUID)
-	client := NewMCPClient(config, orgID, sessionKey, cookie)
+	client := NewMCPClient(config, account)
 	conn, err := client.ConnectToServer(serverUUID)
 	if err != nil {
 		return fmt.Errorf("connect failed: %v", err)
@@ -8,12 +8,12 @@ import (
 	"time"
 )
 
-func DiagnoseMCP(config *Config) {
+func DiagnoseMCP(config *Config, account *Account) {
 	fmt.Println("\n" + strings.Repeat("=", 80))
-	fmt.Println("MCP Diagnostic Tool")
+	fmt.Printf("MCP Diagnostic Tool - account %s (%s)\n", account.Name, account.config.Tokens.OrganizationID)
 	fmt.Println(strings.Repeat("=", 80) + "\n")
 	fmt.Println("Step 1: Testing remote MCP server list...")
-	servers, err := GetRemoteMCPServers(config)
+	servers, err := GetRemoteMCPServers(config, account)
 	if err != nil {
 		log.Printf("Failed to get remote MCP servers: %v", err)
 	} else {
@@ -28,7 +28,7 @@ func DiagnoseMCP(config *Config) {
 	}
 	fmt.Println()
 	fmt.Println("Step 2: Testing Bootstrap SSE stream to get tools...")
-	tools, err := GetRemoteMCPToolsViaBootstrap(config)
+	tools, err := GetRemoteMCPToolsViaBootstrap(config, account)
 	if err != nil {
 		log.Printf("Failed to get remote MCP tools: %v", err)
 	} else {
@@ -45,7 +45,7 @@ func DiagnoseMCP(config *Config) {
 	fmt.Println()
 	if mcpManager != nil {
 		fmt.Println("Step 3: Testing MCP Manager...")
-		allTools := mcpManager.GetAllMCPTools()
+		allTools := mcpManager.GetAllMCPTools(account)
 		log.Printf("MCP Manager returned %d tools", len(allTools))
 		remoteCount := 0
 		localCount := 0
@@ -69,11 +69,11 @@ func DiagnoseMCP(config *Config) {
 	fmt.Println()
 	fmt.Println("Step 4: Testing build completion request...")
 	if mcpManager != nil {
-		tools := mcpManager.GetAllMCPTools()
+		tools := mcpManager.GetAllMCPTools(account)
 		reqBody := map[string]any{
 			"prompt":              "Test message",
 			"parent_message_uuid": "00000000-0000-4000-8000-000000000000",
-			"timezone":            "Asia/Shanghai",
+			"timezone":            globalConfig.Timezone,
 			"tools":               tools,
 			"attachments":         []any{},
 			"files":               []any{},
@@ -108,9 +108,9 @@ func DiagnoseMCP(config *Config) {
 	fmt.Println(strings.Repeat("=", 80) + "\n")
 }
 
-func TestRemoteMCPConnection(config *Config, serverUUID string) error {
+func TestRemoteMCPConnection(config *Config, account *Account, serverUUID string) error {
 	fmt.Printf("\nTesting connection to remote MCP server: %s\n", serverUUID)
-	servers, err := GetRemoteMCPServers(config)
+	servers, err := GetRemoteMCPServers(config, account)
 	if err != nil {
 		return fmt.Errorf("failed to get server list: %v", err)
 	}
@@ -129,7 +129,7 @@ func TestRemoteMCPConnection(config *Config, serverUUID string) error {
 	fmt.Printf("   Authenticated: %v\n", targetServer.IsAuthenticated)
 	fmt.Println("\nTesting Bootstrap SSE stream...")
 	startTime := time.Now()
-	tools, err := GetRemoteMCPToolsViaBootstrap(config)
+	tools, err := GetRemoteMCPToolsViaBootstrap(config, account)
 	duration := time.Since(startTime)
 	if err != nil {
 		return fmt.Errorf("bootstrap stream failed: %v", err)
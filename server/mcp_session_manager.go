@@ -8,6 +8,7 @@ import (
 
 type MCPSessionManager struct {
 	config      *Config
+	account     *Account
 	sessions    map[string]*MCPConnection
 	tools       []map[string]any
 	mu          sync.RWMutex
@@ -15,14 +16,15 @@ type MCPSessionManager struct {
 	initMutex   sync.Mutex
 }
 
-var globalMCPSessionManager *MCPSessionManager
-
 func InitMCPSessionManager(config *Config) {
-	globalMCPSessionManager = &MCPSessionManager{
-		config:      config,
-		sessions:    make(map[string]*MCPConnection),
-		tools:       make([]map[string]any, 0),
-		initialized: false,
+	for _, account := range accountPool.accounts {
+		account.client.mcp = &MCPSessionManager{
+			config:      config,
+			account:     account,
+			sessions:    make(map[string]*MCPConnection),
+			tools:       make([]map[string]any, 0),
+			initialized: false,
+		}
 	}
 }
 
@@ -32,7 +34,7 @@ func (m *MCPSessionManager) EnsureInitialized() error {
 	if m.initialized {
 		return nil
 	}
-	log.Printf("🔧 Initializing MCP sessions...")
+	log.Printf("🔧 Initializing MCP sessions for account %s...", m.account.Name)
 	enabledConnectors := make([]MCPConnectorConfig, 0)
 	for _, connector := range m.config.MCPConnectors {
 		if connector.Enabled {
@@ -45,12 +47,7 @@ func (m *MCPSessionManager) EnsureInitialized() error {
 		return nil
 	}
 	log.Printf("📋 Found %d enabled MCP connector(s)", len(enabledConnectors))
-	client := NewMCPClient(
-		m.config,
-		m.config.GetOrganizationID(),
-		m.config.GetSessionKey(),
-		m.config.GetCookie(),
-	)
+	client := NewMCPClient(m.config, m.account)
 	var wg sync.WaitGroup
 	errorsChan := make(chan error, len(enabledConnectors))
 	allTools := make([][]map[string]any, len(enabledConnectors))
@@ -103,7 +100,7 @@ func (m *MCPSessionManager) EnsureInitialized() error {
 	}
 	m.initialized = true
 	m.mu.Unlock()
-	log.Printf("🎉 MCP initialization complete! Total tools: %d", len(m.tools))
+	log.Printf("🎉 MCP initialization complete for account %s! Total tools: %d", m.account.Name, len(m.tools))
 	return nil
 }
 
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-server/fakeclaude"
+)
+
+func TestMCPToolsUseConversationAccount(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) {
+		cfg.Accounts = append(cfg.Accounts, AccountConfig{
+			Name:   "second",
+			Tokens: TokensConfig{OrganizationID: "org-second", SessionKey: "sk-ant-second"},
+		})
+		cfg.MCPConnectors = []MCPConnectorConfig{{Name: "Fake MCP", UUID: "fake-mcp-server", Enabled: true}}
+	})
+	env.fake.OrgIDs = append(env.fake.OrgIDs, "org-second")
+	InitMCPSessionManager(env.cfg)
+	accountPool.accounts[0].disabledUntil = time.Now().Add(time.Minute)
+	accountPool.accounts[0].disabledReason = "请求受限 (429)"
+	env.fake.QueueCompletion(fakeclaude.TextReply("Hello"))
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-mcp", map[string]any{"request": "Hi"}, http.StatusOK, nil)
+	for _, route := range []string{fakeclaude.RouteRemoteServers, fakeclaude.RouteMCPWebSocket, fakeclaude.RouteCompletion} {
+		requests := env.fake.Requests(route)
+		if len(requests) == 0 {
+			t.Fatalf("no %s request was sent", route)
+		}
+		for _, request := range requests {
+			if !strings.Contains(request.Path, "/organizations/org-second/") {
+				t.Fatalf("%s went to %s, want the second account's organization", route, request.Path)
+			}
+			if !strings.Contains(request.Header.Get("Cookie"), "sk-ant-second") {
+				t.Fatalf("%s was sent with cookie %q, want the second account's session", route, request.Header.Get("Cookie"))
+			}
+		}
+	}
+}
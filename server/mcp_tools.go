@@ -24,15 +24,11 @@ type MCPToolsCache struct {
 	lastUpdate time.Time
 }
 
-var mcpToolsCache = &MCPToolsCache{
-	tools: make([]MCPToolDefinition, 0),
-}
-
-func (m *MCPManager) GetAllMCPTools() []map[string]any {
+func (m *MCPManager) GetAllMCPTools(account *Account) []map[string]any {
 	allTools := make([]map[string]any, 0)
-	localTools := m.getLocalMCPTools()
+	localTools := m.getLocalMCPTools(account)
 	allTools = append(allTools, localTools...)
-	remoteTools, err := GetRemoteMCPToolsViaBootstrap(m.config)
+	remoteTools, err := GetRemoteMCPToolsViaBootstrap(m.config, account)
 	if err != nil {
 		if m.config.Debug {
 			DebugLog("Failed to get remote MCP tools: %v", err)
@@ -67,7 +63,8 @@ func (m *MCPManager) GetAllMCPTools() []map[string]any {
 	return allTools
 }
 
-func (m *MCPManager) getLocalMCPTools() []map[string]any {
+func (m *MCPManager) getLocalMCPTools(account *Account) []map[string]any {
+	mcpToolsCache := &account.mcpTools
 	mcpToolsCache.mu.RLock()
 	if time.Since(mcpToolsCache.lastUpdate) < 5*time.Minute && len(mcpToolsCache.tools) > 0 {
 		tools := make([]map[string]any, 0, len(mcpToolsCache.tools))
@@ -88,7 +85,7 @@ func (m *MCPManager) getLocalMCPTools() []map[string]any {
 		return tools
 	}
 	mcpToolsCache.mu.RUnlock()
-	m.refreshMCPTools()
+	m.refreshMCPTools(account)
 	mcpToolsCache.mu.RLock()
 	defer mcpToolsCache.mu.RUnlock()
 	tools := make([]map[string]any, 0, len(mcpToolsCache.tools))
@@ -105,7 +102,7 @@ func (m *MCPManager) getLocalMCPTools() []map[string]any {
 	return tools
 }
 
-func (m *MCPManager) refreshMCPTools() {
+func (m *MCPManager) refreshMCPTools(account *Account) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	allTools := make([]MCPToolDefinition, 0)
@@ -113,7 +110,7 @@ func (m *MCPManager) refreshMCPTools() {
 		if !connector.Enabled || !connector.IsConnected {
 			continue
 		}
-		tools, err := m.getToolsFromMCPServer(id, connector)
+		tools, err := m.getToolsFromMCPServer(account, id, connector)
 		if err != nil {
 			if m.config.Debug {
 				DebugLog("Failed to get tools from MCP server %s: %v", connector.Name, err)
@@ -122,24 +119,24 @@ func (m *MCPManager) refreshMCPTools() {
 		}
 		allTools = append(allTools, tools...)
 	}
-	mcpToolsCache.mu.Lock()
-	mcpToolsCache.tools = allTools
-	mcpToolsCache.lastUpdate = time.Now()
-	mcpToolsCache.mu.Unlock()
+	account.mcpTools.mu.Lock()
+	account.mcpTools.tools = allTools
+	account.mcpTools.lastUpdate = time.Now()
+	account.mcpTools.mu.Unlock()
 	if m.config.Debug {
 		DebugLog("Refreshed MCP tools cache: %d tools from %d servers", len(allTools), len(m.connectors))
 	}
 }
 
-func (m *MCPManager) getToolsFromMCPServer(serverID string, connector *MCPConnector) ([]MCPToolDefinition, error) {
-	wsURL := fmt.Sprintf("wss://claude.ai/api/ws/organizations/%s/mcp/servers/%s/",
-		m.config.GetOrganizationID(), serverID)
+func (m *MCPManager) getToolsFromMCPServer(account *Account, serverID string, connector *MCPConnector) ([]MCPToolDefinition, error) {
+	wsURL := m.config.UpstreamWSURL("/api/ws/organizations/%s/mcp/servers/%s/",
+		account.config.Tokens.OrganizationID, serverID)
 	dialer := websocket.DefaultDialer
 	if m.config.Proxy.Enable {
 	}
 	headers := make(map[string][]string)
-	headers["Cookie"] = []string{m.config.GetCookie()}
-	headers["Origin"] = []string{"https://claude.ai"}
+	headers["Cookie"] = []string{account.config.GetCookie()}
+	headers["Origin"] = []string{m.config.UpstreamURL("")}
 	headers["User-Agent"] = []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"}
 	conn, _, err := dialer.Dial(wsURL, headers)
 	if err != nil {
@@ -26,6 +26,11 @@ func HandleMCPWebSocket(c *gin.Context) {
 	if globalConfig.Debug {
 		DebugLog("📡 MCP WebSocket connection request - OrgID: %s, ServerID: %s", orgID, serverID)
 	}
+	account := accountPool.forOrganization(orgID)
+	if account == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available account"})
+		return
+	}
 	var mcpServerURL string
 	var mcpServerName string
 	if mcpManager != nil {
@@ -42,7 +47,7 @@ func HandleMCPWebSocket(c *gin.Context) {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MCP manager not initialized"})
 		return
 	}
-	log.Printf("🔌 Establishing MCP WebSocket proxy: %s (%s)", mcpServerName, serverID)
+	log.Printf("🔌 Establishing MCP WebSocket proxy: %s (%s) via account %s", mcpServerName, serverID, account.Name)
 	log.Printf("   Client: wss://claude.ai/api/ws/organizations/%s/mcp/servers/%s/", orgID, serverID)
 	log.Printf("   Server: %s (HTTP POST)", mcpServerURL)
 	clientConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -58,6 +63,7 @@ func HandleMCPWebSocket(c *gin.Context) {
 		mcpServerName: mcpServerName,
 		serverID:      serverID,
 		config:        globalConfig,
+		cookie:        account.config.GetCookie(),
 	}
 	session.Handle()
 }
@@ -68,6 +74,7 @@ type MCPWebSocketSession struct {
 	mcpServerName string
 	serverID      string
 	config        *Config
+	cookie        string
 	mu            sync.Mutex
 }
 
@@ -133,8 +140,8 @@ func (s *MCPWebSocketSession) forwardToMCPServer(message []byte) ([]byte, error)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "Claude-Adapter/1.0")
-	if s.config.GetCookie() != "" {
-		req.Header.Set("Cookie", s.config.GetCookie())
+	if s.cookie != "" {
+		req.Header.Set("Cookie", s.cookie)
 	}
 	resp, err := client.Do(req)
 	if err != nil {
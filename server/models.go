@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	ModelCapabilityThinking = "thinking"
+	ModelCapabilityVision   = "vision"
+)
+
+const defaultModelAlias = "default"
+
+var usageBuckets = []string{"five_hour", "seven_day", "seven_day_opus"}
+
+var builtinModels = []ModelConfig{
+	{
+		ID:           "sonnet-4.5",
+		UpstreamID:   "claude-sonnet-4-5-20250929",
+		DisplayName:  "Claude Sonnet 4.5",
+		Aliases:      []string{"claude-sonnet-4.5", "claude-sonnet-4-5"},
+		Capabilities: []string{ModelCapabilityThinking, ModelCapabilityVision},
+		UsageBucket:  "seven_day",
+	},
+	{
+		ID:           "opus-4.1",
+		UpstreamID:   "claude-opus-4-1-20250805",
+		DisplayName:  "Claude Opus 4.1",
+		Aliases:      []string{"claude-opus-4.1", "claude-opus-4-1"},
+		Capabilities: []string{ModelCapabilityThinking, ModelCapabilityVision},
+		UsageBucket:  "seven_day_opus",
+	},
+	{
+		ID:           "haiku-4.5",
+		UpstreamID:   "claude-haiku-4-5-20251001",
+		DisplayName:  "Claude Haiku 4.5",
+		Aliases:      []string{"claude-haiku-4.5", "claude-haiku-4-5"},
+		Capabilities: []string{ModelCapabilityThinking, ModelCapabilityVision},
+		UsageBucket:  "seven_day",
+	},
+}
+
+type UnknownModelError struct {
+	Model     string
+	Available []string
+}
+
+func (e *UnknownModelError) Error() string {
+	return fmt.Sprintf("unknown model %q, available models: %s", e.Model, strings.Join(e.Available, ", "))
+}
+
+func (m *ModelConfig) Names() []string {
+	names := append([]string{m.ID}, m.Aliases...)
+	if m.UpstreamID != "" {
+		names = append(names, m.UpstreamID)
+	}
+	return names
+}
+
+func (m *ModelConfig) Matches(name string) bool {
+	for _, candidate := range m.Names() {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ModelConfig) HasCapability(capability string) bool {
+	return slices.Contains(m.Capabilities, capability)
+}
+
+func (c *Config) modelCatalog() []ModelConfig {
+	if len(c.Models) > 0 {
+		return c.Models
+	}
+	return builtinModels
+}
+
+func (c *Config) validateModels() error {
+	seen := make(map[string]string)
+	for i, model := range c.modelCatalog() {
+		if model.ID == "" {
+			return fmt.Errorf("models[%d] 缺少 id", i)
+		}
+		for _, name := range append([]string{model.ID}, model.Aliases...) {
+			key := strings.ToLower(name)
+			if key == defaultModelAlias {
+				return fmt.Errorf("模型 %s 不能使用保留名称: %s", model.ID, name)
+			}
+			if owner, exists := seen[key]; exists {
+				return fmt.Errorf("模型名称重复: %s (%s, %s)", name, owner, model.ID)
+			}
+			seen[key] = model.ID
+		}
+		if model.UsageBucket != "" && !slices.Contains(usageBuckets, model.UsageBucket) {
+			return fmt.Errorf("模型 %s 的 usage_bucket 无效: %s (可选: %s)", model.ID, model.UsageBucket, strings.Join(usageBuckets, ", "))
+		}
+		for _, capability := range model.Capabilities {
+			if capability != ModelCapabilityThinking && capability != ModelCapabilityVision {
+				return fmt.Errorf("模型 %s 的 capabilities 无效: %s", model.ID, capability)
+			}
+		}
+	}
+	if c.DefaultModel != "" {
+		found := false
+		for _, model := range c.modelCatalog() {
+			if model.Matches(c.DefaultModel) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("default_model %s 不在 models 列表中", c.DefaultModel)
+		}
+	}
+	return nil
+}
+
+func (c *Config) applyModelDefaults() {
+	if len(c.Models) == 0 {
+		c.Models = make([]ModelConfig, len(builtinModels))
+		copy(c.Models, builtinModels)
+	}
+	for i := range c.Models {
+		model := &c.Models[i]
+		for _, builtin := range builtinModels {
+			if !builtin.Matches(model.ID) {
+				continue
+			}
+			if model.UpstreamID == "" {
+				model.UpstreamID = builtin.UpstreamID
+			}
+			if model.DisplayName == "" {
+				model.DisplayName = builtin.DisplayName
+			}
+			if model.Aliases == nil {
+				model.Aliases = builtin.Aliases
+			}
+			if model.Capabilities == nil {
+				model.Capabilities = builtin.Capabilities
+			}
+			if model.UsageBucket == "" {
+				model.UsageBucket = builtin.UsageBucket
+			}
+			break
+		}
+		if model.Object == "" {
+			model.Object = "model"
+		}
+		if model.OwnedBy == "" {
+			model.OwnedBy = "anthropic"
+		}
+		if model.DisplayName == "" {
+			model.DisplayName = model.ID
+		}
+		if model.UsageBucket == "" {
+			model.UsageBucket = "seven_day"
+		}
+	}
+	if c.DefaultModel == "" {
+		c.DefaultModel = c.Models[0].ID
+	}
+}
+
+func (c *Config) ResolveModel(name string) (*ModelConfig, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, defaultModelAlias) {
+		name = c.DefaultModel
+	}
+	for i := range c.Models {
+		if c.Models[i].Matches(name) {
+			return &c.Models[i], nil
+		}
+	}
+	available := make([]string, 0, len(c.Models))
+	for _, model := range c.Models {
+		available = append(available, model.ID)
+	}
+	return nil, &UnknownModelError{Model: name, Available: available}
+}
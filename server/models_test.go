@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestResolveModelDefaultAlias(t *testing.T) {
+	cfg := &Config{DefaultModel: "opus-4.1"}
+	cfg.applyModelDefaults()
+	for _, name := range []string{"", "default", "Default", " default "} {
+		model, err := cfg.ResolveModel(name)
+		if err != nil || model.ID != "opus-4.1" {
+			t.Fatalf("ResolveModel(%q) = %v, %v, want the default model", name, model, err)
+		}
+	}
+	if _, err := cfg.ResolveModel("defaults"); err == nil {
+		t.Fatalf("an unknown model was accepted")
+	}
+}
+
+func TestDefaultIsReservedModelName(t *testing.T) {
+	cfg := &Config{Models: []ModelConfig{{ID: "sonnet-4.5", Aliases: []string{"default"}}}}
+	if err := cfg.validateModels(); err == nil {
+		t.Fatalf("a model alias named default was accepted")
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ollamaVersion = "0.6.0"
+
+func ollamaNow() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05Z")
+}
+
+func ollamaModelDetails() OllamaModelDetails {
+	return OllamaModelDetails{
+		Format:   "api",
+		Family:   "claude",
+		Families: []string{"claude"},
+	}
+}
+
+func (h *Handler) resolveOllamaModel(name string) (*ModelConfig, error) {
+	return h.config.ResolveModel(strings.TrimSuffix(name, ":latest"))
+}
+
+func ollamaStream(stream *bool) bool {
+	return stream == nil || *stream
+}
+
+func applyOllamaOptions(req *CompatRequest, options *OllamaOptions) {
+	if options == nil {
+		return
+	}
+	if options.NumPredict > 0 {
+		req.MaxTokens = options.NumPredict
+	}
+	req.Stop = options.Stop
+}
+
+func writeNDJSON(w http.ResponseWriter, flusher http.Flusher, data any) {
+	jsonData, _ := json.Marshal(data)
+	w.Write(append(jsonData, '\n'))
+	flusher.Flush()
+}
+
+func ollamaStats(result *CompatResult, start, firstToken time.Time) *OllamaStats {
+	end := time.Now()
+	if firstToken.IsZero() {
+		firstToken = end
+	}
+	return &OllamaStats{
+		DoneReason:         result.FinishReason,
+		TotalDuration:      end.Sub(start).Nanoseconds(),
+		PromptEvalCount:    result.PromptTokens(),
+		PromptEvalDuration: firstToken.Sub(start).Nanoseconds(),
+		EvalCount:          result.CompletionTokens(),
+		EvalDuration:       end.Sub(firstToken).Nanoseconds(),
+	}
+}
+
+func (h *Handler) checkOllamaRequest(c *gin.Context, model *ModelConfig) bool {
+	if isBlocked, blockReason, blockResetTime := checkUsageLimits(model); isBlocked {
+		log.Printf("[Usage Limit] Ollama request blocked - Reason: %s, Reset: %s", blockReason, blockResetTime)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("Usage limit exceeded (%s), resets at %s", blockReason, blockResetTime)})
+		return false
+	}
+	return true
+}
+
+func (h *Handler) runOllamaCompletion(c *gin.Context, req CompatRequest, stream bool, frame func(string, *OllamaStats) any) {
+	start := time.Now()
+	var firstToken time.Time
+	if !stream {
+		result, err := h.runCompatCompletion(c, req, func(string) {
+			if firstToken.IsZero() {
+				firstToken = time.Now()
+			}
+		})
+		if err != nil {
+			c.JSON(compatErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, frame(result.Text, ollamaStats(result, start, firstToken)))
+		return
+	}
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported"})
+		return
+	}
+	started := false
+	begin := func() {
+		if started {
+			return
+		}
+		started = true
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+	}
+	req.stream = &compatStream{write: func(delta string) {
+		begin()
+		writeNDJSON(c.Writer, flusher, frame(delta, nil))
+	}}
+	result, err := h.runCompatCompletion(c, req, func(string) {
+		if firstToken.IsZero() {
+			firstToken = time.Now()
+		}
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if !started {
+			c.JSON(compatErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		writeNDJSON(c.Writer, flusher, gin.H{"error": err.Error()})
+		return
+	}
+	begin()
+	writeNDJSON(c.Writer, flusher, frame("", ollamaStats(result, start, firstToken)))
+}
+
+func (h *Handler) OllamaChat(c *gin.Context) {
+	var req OllamaChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	model, err := h.resolveOllamaModel(req.Model)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Model == "" {
+		req.Model = model.ID
+	}
+	if len(req.Messages) == 0 {
+		c.JSON(http.StatusOK, OllamaChatResponse{
+			Model:       req.Model,
+			CreatedAt:   ollamaNow(),
+			Message:     OpenAIMessage{Role: "assistant"},
+			Done:        true,
+			OllamaStats: OllamaStats{DoneReason: "load"},
+		})
+		return
+	}
+	compatReq, err := renderCompatPrompt(req.Messages)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModelFiles(model, compatReq.Files); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	compatReq.Model = model
+	applyOllamaOptions(&compatReq, req.Options)
+	format, err := ollamaResponseFormat(req.Format)
+	if err == nil {
+		err = compatReq.enableStructuredOutput(format)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkOllamaRequest(c, model) {
+		return
+	}
+	h.runOllamaCompletion(c, compatReq, ollamaStream(req.Stream), func(text string, stats *OllamaStats) any {
+		response := OllamaChatResponse{
+			Model:     req.Model,
+			CreatedAt: ollamaNow(),
+			Message:   OpenAIMessage{Role: "assistant", Content: text},
+			Done:      stats != nil,
+		}
+		if stats != nil {
+			response.OllamaStats = *stats
+		}
+		return response
+	})
+}
+
+func (h *Handler) OllamaGenerate(c *gin.Context) {
+	var req OllamaGenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	model, err := h.resolveOllamaModel(req.Model)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Model == "" {
+		req.Model = model.ID
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		c.JSON(http.StatusOK, OllamaGenerateResponse{
+			Model:       req.Model,
+			CreatedAt:   ollamaNow(),
+			Done:        true,
+			OllamaStats: OllamaStats{DoneReason: "load"},
+		})
+		return
+	}
+	prompt := OpenAIMessage{Role: "user", Content: req.Prompt}
+	for i, image := range req.Images {
+		file, err := newRequestFile("", "", image)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("images.%d: %v", i, err)})
+			return
+		}
+		prompt.Files = append(prompt.Files, file)
+	}
+	messages := []OpenAIMessage{prompt}
+	if req.System != "" {
+		messages = append([]OpenAIMessage{{Role: "system", Content: req.System}}, messages...)
+	}
+	compatReq, err := renderCompatPrompt(messages)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkModelFiles(model, compatReq.Files); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	compatReq.Model = model
+	applyOllamaOptions(&compatReq, req.Options)
+	format, err := ollamaResponseFormat(req.Format)
+	if err == nil {
+		err = compatReq.enableStructuredOutput(format)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkOllamaRequest(c, model) {
+		return
+	}
+	h.runOllamaCompletion(c, compatReq, ollamaStream(req.Stream), func(text string, stats *OllamaStats) any {
+		response := OllamaGenerateResponse{
+			Model:     req.Model,
+			CreatedAt: ollamaNow(),
+			Response:  text,
+			Done:      stats != nil,
+		}
+		if stats != nil {
+			response.OllamaStats = *stats
+		}
+		return response
+	})
+}
+
+func (h *Handler) OllamaShow(c *gin.Context) {
+	var req OllamaShowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	model, err := h.resolveOllamaModel(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", name)})
+		return
+	}
+	capabilities := append([]string{"completion"}, model.Capabilities...)
+	c.JSON(http.StatusOK, OllamaShowResponse{
+		Modelfile:  fmt.Sprintf("FROM %s\n", model.ID),
+		Parameters: "",
+		Template:   "{{ .Prompt }}",
+		Details:    ollamaModelDetails(),
+		ModelInfo: map[string]any{
+			"general.architecture": "claude",
+			"general.basename":     model.DisplayName,
+			"general.upstream_id":  model.UpstreamID,
+		},
+		Capabilities: capabilities,
+		ModifiedAt:   ollamaNow(),
+	})
+}
+
+func (h *Handler) OllamaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"version": ollamaVersion})
+}
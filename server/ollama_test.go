@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+func TestOllamaChat(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("Hallo"))
+	var resp OllamaChatResponse
+	env.doJSON(http.MethodPost, "/api/chat", "device-ollama", map[string]any{
+		"stream":   false,
+		"messages": []map[string]any{{"role": "user", "content": "Hello"}},
+	}, http.StatusOK, &resp)
+	if !resp.Done || resp.Message.Content != "Hallo" {
+		t.Fatalf("got %+v, want a completed Hallo message", resp)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	quotaSubjectDevice = "device"
+	quotaSubjectAPIKey = "api_key"
+)
+
+type QuotaSubject struct {
+	Kind string
+	ID   int
+	Tier *QuotaTierConfig
+}
+
+type QuotaStatus struct {
+	Subject         string           `json:"subject"`
+	Tier            string           `json:"tier"`
+	Limits          *QuotaTierConfig `json:"limits"`
+	Usage           *QuotaUsage      `json:"usage"`
+	TokensEstimated bool             `json:"tokens_estimated"`
+	Exceeded        bool             `json:"exceeded"`
+	Reason          string           `json:"reason,omitempty"`
+	RetryAfter      int              `json:"retry_after,omitempty"`
+}
+
+type QuotaExceededError struct {
+	Status *QuotaStatus
+}
+
+func (e *QuotaExceededError) Error() string {
+	return e.Status.Reason
+}
+
+type SetQuotaTierRequest struct {
+	Tier *string `json:"tier"`
+}
+
+func (c *Config) findQuotaTier(name string) *QuotaTierConfig {
+	for i := range c.QuotaTiers {
+		if strings.EqualFold(c.QuotaTiers[i].Name, name) {
+			return &c.QuotaTiers[i]
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateQuotaTiers() error {
+	seen := make(map[string]bool)
+	for i, tier := range c.QuotaTiers {
+		if tier.Name == "" {
+			return fmt.Errorf("quota_tiers[%d] 缺少 name", i)
+		}
+		key := strings.ToLower(tier.Name)
+		if seen[key] {
+			return fmt.Errorf("配额档位名称重复: %s", tier.Name)
+		}
+		seen[key] = true
+		if tier.RPM < 0 || tier.RPD < 0 || tier.TokensPerDay < 0 || tier.FiveHourShare < 0 || tier.FiveHourShare > 100 {
+			return fmt.Errorf("配额档位 %s 的限制无效，five_hour_share 需在 0-100 之间且其余值不能为负", tier.Name)
+		}
+	}
+	if c.DefaultQuotaTier != "" && c.findQuotaTier(c.DefaultQuotaTier) == nil {
+		return fmt.Errorf("default_quota_tier %s 不在 quota_tiers 列表中", c.DefaultQuotaTier)
+	}
+	return nil
+}
+
+func (c *Config) assignedQuotaTier(assigned *string) *QuotaTierConfig {
+	name := c.DefaultQuotaTier
+	if assigned != nil && *assigned != "" {
+		name = *assigned
+	}
+	if name == "" {
+		return nil
+	}
+	return c.findQuotaTier(name)
+}
+
+func (c *Config) quotaTierParam(name string) (*string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil
+	}
+	tier := c.findQuotaTier(name)
+	if tier == nil {
+		return nil, fmt.Errorf("unknown quota tier %q", name)
+	}
+	return &tier.Name, nil
+}
+
+func resolveQuota(cfg *Config, access *APIAccess, device *CldDevice) *QuotaSubject {
+	if access.Admin != nil {
+		return nil
+	}
+	if access.Key != nil && access.Key.QuotaTier != nil {
+		return &QuotaSubject{Kind: quotaSubjectAPIKey, ID: access.Key.ID, Tier: cfg.assignedQuotaTier(access.Key.QuotaTier)}
+	}
+	if device != nil {
+		return deviceQuotaSubject(cfg, device)
+	}
+	if access.Key != nil {
+		return &QuotaSubject{Kind: quotaSubjectAPIKey, ID: access.Key.ID, Tier: cfg.assignedQuotaTier(nil)}
+	}
+	return nil
+}
+
+func deviceQuotaSubject(cfg *Config, device *CldDevice) *QuotaSubject {
+	subject := &QuotaSubject{Kind: quotaSubjectDevice, ID: device.ID}
+	if !device.Admin {
+		subject.Tier = cfg.assignedQuotaTier(device.QuotaTier)
+	}
+	return subject
+}
+
+func quotaWindowRemaining(oldest *time.Time, window time.Duration, now time.Time) time.Duration {
+	if oldest == nil {
+		return window
+	}
+	return max(oldest.Add(window).Sub(now), time.Second)
+}
+
+func evaluateQuota(tier *QuotaTierConfig, usage *QuotaUsage, fiveHourResetsAt string, now time.Time) (string, time.Duration) {
+	if tier.RPM > 0 && usage.RequestsMinute >= int64(tier.RPM) {
+		return fmt.Sprintf("Quota RPM limit reached (%d/%d)", usage.RequestsMinute, tier.RPM), quotaWindowRemaining(usage.OldestMinute, time.Minute, now)
+	}
+	if tier.RPD > 0 && usage.RequestsDay >= int64(tier.RPD) {
+		return fmt.Sprintf("Quota RPD limit reached (%d/%d)", usage.RequestsDay, tier.RPD), quotaWindowRemaining(usage.OldestDay, 24*time.Hour, now)
+	}
+	if tier.TokensPerDay > 0 && usage.TokensDay >= int64(tier.TokensPerDay) {
+		return fmt.Sprintf("Quota daily token limit reached (%d/%d)", usage.TokensDay, tier.TokensPerDay), quotaWindowRemaining(usage.OldestDay, 24*time.Hour, now)
+	}
+	if tier.FiveHourShare > 0 && usage.FiveHourUtilization >= float64(tier.FiveHourShare) {
+		reason := fmt.Sprintf("Quota five-hour share reached (%.1f%%/%d%%)", usage.FiveHourUtilization, tier.FiveHourShare)
+		if resetAt, err := time.Parse(time.RFC3339, fiveHourResetsAt); err == nil && resetAt.After(now) {
+			return reason, resetAt.Sub(now)
+		}
+		return reason, quotaWindowRemaining(usage.OldestFiveHour, 5*time.Hour, now)
+	}
+	return "", 0
+}
+
+func (s *QuotaSubject) check(db *Database) (*QuotaStatus, error) {
+	var usage *QuotaUsage
+	var err error
+	if s.Kind == quotaSubjectAPIKey {
+		usage, err = db.GetAPIKeyQuotaUsage(s.ID)
+	} else {
+		usage, err = db.GetDeviceQuotaUsage(s.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := &QuotaStatus{Subject: s.Kind, Limits: s.Tier, Usage: usage, TokensEstimated: true}
+	var resetsAt string
+	if usage.TokensFiveHour > 0 {
+		total, err := db.GetFiveHourTokens()
+		if err != nil {
+			return nil, err
+		}
+		accountUsage := getUsage()
+		utilization, _ := accountUsage["five_hour_utilization"].(int)
+		resetsAt, _ = accountUsage["five_hour_resets_at"].(string)
+		if total > 0 {
+			usage.FiveHourUtilization = math.Round(float64(usage.TokensFiveHour)/float64(total)*float64(utilization)*10) / 10
+		}
+	}
+	if s.Tier == nil {
+		return status, nil
+	}
+	status.Tier = s.Tier.Name
+	reason, retryAfter := evaluateQuota(s.Tier, usage, resetsAt, time.Now())
+	if reason != "" {
+		status.Exceeded = true
+		status.Reason = reason
+		status.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	}
+	return status, nil
+}
+
+func quotaExceeded(cfg *Config, db *Database, access *APIAccess, device *CldDevice) *QuotaStatus {
+	quota := resolveQuota(cfg, access, device)
+	if quota == nil || quota.Tier == nil {
+		return nil
+	}
+	status, err := quota.check(db)
+	if err != nil {
+		log.Printf("Failed to check quota: %v", err)
+		return nil
+	}
+	if !status.Exceeded {
+		return nil
+	}
+	return status
+}
+
+func QuotaMiddleware(cfg *Config, db *Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if status := quotaExceeded(cfg, db, requestAPIAccess(c), requestDevice(c, db)); status != nil {
+			c.Header("Retry-After", strconv.Itoa(status.RetryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       status.Reason,
+				"tier":        status.Tier,
+				"retry_after": status.RetryAfter,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *Handler) GetDeviceQuota(c *gin.Context) {
+	fingerprint := c.Query("device_id")
+	if fingerprint == "" {
+		fingerprint = c.GetHeader("X-Device-ID")
+	}
+	if fingerprint == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id required"})
+		return
+	}
+	device, err := h.db.GetDeviceByFingerprint(fingerprint)
+	if err != nil {
+		device = &CldDevice{Fingerprint: fingerprint}
+	}
+	quota := resolveQuota(h.config, requestAPIAccess(c), device)
+	if quota == nil {
+		c.JSON(http.StatusOK, &QuotaStatus{Subject: quotaSubjectDevice, Usage: &QuotaUsage{}, TokensEstimated: true})
+		return
+	}
+	status, err := quota.check(h.db)
+	if err != nil {
+		log.Printf("Failed to check quota: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load quota"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *Handler) GetDeviceQuotaAdmin(c *gin.Context) {
+	device := h.deviceParam(c)
+	if device == nil {
+		return
+	}
+	quota := deviceQuotaSubject(h.config, device)
+	status, err := quota.check(h.db)
+	if err != nil {
+		log.Printf("Failed to check quota: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load quota"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":  device.ID,
+		"quota_tier": device.QuotaTier,
+		"quota":      status,
+	})
+}
+
+func (h *Handler) SetDeviceQuotaTier(c *gin.Context) {
+	device := h.deviceParam(c)
+	if device == nil {
+		return
+	}
+	var req SetQuotaTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Tier == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tier is required"})
+		return
+	}
+	tier, err := h.config.quotaTierParam(*req.Tier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.SetDeviceQuotaTier(device.ID, tier); err != nil {
+		log.Printf("Failed to update device quota tier: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+	log.Printf("✓ %s 已将设备 %d (%s) 的配额档位设为 %s", requestAPIAccess(c).actor(), device.ID, device.Fingerprint, quotaTierLabel(tier))
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Device updated successfully",
+		"device_id":  device.ID,
+		"quota_tier": tier,
+	})
+}
+
+func (h *Handler) SetAPIKeyQuotaTier(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+	var req SetQuotaTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Tier == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tier is required"})
+		return
+	}
+	tier, err := h.config.quotaTierParam(*req.Tier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := h.db.SetAPIKeyQuotaTier(id, tier)
+	if err != nil {
+		log.Printf("Failed to update API key quota tier: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	log.Printf("✓ %s 已将 API 密钥 %d 的配额档位设为 %s", requestAPIAccess(c).actor(), id, quotaTierLabel(tier))
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "API key updated successfully",
+		"id":         id,
+		"quota_tier": tier,
+	})
+}
+
+func quotaTierLabel(tier *string) string {
+	if tier == nil {
+		return "默认"
+	}
+	return *tier
+}
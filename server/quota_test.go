@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+func TestQuotaExceeded(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) {
+		cfg.QuotaTiers = []QuotaTierConfig{{Name: "tight", RPM: 1}}
+		cfg.DefaultQuotaTier = "tight"
+	})
+	env.fake.QueueCompletion(fakeclaude.TextReply("First"), fakeclaude.TextReply("Other device"))
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-quota", chatRequest("One", nil), http.StatusOK, nil)
+	var compatError struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	resp := env.doJSON(http.MethodPost, "/v1/chat/completions", "device-quota", chatRequest("Two", nil), http.StatusTooManyRequests, &compatError)
+	if compatError.Error.Type != "rate_limit_error" {
+		t.Fatalf("got error type %q, want rate_limit_error", compatError.Error.Type)
+	}
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter <= 0 || retryAfter > 60 {
+		t.Fatalf("got Retry-After %q, want 1-60 seconds", resp.Header.Get("Retry-After"))
+	}
+	var dialogueError struct {
+		Tier       string `json:"tier"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	resp = env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-quota", map[string]any{"request": "Three"}, http.StatusTooManyRequests, &dialogueError)
+	if dialogueError.Tier != "tight" || resp.Header.Get("Retry-After") != strconv.Itoa(dialogueError.RetryAfter) {
+		t.Fatalf("got %+v with Retry-After %q", dialogueError, resp.Header.Get("Retry-After"))
+	}
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-quota-other", chatRequest("One", nil), http.StatusOK, nil)
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 2 {
+		t.Fatalf("got %d upstream completions, want 2", got)
+	}
+}
+
+func TestQuotaAdminDeviceExempt(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) {
+		cfg.QuotaTiers = []QuotaTierConfig{{Name: "tight", RPM: 1}}
+		cfg.DefaultQuotaTier = "tight"
+	})
+	device, err := env.db.GetOrCreateDevice("device-admin", "windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.SetDeviceAdmin(device.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	env.fake.QueueCompletion(fakeclaude.TextReply("First"), fakeclaude.TextReply("Second"))
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-admin", chatRequest("One", nil), http.StatusOK, nil)
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-admin", chatRequest("Two", nil), http.StatusOK, nil)
+	var status QuotaStatus
+	env.doJSON(http.MethodGet, "/api/device/quota?device_id=device-admin", "", nil, http.StatusOK, &status)
+	if status.Exceeded || status.Limits != nil || status.Usage == nil || status.Usage.RequestsMinute != 2 {
+		t.Fatalf("got %+v, want unlimited status with 2 requests counted", status)
+	}
+}
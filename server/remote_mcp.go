@@ -27,24 +27,20 @@ type RemoteMCPToolsCache struct {
 	lastUpdate time.Time
 }
 
-var remoteMCPToolsCache = &RemoteMCPToolsCache{
-	tools: make([]MCPToolDefinition, 0),
-}
-
-func GetRemoteMCPServers(config *Config) ([]RemoteMCPServer, error) {
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/mcp/remote_servers",
-		config.GetOrganizationID())
+func GetRemoteMCPServers(config *Config, account *Account) ([]RemoteMCPServer, error) {
+	url := config.UpstreamURL("/api/organizations/%s/mcp/remote_servers",
+		account.config.Tokens.OrganizationID)
 	client := config.CreateHTTPClient(30 * time.Second)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
-	req.Header.Set("Cookie", config.GetCookie())
+	req.Header.Set("Cookie", account.config.GetCookie())
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
-	req.Header.Set("Origin", "https://claude.ai")
-	req.Header.Set("Referer", "https://claude.ai/")
+	req.Header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
+	req.Header.Set("Origin", config.UpstreamURL(""))
+	req.Header.Set("Referer", config.UpstreamURL("/"))
 	req.Header.Set("Sec-Ch-Ua", `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`)
 	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
 	req.Header.Set("Sec-Ch-Ua-Platform", `"Windows"`)
@@ -72,15 +68,16 @@ func GetRemoteMCPServers(config *Config) ([]RemoteMCPServer, error) {
 	return servers, nil
 }
 
-func GetRemoteMCPToolsViaBootstrap(config *Config) ([]MCPToolDefinition, error) {
+func GetRemoteMCPToolsViaBootstrap(config *Config, account *Account) ([]MCPToolDefinition, error) {
+	remoteMCPToolsCache := &account.remoteMCPTools
 	if time.Since(remoteMCPToolsCache.lastUpdate) < 5*time.Minute && len(remoteMCPToolsCache.tools) > 0 {
 		if config.Debug {
 			DebugLog("✅ Using cached remote MCP tools: %d tools", len(remoteMCPToolsCache.tools))
 		}
 		return remoteMCPToolsCache.tools, nil
 	}
-	url := fmt.Sprintf("https://claude.ai/api/organizations/%s/mcp/v2/bootstrap",
-		config.GetOrganizationID())
+	url := config.UpstreamURL("/api/organizations/%s/mcp/v2/bootstrap",
+		account.config.Tokens.OrganizationID)
 	if config.Debug {
 		DebugLog("🔄 Fetching remote MCP tools from: %s", url)
 	}
@@ -89,13 +86,13 @@ func GetRemoteMCPToolsViaBootstrap(config *Config) ([]MCPToolDefinition, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
-	req.Header.Set("Cookie", config.GetCookie())
+	req.Header.Set("Cookie", account.config.GetCookie())
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	req.Header.Set("Accept-Language", acceptLanguage(globalConfig.Locale))
 	req.Header.Set("Cache-Control", "no-cache")
-	req.Header.Set("Origin", "https://claude.ai")
-	req.Header.Set("Referer", "https://claude.ai/")
+	req.Header.Set("Origin", config.UpstreamURL(""))
+	req.Header.Set("Referer", config.UpstreamURL("/"))
 	req.Header.Set("Sec-Ch-Ua", `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`)
 	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
 	req.Header.Set("Sec-Ch-Ua-Platform", `"Windows"`)
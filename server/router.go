@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,9 +33,10 @@ func getStaticPath() string {
 func SetupRouter(cfg *Config, db *Database) *gin.Engine {
 	r := gin.New()
 	r.SetTrustedProxies(nil)
+	r.Use(StripQueryAPIKeyMiddleware())
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
-	r.Use(CORSMiddleware())
+	r.Use(CORSMiddleware(cfg))
 	handler := NewHandler(cfg, db)
 	r.Use(func(c *gin.Context) {
 		path := c.Request.URL.Path
@@ -90,6 +93,7 @@ func SetupRouter(cfg *Config, db *Database) *gin.Engine {
 		}
 		c.Next()
 	})
+	r.Use(AdminPageMiddleware(db))
 	staticPath := getStaticPath()
 	r.Static("/static", staticPath)
 	r.GET("/", func(c *gin.Context) {
@@ -101,21 +105,34 @@ func SetupRouter(cfg *Config, db *Database) *gin.Engine {
 	r.GET("/.well-known/appspecific/com.chrome.devtools.json", func(c *gin.Context) {
 		c.Status(http.StatusNoContent)
 	})
-	chat := r.Group("/chat")
+	chatAccess := APIKeyMiddleware(cfg, db, scopeChat)
+	historyAccess := APIKeyMiddleware(cfg, db, scopeReadHistory)
+	adminAccess := APIKeyMiddleware(cfg, db, scopeAdmin)
+	monitorAccess := AdminMiddleware(cfg, db, scopeReadHistory)
+	chat := r.Group("/chat", chatAccess, DeviceBanMiddleware(db))
 	{
-		chat.POST("/dialogue/http", RateLimitMiddleware(cfg, db), handler.DialogueChatEnhanced)
-		chat.GET("/dialogue/event", RateLimitMiddleware(cfg, db), handler.DialogueEvent)
-		chat.GET("/dialogue/websocket", RateLimitMiddleware(cfg, db), handler.DialogueStream)
+		chat.POST("/dialogue/http", RateLimitMiddleware(cfg, db), QuotaMiddleware(cfg, db), handler.DialogueChatEnhanced)
+		chat.GET("/dialogue/event", RateLimitMiddleware(cfg, db), QuotaMiddleware(cfg, db), handler.DialogueEvent)
+		chat.GET("/dialogue/websocket", RateLimitMiddleware(cfg, db), QuotaMiddleware(cfg, db), handler.DialogueStream)
 		chat.POST("/dialogue/keepalive/:id", handler.KeepAlive)
 		chat.DELETE("/dialogue/:id", handler.DeleteDialogue)
+		chat.DELETE("/dialogue/:id/generation", handler.CancelGeneration)
+		chat.POST("/dialogue/:id/regenerate", RateLimitMiddleware(cfg, db), QuotaMiddleware(cfg, db), handler.RegenerateDialogue)
+		chat.POST("/dialogue/:id/edit", RateLimitMiddleware(cfg, db), QuotaMiddleware(cfg, db), handler.EditDialogue)
 	}
-	data := r.Group("/data")
+	v1 := r.Group("/v1", chatAccess)
+	{
+		v1.POST("/chat/completions", RateLimitMiddleware(cfg, db), handler.ChatCompletion)
+		v1.POST("/messages", RateLimitMiddleware(cfg, db), handler.AnthropicMessages)
+		v1.GET("/models", handler.ListModels)
+	}
+	data := r.Group("/data", APIKeyMiddleware(cfg, db, scopeChat, scopeReadHistory))
 	{
 		data.GET("/websocket/create", handler.PersistentWebSocket)
 	}
 	api := r.Group("/api")
 	{
-		orgGroup := api.Group("/organizations/:org_id")
+		orgGroup := api.Group("/organizations/:org_id", chatAccess)
 		{
 			mcpGroup := orgGroup.Group("/mcp")
 			{
@@ -127,67 +144,106 @@ func SetupRouter(cfg *Config, db *Database) *gin.Engine {
 				mcpGroup.GET("/status/:server_id", HandleMCPStatus)
 			}
 		}
-		wsGroup := api.Group("/ws/organizations/:org_id/mcp/servers/:server_id")
+		wsGroup := api.Group("/ws/organizations/:org_id/mcp/servers/:server_id", chatAccess)
 		{
 			wsGroup.GET("/", HandleMCPWebSocket)
 		}
+		adminGroup := api.Group("/admin")
+		{
+			adminGroup.POST("/login", handler.AdminLogin)
+			adminGroup.POST("/logout", handler.AdminLogout)
+			adminGroup.GET("/accounts", adminAccess, handler.ListAdmins)
+			adminGroup.POST("/accounts", adminAccess, handler.CreateAdmin)
+			adminGroup.DELETE("/accounts/:id", adminAccess, handler.DeleteAdmin)
+			adminGroup.GET("/devices", adminAccess, handler.ListDevices)
+			adminGroup.GET("/devices/:id/conversations", adminAccess, handler.GetDeviceConversations)
+			adminGroup.POST("/devices/:id/ban", adminAccess, handler.BanDevice)
+			adminGroup.POST("/devices/:id/unban", adminAccess, handler.UnbanDevice)
+			adminGroup.PUT("/devices/:id/admin", adminAccess, handler.SetDeviceAdmin)
+			adminGroup.GET("/devices/:id/quota", adminAccess, handler.GetDeviceQuotaAdmin)
+			adminGroup.PUT("/devices/:id/quota", adminAccess, handler.SetDeviceQuotaTier)
+			adminGroup.GET("/service", adminAccess, handler.GetServiceState)
+			adminGroup.PUT("/service", adminAccess, handler.SetServiceState)
+		}
+		keys := api.Group("/keys", adminAccess)
+		{
+			keys.GET("", handler.ListAPIKeys)
+			keys.POST("", handler.CreateAPIKey)
+			keys.DELETE("/:id", handler.RevokeAPIKey)
+			keys.PUT("/:id/quota", handler.SetAPIKeyQuotaTier)
+		}
 	}
-	api.GET("/usage", handler.GetUsage)
-	api.GET("/stats", handler.GetStats)
-	api.GET("/device/status", handler.CheckDeviceStatus)
-	api.POST("/device/notice", handler.UpdateDeviceNotice)
-	api.GET("/ui-config", handler.GetUIConfig)
-	api.POST("/error", handler.ReportError)
+	api.GET("/tags", chatAccess, handler.OllamaListModels)
+	api.GET("/tags/debug", chatAccess, handler.OllamaListModelsDebug)
+	api.GET("/tags/raw", chatAccess, handler.OllamaListModelsRaw)
+	api.GET("/version", chatAccess, handler.OllamaVersion)
+	api.POST("/show", chatAccess, handler.OllamaShow)
+	api.POST("/chat", chatAccess, RateLimitMiddleware(cfg, db), handler.OllamaChat)
+	api.POST("/generate", chatAccess, RateLimitMiddleware(cfg, db), handler.OllamaGenerate)
+	api.GET("/usage", chatAccess, handler.GetUsage)
+	api.GET("/stats", monitorAccess, handler.GetStats)
+	api.GET("/device/status", chatAccess, handler.CheckDeviceStatus)
+	api.GET("/device/quota", chatAccess, handler.GetDeviceQuota)
+	api.POST("/device/notice", chatAccess, handler.UpdateDeviceNotice)
+	api.POST("/device/locale", chatAccess, handler.UpdateDeviceLocale)
+	api.GET("/ui-config", chatAccess, handler.GetUIConfig)
+	api.GET("/dialogues/:id/branches", historyAccess, handler.GetDialogueBranches)
+	api.POST("/dialogues/:id/branch", chatAccess, handler.SwitchDialogueBranch)
+	api.POST("/dialogues/:id/sync", chatAccess, handler.SyncDialogue)
+	api.GET("/styles", chatAccess, handler.ListStyles)
+	api.POST("/styles", adminAccess, handler.CreateStyle)
+	api.PUT("/styles/:key", adminAccess, handler.UpdateStyle)
+	api.DELETE("/styles/:key", adminAccess, handler.DeleteStyle)
+	api.POST("/error", chatAccess, handler.ReportError)
 	return r
 }
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusOK)
-			return
+func (c *Config) validateAllowedOrigins() error {
+	for i, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+			return fmt.Errorf("allowed_origins[%d] 无效: %s，应为 \"*\" 或 scheme://host[:port] 形式", i, origin)
 		}
-		c.Next()
 	}
+	return nil
+}
+
+func isAdminRoute(path string) bool {
+	return strings.HasPrefix(path, "/api/admin") || strings.HasPrefix(path, "/api/keys")
 }
 
-func RateLimitMiddleware(cfg *Config, db *Database) gin.HandlerFunc {
+func (c *Config) corsOrigin(origin string, admin bool) string {
+	if origin == "" {
+		return ""
+	}
+	wildcard := false
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+		} else if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	if wildcard && !admin {
+		return "*"
+	}
+	return ""
+}
+
+func CORSMiddleware(cfg *Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if db.IsShutdown() {
-			stats := db.GetStats()
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error":  "Service temporarily unavailable",
-				"reason": stats.ShutdownReason,
-			})
-			c.Abort()
-			return
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin := cfg.corsOrigin(c.GetHeader("Origin"), isAdminRoute(c.Request.URL.Path)); origin != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		}
-		tpm, rpm, rpd, err := db.CalculateRates()
-		if err == nil {
-			if cfg.MaxTPM > 0 && int(tpm) >= cfg.MaxTPM {
-				reason := "Max TPM limit reached"
-				db.SetShutdown(reason)
-				c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
-				c.Abort()
-				return
-			}
-			if cfg.MaxRPM > 0 && int(rpm) >= cfg.MaxRPM {
-				reason := "Max RPM limit reached"
-				db.SetShutdown(reason)
-				c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
-				c.Abort()
-				return
-			}
-			if cfg.MaxRPD > 0 && int(rpd) >= cfg.MaxRPD {
-				reason := "Max RPD limit reached"
-				db.SetShutdown(reason)
-				c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason})
-				c.Abort()
-				return
-			}
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusOK)
+			return
 		}
 		c.Next()
 	}
@@ -196,6 +252,7 @@ func RateLimitMiddleware(cfg *Config, db *Database) gin.HandlerFunc {
 type Handler struct {
 	config          *Config
 	db              *Database
+	client          ClaudeClient
 	semaphore       chan struct{}
 	dialogueManager *DialogueManager
 	pendingAcks     sync.Map
@@ -205,6 +262,7 @@ func NewHandler(cfg *Config, db *Database) *Handler {
 	return &Handler{
 		config:          cfg,
 		db:              db,
+		client:          accountPool,
 		semaphore:       make(chan struct{}, cfg.ThreadNum),
 		dialogueManager: NewDialogueManager(),
 	}
@@ -246,23 +304,14 @@ func (h *Handler) ListModels(c *gin.Context) {
 	log.Printf("请求方法: %s", c.Request.Method)
 	log.Printf("请求路径: %s", c.Request.URL.Path)
 	log.Printf("查询参数: %s", c.Request.URL.RawQuery)
-	currentTime := time.Now().UTC().Format("2006-01-02T15:04:05Z")
-	models := make([]OllamaModel, 0, len(h.config.Models))
-	for _, model := range h.config.Models {
-		models = append(models, OllamaModel{
-			Name:       model.ID,
-			ModifiedAt: currentTime,
-			Size:       0,
-			Digest:     "sha256:0000000000000000000000000000000000000000000000000000000000000000",
-		})
-	}
-	response := OllamaTagsResponse{
-		Models: models,
+	response := OpenAIModelList{
+		Object: "list",
+		Data:   h.config.Models,
 	}
 	jsonData, _ := json.Marshal(response)
 	log.Printf("响应 JSON: %s", string(jsonData))
-	log.Printf("响应模型数量: %d", len(models))
-	log.Printf("响应类型: Ollama 格式 (OllamaTagsResponse)")
+	log.Printf("响应模型数量: %d", len(response.Data))
+	log.Printf("响应类型: OpenAI 格式 (OpenAIModelList)")
 	log.Printf("===================")
 	c.JSON(http.StatusOK, response)
 }
@@ -273,16 +322,7 @@ func (h *Handler) OllamaListModels(c *gin.Context) {
 	log.Printf("Accept: %s", c.GetHeader("Accept"))
 	log.Printf("请求方法: %s", c.Request.Method)
 	log.Printf("请求路径: %s", c.Request.URL.Path)
-	currentTime := time.Now().UTC().Format("2006-01-02T15:04:05Z")
-	models := make([]OllamaModel, 0, len(h.config.Models))
-	for _, model := range h.config.Models {
-		models = append(models, OllamaModel{
-			Name:       model.ID,
-			ModifiedAt: currentTime,
-			Size:       0,
-			Digest:     "sha256:0000000000000000000000000000000000000000000000000000000000000000",
-		})
-	}
+	models := h.ollamaModels(time.Now().UTC().Format("2006-01-02T15:04:05Z"))
 	response := OllamaTagsResponse{
 		Models: models,
 	}
@@ -294,16 +334,7 @@ func (h *Handler) OllamaListModels(c *gin.Context) {
 }
 
 func (h *Handler) OllamaListModelsDebug(c *gin.Context) {
-	currentTime := time.Now().UTC().Format("2006-01-02T15:04:05Z")
-	models := make([]OllamaModel, 0, len(h.config.Models))
-	for _, model := range h.config.Models {
-		models = append(models, OllamaModel{
-			Name:       model.ID,
-			ModifiedAt: currentTime,
-			Size:       0,
-			Digest:     "sha256:0000000000000000000000000000000000000000000000000000000000000000",
-		})
-	}
+	models := h.ollamaModels(time.Now().UTC().Format("2006-01-02T15:04:05Z"))
 	response := OllamaTagsResponse{
 		Models: models,
 	}
@@ -319,30 +350,28 @@ func (h *Handler) OllamaListModelsDebug(c *gin.Context) {
 }
 
 func (h *Handler) OllamaListModelsRaw(c *gin.Context) {
-	rawJSON := `{
-  "models": [
-    {
-      "name": "sonnet-4.5",
-      "modified_at": "2025-11-01T12:00:00Z",
-      "size": 0,
-      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000000"
-    },
-    {
-      "name": "opus-4.1",
-      "modified_at": "2025-11-01T12:00:00Z",
-      "size": 0,
-      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000000"
-    },
-    {
-      "name": "haiku-4.5",
-      "modified_at": "2025-11-01T12:00:00Z",
-      "size": 0,
-      "digest": "sha256:0000000000000000000000000000000000000000000000000000000000000000"
-    }
-  ]
-}`
+	rawJSON, _ := json.MarshalIndent(OllamaTagsResponse{
+		Models: h.ollamaModels("2025-11-01T12:00:00Z"),
+	}, "", "  ")
 	c.Header("Content-Type", "application/json; charset=utf-8")
-	c.String(http.StatusOK, rawJSON)
+	c.String(http.StatusOK, string(rawJSON))
+}
+
+func (h *Handler) ollamaModels(modifiedAt string) []OllamaModel {
+	models := make([]OllamaModel, 0, len(h.config.Models))
+	for _, model := range h.config.Models {
+		models = append(models, OllamaModel{
+			Name:         model.ID,
+			Model:        model.ID,
+			DisplayName:  model.DisplayName,
+			ModifiedAt:   modifiedAt,
+			Size:         0,
+			Digest:       "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			Details:      ollamaModelDetails(),
+			Capabilities: model.Capabilities,
+		})
+	}
+	return models
 }
 
 func (h *Handler) GetConfig(c *gin.Context) {
@@ -379,6 +408,7 @@ func (h *Handler) GetConfig(c *gin.Context) {
 		"max_rpd":             h.config.MaxRPD,
 		"request_interval_ms": h.config.RequestIntervalMS,
 		"models":              h.config.Models,
+		"default_model":       h.config.DefaultModel,
 		"endpoints":           endpoints,
 	})
 }
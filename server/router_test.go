@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		allowed []string
+		path    string
+		origin  string
+		want    string
+	}{
+		{[]string{"*"}, "/v1/chat/completions", "https://app.example.com", "*"},
+		{[]string{"*"}, "/api/admin/devices", "https://app.example.com", ""},
+		{[]string{"*"}, "/api/keys", "https://app.example.com", ""},
+		{[]string{"*", "https://admin.example.com"}, "/api/admin/devices", "https://admin.example.com", "https://admin.example.com"},
+		{[]string{"https://app.example.com"}, "/v1/chat/completions", "https://evil.example.com", ""},
+		{[]string{"https://app.example.com"}, "/v1/chat/completions", "https://app.example.com", "https://app.example.com"},
+		{[]string{"*"}, "/v1/chat/completions", "", ""},
+	}
+	for _, tc := range cases {
+		cfg := &Config{AllowedOrigins: tc.allowed}
+		r := gin.New()
+		r.Use(CORSMiddleware(cfg))
+		r.Any("/*path", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		for _, method := range []string{http.MethodOptions, http.MethodGet} {
+			req := httptest.NewRequest(method, tc.path, nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.want {
+				t.Errorf("%s %s from %q with %v: got origin %q, want %q", method, tc.path, tc.origin, tc.allowed, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestAllowedOriginsValidation(t *testing.T) {
+	for _, origin := range []string{"*", "https://app.example.com", "http://localhost:3000"} {
+		if err := (&Config{AllowedOrigins: []string{origin}}).validateAllowedOrigins(); err != nil {
+			t.Errorf("%q rejected: %v", origin, err)
+		}
+	}
+	for _, origin := range []string{"app.example.com", "https://app.example.com/chat", ""} {
+		if err := (&Config{AllowedOrigins: []string{origin}}).validateAllowedOrigins(); err == nil {
+			t.Errorf("%q accepted, want an error", origin)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	serviceOverrideOpen    = "open"
+	serviceOverrideClosed  = "closed"
+	serviceOverrideAuto    = "auto"
+	serviceCauseAdmin      = "admin"
+	serviceCauseMaxTPM     = "max_tpm"
+	serviceCauseMaxRPM     = "max_rpm"
+	serviceCauseMaxRPD     = "max_rpd"
+	serviceRecheckInterval = 10 * time.Second
+)
+
+type ServiceState struct {
+	Shutdown  bool       `json:"service_shutdown"`
+	Reason    string     `json:"shutdown_reason,omitempty"`
+	Cause     string     `json:"shutdown_cause,omitempty"`
+	Since     *time.Time `json:"shutdown_since,omitempty"`
+	RecoverAt *time.Time `json:"recover_at,omitempty"`
+	Override  string     `json:"service_override,omitempty"`
+}
+
+type ServiceLimit struct {
+	Cause     string
+	Reason    string
+	RecoverAt *time.Time
+}
+
+type ServiceStateRequest struct {
+	State  string `json:"state"`
+	Reason string `json:"reason"`
+}
+
+func checkServiceLimits(cfg *Config, db *Database) (*ServiceLimit, error) {
+	tpm, rpm, rpd, err := db.CalculateRates()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.MaxTPM > 0 && int(tpm) >= cfg.MaxTPM:
+		return &ServiceLimit{
+			Cause:     serviceCauseMaxTPM,
+			Reason:    "Max TPM limit reached",
+			RecoverAt: db.TokenWindowRecovery(time.Minute, int(tpm)-cfg.MaxTPM+1),
+		}, nil
+	case cfg.MaxRPM > 0 && int(rpm) >= cfg.MaxRPM:
+		return &ServiceLimit{
+			Cause:     serviceCauseMaxRPM,
+			Reason:    "Max RPM limit reached",
+			RecoverAt: db.RequestWindowRecovery(time.Minute, int(rpm)-cfg.MaxRPM+1),
+		}, nil
+	case cfg.MaxRPD > 0 && int(rpd) >= cfg.MaxRPD:
+		return &ServiceLimit{
+			Cause:     serviceCauseMaxRPD,
+			Reason:    "Max RPD limit reached",
+			RecoverAt: db.RequestWindowRecovery(24*time.Hour, int(rpd)-cfg.MaxRPD+1),
+		}, nil
+	}
+	return nil, nil
+}
+
+func broadcastServiceState(state ServiceState) {
+	stateJSON, _ := json.Marshal(state)
+	broker.broadcast(SSEMessage{Event: "service", Data: string(stateJSON)})
+	broadcastStats()
+}
+
+func shutdownService(db *Database, cause, reason string, recoverAt *time.Time) bool {
+	if !db.SetShutdown(cause, reason, recoverAt) {
+		return false
+	}
+	if recoverAt != nil {
+		log.Printf("⛔ 服务已暂停 [%s]: %s，预计 %s 恢复", cause, reason, recoverAt.Local().Format("2006-01-02 15:04:05"))
+	} else {
+		log.Printf("⛔ 服务已暂停 [%s]: %s", cause, reason)
+	}
+	broadcastServiceState(db.GetStats().Service)
+	return true
+}
+
+func reopenService(db *Database, by string) bool {
+	if !db.ClearShutdown() {
+		return false
+	}
+	log.Printf("✓ 服务已恢复 (%s)", by)
+	broadcastServiceState(db.GetStats().Service)
+	return true
+}
+
+func recheckService(cfg *Config, db *Database) bool {
+	state := db.GetStats().Service
+	if !state.Shutdown || state.Override == serviceOverrideClosed {
+		return false
+	}
+	limit, err := checkServiceLimits(cfg, db)
+	if err != nil {
+		log.Printf("Failed to recheck service limits: %v", err)
+		return false
+	}
+	if limit != nil {
+		return shutdownService(db, limit.Cause, limit.Reason, limit.RecoverAt)
+	}
+	return reopenService(db, "限额已恢复")
+}
+
+func MonitorServiceState(cfg *Config, database *Database) {
+	ticker := time.NewTicker(serviceRecheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		recheckService(cfg, database)
+	}
+}
+
+func serviceUnavailable(cfg *Config, db *Database) *ServiceState {
+	state := db.GetStats().Service
+	if !state.Shutdown && state.Override != serviceOverrideOpen {
+		if limit, err := checkServiceLimits(cfg, db); err == nil && limit != nil {
+			shutdownService(db, limit.Cause, limit.Reason, limit.RecoverAt)
+			state = db.GetStats().Service
+		}
+	}
+	if !state.Shutdown {
+		return nil
+	}
+	return &state
+}
+
+func (s *ServiceState) retryAfter() int {
+	if s.RecoverAt == nil {
+		return 0
+	}
+	return max(int(math.Ceil(time.Until(*s.RecoverAt).Seconds())), 1)
+}
+
+func RateLimitMiddleware(cfg *Config, db *Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := serviceUnavailable(cfg, db)
+		if state == nil {
+			c.Next()
+			return
+		}
+		if retryAfter := state.retryAfter(); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":      "Service temporarily unavailable",
+			"reason":     state.Reason,
+			"cause":      state.Cause,
+			"recover_at": state.RecoverAt,
+		})
+		c.Abort()
+	}
+}
+
+func (h *Handler) GetServiceState(c *gin.Context) {
+	c.JSON(http.StatusOK, h.db.GetStats().Service)
+}
+
+func (h *Handler) SetServiceState(c *gin.Context) {
+	var req ServiceStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	actor := requestAPIAccess(c).actor()
+	changed := false
+	switch req.State {
+	case serviceOverrideClosed:
+		reason := strings.TrimSpace(req.Reason)
+		if reason == "" {
+			reason = "Service closed by admin"
+		}
+		h.db.SetServiceOverride(serviceOverrideClosed)
+		changed = shutdownService(h.db, serviceCauseAdmin, reason, nil)
+	case serviceOverrideOpen:
+		h.db.SetServiceOverride(serviceOverrideOpen)
+		changed = reopenService(h.db, actor+" 强制开启")
+	case serviceOverrideAuto:
+		h.db.SetServiceOverride("")
+		changed = recheckService(h.config, h.db)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be open, closed or auto"})
+		return
+	}
+	log.Printf("✓ %s 已将服务状态设为 %s", actor, req.State)
+	state := h.db.GetStats().Service
+	if !changed {
+		broadcastServiceState(state)
+	}
+	c.JSON(http.StatusOK, state)
+}
@@ -1,7 +1,35 @@
+CREATE TABLE public.cld_admin (
+	id bigserial NOT NULL,
+	username varchar NOT NULL,
+	password_hash varchar NOT NULL,
+	create_time timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	last_login_time timestamptz NULL,
+	CONSTRAINT cld_admin_pkey PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_cld_admin_username ON public.cld_admin USING btree (username);
+
+CREATE TABLE public.cld_api_key (
+	id bigserial NOT NULL,
+	"name" varchar NOT NULL,
+	prefix varchar NOT NULL,
+	key_hash varchar NOT NULL,
+	scopes varchar NOT NULL,
+	device_id int8 NULL,
+	create_time timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	last_used_time timestamptz NULL,
+	revoke_time timestamptz NULL,
+	quota_tier varchar NULL,
+	CONSTRAINT cld_api_key_pkey PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_cld_api_key_key_hash ON public.cld_api_key USING btree (key_hash);
+CREATE INDEX idx_cld_api_key_device_id ON public.cld_api_key USING btree (device_id);
+
 CREATE TABLE public.cld_conversation (
 	id bigserial NOT NULL,
 	uid varchar NOT NULL,
 	device_id int8 NOT NULL,
+	account varchar NULL,
+	current_dialogue_id int8 NULL,
 	CONSTRAINT cld_conversation_pkey PRIMARY KEY (id)
 );
 CREATE INDEX idx_cld_conversation_device_id ON public.cld_conversation USING btree (device_id);
@@ -16,12 +44,13 @@ CREATE TABLE public.cld_device (
 	banned bool DEFAULT false NOT NULL,
 	ban_reason varchar NULL,
 	"admin" bool DEFAULT false NOT NULL,
-	admin_password varchar NOT NULL,
 	fingerprint varchar NOT NULL,
+	timezone varchar NULL,
+	"locale" varchar NULL,
+	quota_tier varchar NULL,
 	CONSTRAINT cld_device_check CHECK (((platform)::text = ANY ((ARRAY['windows'::character varying, 'android'::character varying, 'linux'::character varying, 'macos'::character varying, 'ios'::character varying])::text[]))),
 	CONSTRAINT cld_device_pkey PRIMARY KEY (id)
 );
-CREATE UNIQUE INDEX idx_cld_device_admin_password ON public.cld_device USING btree (admin_password);
 CREATE UNIQUE INDEX idx_cld_device_fingerprint ON public.cld_device USING btree (fingerprint);
 
 CREATE TABLE public.cld_dialogue (
@@ -36,18 +65,62 @@ CREATE TABLE public.cld_dialogue (
 	request_time timetz NULL,
 	status varchar DEFAULT 'processing'::character varying NOT NULL,
 	duration int8 NULL,
-	CONSTRAINT cld_dialogue_check CHECK (((status)::text = ANY ((ARRAY['waiting'::character varying, 'processing'::character varying, 'replying'::character varying, 'done'::character varying, 'send_failed'::character varying, 'reply_failed'::character varying])::text[]))),
+	thinking text NULL,
+	stop_reason varchar NULL,
+	finish_reason varchar NULL,
+	content_blocks jsonb NULL,
+	parent_id int8 NULL,
+	parent_message_uuid varchar NULL,
+	user_message_uuid varchar NULL,
+	assistant_message_uuid varchar NULL,
+	attachments jsonb NULL,
+	input_tokens int8 DEFAULT 0 NOT NULL,
+	output_tokens int8 DEFAULT 0 NOT NULL,
+	api_key_id int8 NULL,
+	CONSTRAINT cld_dialogue_check CHECK (((status)::text = ANY ((ARRAY['waiting'::character varying, 'processing'::character varying, 'replying'::character varying, 'done'::character varying, 'send_failed'::character varying, 'reply_failed'::character varying, 'cancelled'::character varying])::text[]))),
 	CONSTRAINT cld_dialogue_pkey PRIMARY KEY (id)
 );
 CREATE INDEX idx_cld_dialogue_conversation_id ON public.cld_dialogue USING btree (conversation_id);
+CREATE INDEX idx_cld_dialogue_api_key_id ON public.cld_dialogue USING btree (api_key_id);
+CREATE INDEX idx_cld_dialogue_assistant_message_uuid ON public.cld_dialogue USING btree (assistant_message_uuid);
 CREATE INDEX idx_cld_dialogue_create_time ON public.cld_dialogue USING btree (create_time DESC);
+CREATE INDEX idx_cld_dialogue_parent_id ON public.cld_dialogue USING btree (parent_id);
 CREATE INDEX idx_cld_dialogue_status ON public.cld_dialogue USING btree (status);
 CREATE UNIQUE INDEX idx_cld_dialogue_uid ON public.cld_dialogue USING btree (uid);
 
+CREATE TABLE public.cld_compat_cache (
+	id bigserial NOT NULL,
+	fingerprint varchar NOT NULL,
+	device_id int8 NOT NULL,
+	conversation_id int8 NOT NULL,
+	message_uuid varchar NOT NULL,
+	system_prompt_hash varchar NULL,
+	create_time timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	expire_time timestamptz NOT NULL,
+	CONSTRAINT cld_compat_cache_pkey PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_cld_compat_cache_fingerprint ON public.cld_compat_cache USING btree (fingerprint);
+CREATE INDEX idx_cld_compat_cache_device_id ON public.cld_compat_cache USING btree (device_id);
+CREATE INDEX idx_cld_compat_cache_expire_time ON public.cld_compat_cache USING btree (expire_time);
+
 CREATE TABLE public.cld_error (
 	id int4 GENERATED BY DEFAULT AS IDENTITY NOT NULL,
 	dialogue_id int4 NOT NULL,
 	"error" text NOT NULL,
 	create_time timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
 	CONSTRAINT cld_error_pk PRIMARY KEY (id)
-);
\ No newline at end of file
+);
+
+CREATE TABLE public.cld_style (
+	id bigserial NOT NULL,
+	uid varchar NOT NULL,
+	"key" varchar NOT NULL,
+	"name" varchar NOT NULL,
+	summary varchar NULL,
+	prompt text NOT NULL,
+	create_time timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	update_time timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	CONSTRAINT cld_style_pkey PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_cld_style_key ON public.cld_style USING btree (key);
+CREATE UNIQUE INDEX idx_cld_style_uid ON public.cld_style USING btree (uid);
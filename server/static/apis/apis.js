@@ -11,25 +11,26 @@ const apiData = {
                 request: {
                     "conversation_id": "optional-conversation-id",
                     "request": "Hello!",
-                    "model": "claude-sonnet-4-20250514",
+                    "model": "sonnet-4.5",
                     "style": "normal"
                 },
                 response: {
                     "conversation_id": "conv-abc123",
                     "response": "Hello! How can I help you?"
-                }
+                },
+                notes: '绑定设备的 API 密钥只能继续该设备自己的对话，conversation_id 属于其他设备时返回 404（SSE、WebSocket 及持久 WebSocket 返回 Conversation not found 错误，保活与删除接口同样返回 404）'
             },
             {
                 method: 'GET',
                 path: '/chat/dialogue/event',
                 description: 'SSE流式对话(查询参数传递)',
-                fullPath: 'http://localhost:5000/chat/dialogue/event?request=Hello&conversation_id=optional&model=claude-sonnet-4-20250514',
+                fullPath: 'http://localhost:5000/chat/dialogue/event?request=Hello&conversation_id=optional&model=sonnet-4.5',
                 request: null,
                 response: {
                     "event": "conversation_id",
                     "data": {"conversation_id": "conv-abc123"}
                 },
-                notes: '返回多个SSE事件：conversation_id, content, done, error'
+                notes: '返回多个SSE事件：conversation_id, content, done, cancelled, error'
             },
             {
                 method: 'GET',
@@ -39,14 +40,14 @@ const apiData = {
                 request: {
                     "request": "Hello!",
                     "conversation_id": "optional-conversation-id",
-                    "model": "claude-sonnet-4-20250514",
+                    "model": "sonnet-4.5",
                     "style": "normal"
                 },
                 response: {
                     "type": "conversation_id",
                     "data": {"conversation_id": "conv-abc123"}
                 },
-                notes: '返回多个WebSocket消息：conversation_id, content, done, error'
+                notes: '返回多个WebSocket消息：conversation_id, content, done, cancelled, error；发送 {"type": "cancel"} 可停止生成'
             },
             {
                 method: 'POST',
@@ -67,6 +68,128 @@ const apiData = {
                 response: {
                     "message": "Dialogue deleted successfully"
                 }
+            },
+            {
+                method: 'DELETE',
+                path: '/chat/dialogue/:id/generation',
+                description: '停止正在生成的回复(会话ID或对话记录ID)',
+                fullPath: 'http://localhost:5000/chat/dialogue/{conversation_id}/generation',
+                request: null,
+                response: {
+                    "conversation_id": "conv-abc123",
+                    "dialogue_id": 42,
+                    "message": "Generation cancelled"
+                },
+                notes: '需通过 X-Device-ID 请求头或 device_id 参数标明设备，只能停止该设备自己的生成（绑定设备的 API 密钥按绑定设备校验，管理员不受限）；已生成的部分内容以 cancelled 状态保存，原请求收到 cancelled 事件'
+            },
+            {
+                method: 'POST',
+                path: '/chat/dialogue/:id/regenerate',
+                description: '重新生成指定对话记录的回复(创建新分支)',
+                fullPath: 'http://localhost:5000/chat/dialogue/{dialogue_id}/regenerate',
+                request: {
+                    "model": "sonnet-4.5",
+                    "style": "normal"
+                },
+                response: {
+                    "conversation_id": "conv-abc123",
+                    "dialogue_id": 43,
+                    "parent_id": 41,
+                    "response": "Hello again! How can I help you?"
+                },
+                notes: '请求体可选；使用原用户消息，以原 parent_message_uuid 发送到上游'
+            },
+            {
+                method: 'POST',
+                path: '/chat/dialogue/:id/edit',
+                description: '编辑指定对话记录的用户消息并重新生成(创建新分支)',
+                fullPath: 'http://localhost:5000/chat/dialogue/{dialogue_id}/edit',
+                request: {
+                    "request": "Hi there!",
+                    "model": "sonnet-4.5"
+                },
+                response: {
+                    "conversation_id": "conv-abc123",
+                    "dialogue_id": 44,
+                    "parent_id": 41,
+                    "response": "Hi! What can I do for you?"
+                },
+                notes: '其他对话接口也可传 replace_dialogue_id 达到同样效果'
+            },
+            {
+                method: 'GET',
+                path: '/api/dialogues/:id/branches',
+                description: '获取会话的分支树',
+                fullPath: 'http://localhost:5000/api/dialogues/{conversation_id}/branches',
+                request: null,
+                response: {
+                    "conversation_id": 7,
+                    "conversation_uid": "conv-abc123",
+                    "current_dialogue_id": 44,
+                    "branches": [
+                        {"leaf_id": 42, "dialogue_ids": [41, 42], "preview": "Hello!", "status": "done", "active": false},
+                        {"leaf_id": 44, "dialogue_ids": [41, 44], "preview": "Hi there!", "status": "done", "active": true}
+                    ],
+                    "dialogues": []
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/dialogues/:id/branch',
+                description: '切换会话当前分支，后续消息从该对话记录继续',
+                fullPath: 'http://localhost:5000/api/dialogues/{conversation_id}/branch',
+                request: {
+                    "dialogue_id": 42
+                },
+                response: {
+                    "message": "Branch switched successfully",
+                    "conversation_id": 7,
+                    "current_dialogue_id": 42,
+                    "parent_message_uuid": "msg-uuid"
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/dialogues/:id/sync',
+                description: '从上游同步完整会话历史(包括其他客户端或网页端的消息)',
+                fullPath: 'http://localhost:5000/api/dialogues/{conversation_id}/sync',
+                request: null,
+                response: {
+                    "conversation_id": 7,
+                    "messages": 6,
+                    "created": 1,
+                    "updated": 0,
+                    "current_dialogue_id": 45
+                },
+                notes: '导入用户消息、回复、附件、时间和消息UUID；持久WebSocket的 api_request 同样支持该端点'
+            },
+            {
+                method: 'GET',
+                path: '/api/device/quota',
+                description: '查询设备（或 API 密钥）的配额档位与当前用量',
+                fullPath: 'http://localhost:5000/api/device/quota?device_id=your-device-id',
+                request: null,
+                response: {
+                    "subject": "device",
+                    "tier": "standard",
+                    "limits": {
+                        "name": "standard",
+                        "rpm": 10,
+                        "rpd": 500,
+                        "tokens_per_day": 2000000,
+                        "five_hour_share": 30
+                    },
+                    "usage": {
+                        "requests_minute": 2,
+                        "requests_day": 120,
+                        "tokens_day": 350000,
+                        "tokens_five_hour": 90000,
+                        "five_hour_utilization": 12.5
+                    },
+                    "tokens_estimated": true,
+                    "exceeded": false
+                },
+                notes: 'token 数由服务端使用 cl100k_base 分词器计算（图片与 PDF 按尺寸或页数折算），与 Claude 上游的实际计数存在差异，tokens_estimated 恒为 true；配额档位在 quota_tiers 中配置；携带已分配档位的 API 密钥时按密钥计量（subject 为 api_key），否则按设备计量；limits 为 null 表示不限制；five_hour_utilization 为按近五小时 token 占比折算的五小时用量百分比。HTTP、SSE 与单次 WebSocket 对话超出配额时返回 429 并带 Retry-After 头；OpenAI、Anthropic 与 Ollama 兼容接口的设备依次取 X-Device-ID、user（或 metadata.user_id），都未提供时按 API 密钥计量，超出时同样返回 429 与 Retry-After；持久 WebSocket 返回 quota_exceeded 消息（内容与本接口相同，含 reason 与 retry_after 秒数）'
             }
         ]
     },
@@ -99,14 +222,15 @@ const apiData = {
                     "data": {
                         "request": "Hello!",
                         "conversation_id": "optional-conversation-id",
-                        "model": "claude-sonnet-4-20250514",
+                        "model": "sonnet-4.5",
                         "style": "normal"
                     }
                 },
                 response: {
                     "type": "content",
                     "data": {"delta": "Hello!", "text": "Hello!"}
-                }
+                },
+                notes: '服务暂停时（含 regenerate、edit）返回 service_unavailable 消息，data 含 reason、cause、recover_at 与 retry_after 秒数（未知恢复时间时为 0）'
             },
             {
                 method: 'WS',
@@ -127,9 +251,12 @@ const apiData = {
                         "request_id": 1,
                         "processing": 0,
                         "completed": 100,
-                        "failed": 5
+                        "failed": 5,
+                        "tpm": 1200,
+                        "tokens_estimated": true
                     }
-                }
+                },
+                notes: '需要管理员登录（浏览器会话 Cookie）或携带 read-history 权限的 API 密钥；绑定设备的密钥只能读取该设备的对话与记录；/api/dialogues/:id/sync 与删除 /api/dialogues/:id 还需要 chat 权限'
             },
             {
                 method: 'WS',
@@ -164,6 +291,570 @@ const apiData = {
                     "type": "pong",
                     "data": {"timestamp": "2025-11-03T12:00:00Z"}
                 }
+            },
+            {
+                method: 'WS',
+                path: '消息类型: cancel',
+                description: '停止正在生成的回复',
+                fullPath: 'ws://localhost:5000/data/websocket/create',
+                request: {
+                    "type": "cancel",
+                    "data": {
+                        "conversation_id": "conv-abc123"
+                    }
+                },
+                response: {
+                    "type": "cancel_received",
+                    "data": {
+                        "conversation_id": "conv-abc123",
+                        "dialogue_id": 42,
+                        "status": "ok"
+                    }
+                },
+                notes: '也可传 dialogue_id；只能停止当前设备（data.device_id 或连接时的 device_id）的生成；对应的 dialogue 请求随后收到 cancelled 消息。连接意外断开不会中止生成，回复保存为 replying，未收到 ack 时标记为 reply_failed'
+            },
+            {
+                method: 'WS',
+                path: '消息类型: regenerate',
+                description: '重新生成指定对话记录的回复',
+                fullPath: 'ws://localhost:5000/data/websocket/create',
+                request: {
+                    "type": "regenerate",
+                    "data": {
+                        "dialogue_id": 42,
+                        "device_id": "device-fingerprint",
+                        "model": "sonnet-4.5"
+                    }
+                },
+                response: {
+                    "type": "content",
+                    "data": {"delta": "Hello again!", "text": "Hello again!"}
+                },
+                notes: '后续消息与 dialogue 相同，done 消息包含新的 dialogue_id 与 parent_id'
+            },
+            {
+                method: 'WS',
+                path: '消息类型: edit',
+                description: '编辑指定对话记录的用户消息并重新生成',
+                fullPath: 'ws://localhost:5000/data/websocket/create',
+                request: {
+                    "type": "edit",
+                    "data": {
+                        "dialogue_id": 42,
+                        "request": "Hi there!",
+                        "device_id": "device-fingerprint"
+                    }
+                },
+                response: {
+                    "type": "content",
+                    "data": {"delta": "Hi!", "text": "Hi!"}
+                }
+            }
+        ]
+    },
+    compat: {
+        title: '兼容接口',
+        intro: '兼容 OpenAI 等标准 SDK 的接口，每次请求使用独立的上游会话；OpenAI、Anthropic 与 Ollama 接口请求未配置的模型时统一返回 404（错误类型分别为 invalid_request_error、not_found_error 与 Ollama 的 error 字段）；model 省略或为 "default" 时使用配置的 default_model',
+        apis: [
+            {
+                method: 'POST',
+                path: '/v1/chat/completions',
+                description: 'OpenAI Chat Completions 兼容接口',
+                fullPath: 'http://localhost:5000/v1/chat/completions',
+                request: {
+                    "model": "sonnet-4.5",
+                    "messages": [
+                        {"role": "system", "content": "You are a helpful assistant."},
+                        {"role": "user", "content": "Hello!"},
+                        {"role": "assistant", "content": "Hi! How can I help?"},
+                        {"role": "user", "content": "Tell me a joke."}
+                    ],
+                    "max_tokens": 256,
+                    "stop": ["\n\n"]
+                },
+                response: {
+                    "id": "chatcmpl-...",
+                    "object": "chat.completion",
+                    "model": "sonnet-4.5",
+                    "choices": [
+                        {"index": 0, "message": {"role": "assistant", "content": "..."}, "finish_reason": "stop"}
+                    ],
+                    "usage": {"prompt_tokens": 40, "completion_tokens": 20, "total_tokens": 60}
+                },
+                notes: 'usage 中的 token 数为服务端估算值；system 消息作为系统提示词，历史轮次以对话记录形式发送；stop 与 max_tokens 在读取上游流时逐块检测（可跨分块匹配），命中后提前停止上游生成，finish_reason 分别为 stop 与 length，原因记录在 dialogue 的 finish_reason 字段；temperature 仅校验范围，上游不支持调整；content 可为内容数组，支持 text、image_url（data URL）与 file/input_file（file_data）分片，文件会上传到上游对话作为附件；历史前缀与此前返回的回复一致时复用同一上游对话，只发送最新一轮（有效期与数量由 compat_cache_ttl_minutes、compat_cache_max_entries 配置）；会话按 X-Device-ID 或 user 归属设备，都未提供时同一 API 密钥的请求归入固定设备 api-key:<密钥ID>（无密钥时为 anonymous），不再为每个请求创建新设备'
+            },
+            {
+                method: 'POST',
+                path: '/v1/chat/completions (stream)',
+                description: 'OpenAI Chat Completions 流式输出',
+                fullPath: 'http://localhost:5000/v1/chat/completions',
+                request: {
+                    "model": "sonnet-4.5",
+                    "messages": [
+                        {"role": "user", "content": "Hello!"}
+                    ],
+                    "stream": true,
+                    "stream_options": {"include_usage": true}
+                },
+                response: {
+                    "id": "chatcmpl-...",
+                    "object": "chat.completion.chunk",
+                    "model": "sonnet-4.5",
+                    "choices": [
+                        {"index": 0, "delta": {"content": "Hi"}, "finish_reason": null}
+                    ]
+                },
+                notes: '以 SSE 返回多个 data: chat.completion.chunk 分块并以 data: [DONE] 结束；首个分块携带 role，最后一个分块携带 finish_reason；stream_options.include_usage 为 true 时在 [DONE] 前追加 usage 分块'
+            },
+            {
+                method: 'POST',
+                path: '/v1/chat/completions (tools)',
+                description: 'OpenAI 工具调用（模拟）',
+                fullPath: 'http://localhost:5000/v1/chat/completions',
+                request: {
+                    "model": "sonnet-4.5",
+                    "messages": [
+                        {"role": "user", "content": "What's the weather in Paris?"}
+                    ],
+                    "tools": [
+                        {"type": "function", "function": {"name": "get_weather", "description": "Get current weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}}
+                    ],
+                    "tool_choice": "auto"
+                },
+                response: {
+                    "id": "chatcmpl-...",
+                    "object": "chat.completion",
+                    "choices": [
+                        {"index": 0, "message": {"role": "assistant", "content": "", "tool_calls": [{"id": "call_...", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}, "finish_reason": "tool_calls"}
+                    ]
+                },
+                notes: '工具定义会写入提示词，模型以 <tool_calls> 块返回调用并解析为 tool_calls；参数按 JSON Schema 校验，不合法时自动发送修正请求（次数由 compat_repair_attempts 配置），仍失败返回 502；流式请求在校验通过后才一次性返回文本与 tool_calls，不会推送随后被修正替换的内容；后续轮次以 role 为 tool 的消息回传结果；tool_choice 支持 auto、none、required 与指定函数；/v1/messages 同样支持 tools、tool_choice、tool_use 与 tool_result 块'
+            },
+            {
+                method: 'POST',
+                path: '/v1/chat/completions (response_format)',
+                description: 'OpenAI 结构化输出（JSON Schema 校验）',
+                fullPath: 'http://localhost:5000/v1/chat/completions',
+                request: {
+                    "model": "sonnet-4.5",
+                    "messages": [
+                        {"role": "user", "content": "Extract the name and age: Alice is 30 years old."}
+                    ],
+                    "response_format": {
+                        "type": "json_schema",
+                        "json_schema": {"name": "person", "schema": {"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "required": ["name", "age"]}}
+                    }
+                },
+                response: {
+                    "id": "chatcmpl-...",
+                    "object": "chat.completion",
+                    "choices": [
+                        {"index": 0, "message": {"role": "assistant", "content": "{\"name\":\"Alice\",\"age\":30}"}, "finish_reason": "stop"}
+                    ]
+                },
+                notes: 'type 支持 text、json_object 与 json_schema；schema 会写入提示词，回复中的 JSON 去除代码块后按 schema 校验（支持 type、enum、const、properties、required、additionalProperties、patternProperties、items、prefixItems、allOf、anyOf、oneOf、not、本地 $ref/$defs 以及数值、长度、pattern、数组长度与 uniqueItems 约束；if/then/else、dependentRequired 等不支持的关键字或无法解析的 $ref、pattern 会直接返回 400），不合法时在同一上游对话中发送修正请求（次数由 compat_repair_attempts 配置），仍失败返回 502 且 error.type 为 validation_error；流式请求在校验通过后一次性返回 JSON；/v1/messages 使用 output_format，/api/chat 与 /api/generate 使用 format（"json" 或 schema 对象），/chat/dialogue/http、/chat/dialogue/websocket 与持久 WebSocket 的 dialogue 消息（data.response_format）使用 response_format'
+            },
+            {
+                method: 'POST',
+                path: '/v1/messages',
+                description: 'Anthropic Messages 兼容接口',
+                fullPath: 'http://localhost:5000/v1/messages',
+                request: {
+                    "model": "claude-sonnet-4-5",
+                    "system": "You are a helpful assistant.",
+                    "messages": [
+                        {"role": "user", "content": [{"type": "text", "text": "Hello!"}]}
+                    ],
+                    "max_tokens": 1024,
+                    "stop_sequences": ["\n\nHuman:"],
+                    "stream": false,
+                    "metadata": {"user_id": "optional-device-id"}
+                },
+                response: {
+                    "id": "msg_...",
+                    "type": "message",
+                    "role": "assistant",
+                    "model": "claude-sonnet-4-5",
+                    "content": [{"type": "text", "text": "Hi! How can I help?"}],
+                    "stop_reason": "end_turn",
+                    "stop_sequence": null,
+                    "usage": {"input_tokens": 12, "output_tokens": 8}
+                },
+                notes: 'usage 中的 token 数为服务端估算值；max_tokens 必填；content 可为字符串或内容块数组，支持 text 以及 source 为 base64/text 的 image、document 块；stream 为 true 时依次返回 message_start, content_block_start, content_block_delta, content_block_stop, message_delta, message_stop 事件；未提供 X-Device-ID 时使用 metadata.user_id 作为设备标识'
+            },
+            {
+                method: 'GET',
+                path: '/v1/models',
+                description: 'OpenAI 兼容的模型列表',
+                fullPath: 'http://localhost:5000/v1/models',
+                request: null,
+                response: {
+                    "object": "list",
+                    "data": [
+                        {"id": "sonnet-4.5", "object": "model", "created": 0, "owned_by": "anthropic", "upstream_id": "claude-sonnet-4-5-20250929", "display_name": "Claude Sonnet 4.5", "aliases": ["claude-sonnet-4.5", "claude-sonnet-4-5"], "capabilities": ["thinking", "vision"], "usage_bucket": "seven_day"}
+                    ]
+                },
+                notes: '模型来自配置中的 models 列表，与 /api/tags 一致'
+            },
+            {
+                method: 'GET',
+                path: '/api/tags',
+                description: 'Ollama 兼容的模型列表',
+                fullPath: 'http://localhost:5000/api/tags',
+                request: null,
+                response: {
+                    "models": [
+                        {"name": "sonnet-4.5", "model": "sonnet-4.5", "modified_at": "2025-11-01T12:00:00Z", "size": 0, "digest": "sha256:...", "details": {"format": "api", "family": "claude"}, "capabilities": ["thinking", "vision"]}
+                    ]
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/show',
+                description: 'Ollama 兼容的模型详情',
+                fullPath: 'http://localhost:5000/api/show',
+                request: {
+                    "model": "sonnet-4.5"
+                },
+                response: {
+                    "modelfile": "FROM sonnet-4.5\n",
+                    "details": {"format": "api", "family": "claude", "families": ["claude"]},
+                    "model_info": {"general.architecture": "claude", "general.basename": "Claude Sonnet 4.5"},
+                    "capabilities": ["completion", "thinking", "vision"]
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/chat',
+                description: 'Ollama 兼容的对话接口',
+                fullPath: 'http://localhost:5000/api/chat',
+                request: {
+                    "model": "sonnet-4.5",
+                    "messages": [
+                        {"role": "user", "content": "Hello!"}
+                    ],
+                    "stream": true,
+                    "options": {"num_predict": 256, "stop": ["\n\n"]}
+                },
+                response: {
+                    "model": "sonnet-4.5",
+                    "created_at": "2025-11-01T12:00:00Z",
+                    "message": {"role": "assistant", "content": ""},
+                    "done": true,
+                    "done_reason": "stop",
+                    "total_duration": 2100000000,
+                    "prompt_eval_count": 3,
+                    "eval_count": 12
+                },
+                notes: 'stream 默认为 true，以换行分隔的 JSON 逐行返回 done 为 false 的分块，最后一行为携带统计信息的 done 为 true 对象；模型名可带 :latest 后缀；消息可携带 images（base64）作为图片附件'
+            },
+            {
+                method: 'POST',
+                path: '/api/generate',
+                description: 'Ollama 兼容的文本生成接口',
+                fullPath: 'http://localhost:5000/api/generate',
+                request: {
+                    "model": "sonnet-4.5",
+                    "system": "You are a helpful assistant.",
+                    "prompt": "Why is the sky blue?",
+                    "stream": false
+                },
+                response: {
+                    "model": "sonnet-4.5",
+                    "created_at": "2025-11-01T12:00:00Z",
+                    "response": "...",
+                    "done": true,
+                    "done_reason": "stop",
+                    "prompt_eval_count": 12,
+                    "eval_count": 80
+                },
+                notes: '流式格式与 /api/chat 相同，分块内容在 response 字段；prompt 为空时直接返回 done_reason 为 load'
+            }
+        ]
+    },
+    admin: {
+        title: '管理接口',
+        intro: '需要管理员登录会话或 admin 权限的 API 密钥：Authorization: Bearer <token>；require_api_key 默认开启，其他接口也需要对应权限（chat / read-history）的密钥，携带无效或已吊销的密钥返回 401',
+        apis: [
+            {
+                method: 'POST',
+                path: '/api/admin/login',
+                description: '管理员登录，签发带有效期的会话令牌',
+                fullPath: 'http://localhost:5000/api/admin/login',
+                request: {
+                    "username": "admin",
+                    "password": "********"
+                },
+                response: {
+                    "token": "eyJhaWQiOjEsInVzciI6ImFkbWluIiwiZXhwIjoxNzMwNTI4MDAwfQ.signature",
+                    "username": "admin",
+                    "expires_at": "2025-11-02T12:00:00Z"
+                },
+                notes: '同时写入 HttpOnly 的 admin_session Cookie，用于访问监控面板、对话记录等页面；令牌也可作为 Authorization: Bearer 使用；有效期由 admin_session_hours 配置'
+            },
+            {
+                method: 'POST',
+                path: '/api/admin/logout',
+                description: '退出登录，清除会话 Cookie',
+                fullPath: 'http://localhost:5000/api/admin/logout',
+                request: null,
+                response: {
+                    "message": "Logged out successfully"
+                }
+            },
+            {
+                method: 'GET',
+                path: '/api/admin/accounts',
+                description: '列出管理员账号',
+                fullPath: 'http://localhost:5000/api/admin/accounts',
+                request: null,
+                response: {
+                    "admins": [
+                        {"id": 1, "username": "admin", "create_time": "2025-11-01T12:00:00Z", "last_login_time": "2025-11-01T12:30:00Z"}
+                    ]
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/admin/accounts',
+                description: '创建管理员账号',
+                fullPath: 'http://localhost:5000/api/admin/accounts',
+                request: {
+                    "username": "ops",
+                    "password": "at-least-8-chars"
+                },
+                response: {
+                    "id": 2,
+                    "username": "ops",
+                    "create_time": "2025-11-01T12:00:00Z",
+                    "last_login_time": null
+                },
+                notes: '密码使用 bcrypt 哈希保存；第一个管理员请使用 ./claude-adapter -a <用户名> 创建'
+            },
+            {
+                method: 'DELETE',
+                path: '/api/admin/accounts/:id',
+                description: '删除管理员账号（不能删除当前登录的账号）',
+                fullPath: 'http://localhost:5000/api/admin/accounts/{id}',
+                request: null,
+                response: {
+                    "message": "Admin deleted successfully"
+                }
+            },
+            {
+                method: 'GET',
+                path: '/api/admin/devices',
+                description: '列出与搜索设备，包含最近活动时间与对话数量',
+                fullPath: 'http://localhost:5000/api/admin/devices?q=android&banned=false&limit=50&offset=0',
+                request: null,
+                response: {
+                    "devices": [
+                        {
+                            "id": 3,
+                            "platform": "android",
+                            "fingerprint": "device-fingerprint",
+                            "banned": false,
+                            "ban_reason": null,
+                            "admin": false,
+                            "last_activity": "2025-11-01T12:30:00Z",
+                            "conversation_count": 4,
+                            "dialogue_count": 27
+                        }
+                    ],
+                    "total": 1,
+                    "limit": 50,
+                    "offset": 0
+                },
+                notes: 'q 按设备ID、平台、备注与封禁原因模糊搜索；banned 可筛选封禁状态；按最近活动时间倒序'
+            },
+            {
+                method: 'GET',
+                path: '/api/admin/devices/:id/conversations',
+                description: '查看单个设备的会话列表',
+                fullPath: 'http://localhost:5000/api/admin/devices/{id}/conversations',
+                request: null,
+                response: {
+                    "device": {"id": 3, "platform": "android", "fingerprint": "device-fingerprint"},
+                    "conversations": [
+                        {"id": 12, "uid": "conv-abc123", "device_id": 3, "current_dialogue_id": 45}
+                    ]
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/admin/devices/:id/ban',
+                description: '封禁设备',
+                fullPath: 'http://localhost:5000/api/admin/devices/{id}/ban',
+                request: {
+                    "reason": "滥用接口"
+                },
+                response: {
+                    "message": "Device banned successfully",
+                    "device_id": 3,
+                    "reason": "滥用接口",
+                    "disconnected": 1,
+                    "cancelled": 1
+                },
+                notes: 'reason 必填；该设备在线的持久 WebSocket 会收到 banned 消息后立即断开，正在进行的生成会被取消（cancelled 为取消数量）；封禁后 /chat 下的 HTTP、SSE、WebSocket 对话以及 OpenAI、Anthropic、Ollama 兼容接口均返回 403（兼容接口错误类型为 permission_error，其他接口返回 {"error": "Device is banned", "reason": "..."}），绑定该设备的 API 密钥同样被拒绝'
+            },
+            {
+                method: 'POST',
+                path: '/api/admin/devices/:id/unban',
+                description: '解封设备',
+                fullPath: 'http://localhost:5000/api/admin/devices/{id}/unban',
+                request: null,
+                response: {
+                    "message": "Device unbanned successfully",
+                    "device_id": 3
+                }
+            },
+            {
+                method: 'PUT',
+                path: '/api/admin/devices/:id/admin',
+                description: '设置设备的管理员标记',
+                fullPath: 'http://localhost:5000/api/admin/devices/{id}/admin',
+                request: {
+                    "admin": true
+                },
+                response: {
+                    "message": "Device updated successfully",
+                    "device_id": 3,
+                    "admin": true
+                },
+                notes: '标记为管理员的设备不受配额档位限制（用量仍会统计）；使用设置了 quota_tier 的 API 密钥时仍按密钥档位计量；该标记不授予任何管理接口权限'
+            },
+            {
+                method: 'GET',
+                path: '/api/admin/devices/:id/quota',
+                description: '查看设备的配额档位与当前用量',
+                fullPath: 'http://localhost:5000/api/admin/devices/{id}/quota',
+                request: null,
+                response: {
+                    "device_id": 3,
+                    "quota_tier": "heavy",
+                    "quota": {
+                        "subject": "device",
+                        "tier": "heavy",
+                        "exceeded": false
+                    }
+                }
+            },
+            {
+                method: 'PUT',
+                path: '/api/admin/devices/:id/quota',
+                description: '为设备分配配额档位',
+                fullPath: 'http://localhost:5000/api/admin/devices/{id}/quota',
+                request: {
+                    "tier": "heavy"
+                },
+                response: {
+                    "message": "Device updated successfully",
+                    "device_id": 3,
+                    "quota_tier": "heavy"
+                },
+                notes: 'tier 必须是 quota_tiers 中的名称；传空字符串恢复为 default_quota_tier'
+            },
+            {
+                method: 'GET',
+                path: '/api/admin/service',
+                description: '查看服务暂停状态',
+                fullPath: 'http://localhost:5000/api/admin/service',
+                request: null,
+                response: {
+                    "service_shutdown": true,
+                    "shutdown_reason": "Max RPM limit reached",
+                    "shutdown_cause": "max_rpm",
+                    "shutdown_since": "2025-11-01T12:00:00Z",
+                    "recover_at": "2025-11-01T12:00:42Z"
+                },
+                notes: 'max_tpm 与 stats 中的 tpm 均基于服务端估算的 token 数（tokens_estimated 为 true），与上游实际计费可能有偏差；达到 max_tpm / max_rpm / max_rpd 时服务暂停，对话接口返回 503 并带 Retry-After 头；服务端每 10 秒重新检查，限额允许后自动恢复；状态变化会通过 SSE 的 service 与 stats 事件推送并写入日志'
+            },
+            {
+                method: 'PUT',
+                path: '/api/admin/service',
+                description: '强制开启或关闭服务',
+                fullPath: 'http://localhost:5000/api/admin/service',
+                request: {
+                    "state": "closed",
+                    "reason": "维护中"
+                },
+                response: {
+                    "service_shutdown": true,
+                    "shutdown_reason": "维护中",
+                    "shutdown_cause": "admin",
+                    "shutdown_since": "2025-11-01T12:00:00Z",
+                    "service_override": "closed"
+                },
+                notes: 'state 可选 closed（暂停直到管理员再次修改）、open（立即恢复并忽略 max_tpm / max_rpm / max_rpd 限制）、auto（取消强制状态，按限额自动暂停与恢复）'
+            },
+            {
+                method: 'GET',
+                path: '/api/keys',
+                description: '列出所有 API 密钥（不含密钥明文）',
+                fullPath: 'http://localhost:5000/api/keys',
+                request: null,
+                response: {
+                    "keys": [
+                        {
+                            "id": 1,
+                            "name": "desktop",
+                            "prefix": "sk-cs-1a2b3c",
+                            "scopes": "chat,read-history",
+                            "device_id": null,
+                            "create_time": "2025-11-01T12:00:00Z",
+                            "last_used_time": "2025-11-01T12:30:00Z",
+                            "revoke_time": null
+                        }
+                    ]
+                }
+            },
+            {
+                method: 'POST',
+                path: '/api/keys',
+                description: '创建 API 密钥',
+                fullPath: 'http://localhost:5000/api/keys',
+                request: {
+                    "name": "desktop",
+                    "scopes": ["chat", "read-history"],
+                    "device_id": "optional-device-id",
+                    "quota_tier": "standard"
+                },
+                response: {
+                    "key": "sk-cs-1a2b3c...",
+                    "api_key": {
+                        "id": 1,
+                        "name": "desktop",
+                        "prefix": "sk-cs-1a2b3c",
+                        "scopes": "chat,read-history",
+                        "device_id": 3
+                    }
+                },
+                notes: '密钥明文只在创建时返回一次；scopes 可选 chat、read-history、admin（admin 包含全部权限）；指定 device_id 后密钥只能以该设备身份使用；quota_tier 可选，设置后该密钥按自身用量单独计算配额'
+            },
+            {
+                method: 'DELETE',
+                path: '/api/keys/:id',
+                description: '吊销 API 密钥',
+                fullPath: 'http://localhost:5000/api/keys/{id}',
+                request: null,
+                response: {
+                    "message": "API key revoked successfully"
+                }
+            },
+            {
+                method: 'PUT',
+                path: '/api/keys/:id/quota',
+                description: '为 API 密钥分配配额档位',
+                fullPath: 'http://localhost:5000/api/keys/{id}/quota',
+                request: {
+                    "tier": "standard"
+                },
+                response: {
+                    "message": "API key updated successfully",
+                    "id": 1,
+                    "quota_tier": "standard"
+                },
+                notes: '传空字符串取消密钥档位，之后按所属设备的配额计量'
             }
         ]
     }
@@ -291,7 +982,9 @@ function closeApiModal() {
 function getCategoryName(category) {
     const names = {
         'dialogue': '对话接口',
-        'websocket': '持久化WebSocket'
+        'websocket': '持久化WebSocket',
+        'compat': '兼容接口',
+        'admin': '管理接口'
     };
     return names[category] || category;
 }
@@ -80,6 +80,50 @@ function updateUsageDisplay(usage) {
     if (sevenDayOpusReset && usage.seven_day_opus_resets_at) {
         sevenDayOpusReset.textContent = '重置于 ' + formatResetTime(usage.seven_day_opus_resets_at);
     }
+
+    updateAccountsDisplay(usage.accounts || []);
+}
+
+function updateAccountsDisplay(accounts) {
+    const accountsDisplay = document.getElementById('accountsDisplay');
+    if (!accountsDisplay) return;
+
+    if (accounts.length === 0) {
+        accountsDisplay.innerHTML = '<tr><td colspan="6">暂无账号</td></tr>';
+        return;
+    }
+
+    const stateLabels = {
+        active: ['success', '可用'],
+        cooldown: ['processing', '冷却中'],
+        blocked: ['failed', '已达限额']
+    };
+
+    accountsDisplay.innerHTML = accounts.map(account => {
+        const [badgeClass, label] = stateLabels[account.state] || ['failed', account.state];
+        let note = account.reason || '';
+        if (account.disabled_until) {
+            note += (note ? ' · ' : '') + '恢复于 ' + formatResetTime(account.disabled_until);
+        }
+        if (account.last_error) {
+            note += (note ? ' · ' : '') + account.last_error;
+        }
+        return '<tr>' +
+            '<td>' + escapeHtml(account.name) + '</td>' +
+            '<td><span class="status-badge ' + badgeClass + '">' + label + '</span></td>' +
+            '<td>' + (account.five_hour_utilization || 0) + '%</td>' +
+            '<td>' + (account.seven_day_utilization || 0) + '%</td>' +
+            '<td>' + (account.conversations || 0) + '</td>' +
+            '<td>' + escapeHtml(note || '--') + '</td>' +
+            '</tr>';
+    }).join('');
+}
+
+function escapeHtml(text) {
+    if (!text) return '';
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
 }
 
 function formatResetTime(timeStr) {
@@ -101,7 +145,7 @@ async function loadConfig() {
         const configDisplay = document.getElementById('configDisplay');
         if (!configDisplay) return;
 
-        configDisplay.innerHTML = '<tr><td>最大TPM</td><td>' + (config.max_tpm || '无限制') + '</td></tr>' +
+        configDisplay.innerHTML = '<tr><td>最大TPM（估算）</td><td>' + (config.max_tpm || '无限制') + '</td></tr>' +
             '<tr><td>最大RPM</td><td>' + (config.max_rpm || '无限制') + '</td></tr>' +
             '<tr><td>最大RPD</td><td>' + (config.max_rpd || '无限制') + '</td></tr>' +
             '<tr><td>请求间隔</td><td>' + (config.request_interval_ms || 0) + ' ms</td></tr>';
@@ -316,6 +316,7 @@ function translateStatus(status) {
         'done': '已完成',
         'processing': '处理中',
         'failed': '失败',
+        'cancelled': '已取消',
         'overloaded': '过载'
     };
     return statusMap[status] || status;
@@ -0,0 +1,38 @@
+function loginRedirectTarget() {
+    const redirect = new URLSearchParams(window.location.search).get('redirect');
+    if (redirect && redirect.startsWith('/static/') && !redirect.startsWith('/static/login/')) {
+        return redirect;
+    }
+    return '/static/dashboard/dashboard.html';
+}
+
+async function submitLogin(event) {
+    event.preventDefault();
+    const button = document.getElementById('loginButton');
+    const error = document.getElementById('loginError');
+    button.disabled = true;
+    error.textContent = '';
+
+    try {
+        const response = await fetch('/api/admin/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                username: document.getElementById('username').value,
+                password: document.getElementById('password').value
+            })
+        });
+        if (!response.ok) {
+            error.textContent = response.status === 401 ? '用户名或密码错误' : '登录失败，请稍后重试';
+            return;
+        }
+        window.location.href = loginRedirectTarget();
+    } catch (e) {
+        error.textContent = '无法连接服务器';
+    } finally {
+        button.disabled = false;
+    }
+}
+
+document.documentElement.setAttribute('data-theme', localStorage.getItem('theme') || 'dark');
+document.getElementById('loginForm').addEventListener('submit', submitLogin);
@@ -340,6 +340,14 @@ function toggleTheme() {
     localStorage.setItem('theme', theme);
 }
 
+async function adminLogout() {
+    try {
+        await fetch('/api/admin/logout', { method: 'POST' });
+    } finally {
+        window.location.href = '/static/login/login.html';
+    }
+}
+
 function initTheme() {
     const savedTheme = localStorage.getItem('theme') || 'dark';
     document.documentElement.setAttribute('data-theme', savedTheme);
@@ -555,7 +563,13 @@ function updateStatsDisplay(data) {
     const banner = document.getElementById('warningBanner');
     const message = document.getElementById('warningMessage');
     if (data.service_shutdown && banner && message) {
-        message.textContent = data.shutdown_reason || '服务已停止';
+        let text = data.shutdown_reason || '服务已停止';
+        if (data.recover_at) {
+            text += `，预计 ${new Date(data.recover_at).toLocaleString()} 自动恢复`;
+        } else if (data.service_override === 'closed') {
+            text += '，需管理员手动开启';
+        }
+        message.textContent = text;
         banner.style.display = 'block';
     } else if (banner) {
         banner.style.display = 'none';
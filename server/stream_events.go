@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type StreamEvent struct {
+	Type         string        `json:"type"`
+	Index        int           `json:"index"`
+	DeltaType    string        `json:"delta_type,omitempty"`
+	Delta        string        `json:"delta,omitempty"`
+	Thinking     string        `json:"thinking,omitempty"`
+	PartialJSON  string        `json:"partial_json,omitempty"`
+	Block        *ContentBlock `json:"content_block,omitempty"`
+	Citation     *Citation     `json:"citation,omitempty"`
+	Message      *MessageInfo  `json:"message,omitempty"`
+	StopReason   string        `json:"stop_reason,omitempty"`
+	StopSequence string        `json:"stop_sequence,omitempty"`
+	MessageLimit *MessageLimit `json:"message_limit,omitempty"`
+	Data         string        `json:"-"`
+	Err          error         `json:"-"`
+}
+
+type ContentBlock struct {
+	Index        int        `json:"index"`
+	Type         string     `json:"type"`
+	Text         string     `json:"text,omitempty"`
+	Thinking     string     `json:"thinking,omitempty"`
+	ID           string     `json:"id,omitempty"`
+	Name         string     `json:"name,omitempty"`
+	Input        any        `json:"input,omitempty"`
+	ToolUseID    string     `json:"tool_use_id,omitempty"`
+	Content      any        `json:"content,omitempty"`
+	IsError      bool       `json:"is_error,omitempty"`
+	Citations    []Citation `json:"citations,omitempty"`
+	partialInput string
+}
+
+type Citation struct {
+	UUID       string `json:"uuid,omitempty"`
+	Type       string `json:"type,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Title      string `json:"title,omitempty"`
+	CitedText  string `json:"cited_text,omitempty"`
+	StartIndex *int   `json:"start_index,omitempty"`
+	EndIndex   *int   `json:"end_index,omitempty"`
+}
+
+type MessageInfo struct {
+	ID         string `json:"id,omitempty"`
+	UUID       string `json:"uuid,omitempty"`
+	ParentUUID string `json:"parent_uuid,omitempty"`
+	Model      string `json:"model,omitempty"`
+}
+
+type MessageLimit struct {
+	Type      string `json:"type"`
+	ResetsAt  *int64 `json:"resets_at,omitempty"`
+	Remaining *int   `json:"remaining,omitempty"`
+}
+
+type StreamResult struct {
+	Text              string          `json:"text"`
+	Thinking          string          `json:"thinking,omitempty"`
+	Blocks            []*ContentBlock `json:"content_blocks,omitempty"`
+	Citations         []Citation      `json:"citations,omitempty"`
+	MessageUUID       string          `json:"message_uuid,omitempty"`
+	ParentMessageUUID string          `json:"parent_message_uuid,omitempty"`
+	StopReason        string          `json:"stop_reason,omitempty"`
+	StopSequence      string          `json:"stop_sequence,omitempty"`
+	FinishReason      string          `json:"finish_reason,omitempty"`
+	MessageLimit      *MessageLimit   `json:"message_limit,omitempty"`
+	text              strings.Builder
+	thinking          strings.Builder
+}
+
+func parseStreamEvent(eventType, data string) StreamEvent {
+	ev := StreamEvent{Type: eventType, Data: data}
+	var payload struct {
+		Index        int             `json:"index"`
+		Message      json.RawMessage `json:"message"`
+		ContentBlock json.RawMessage `json:"content_block"`
+		Delta        struct {
+			Type         string          `json:"type"`
+			Text         string          `json:"text"`
+			Thinking     string          `json:"thinking"`
+			PartialJSON  string          `json:"partial_json"`
+			Citation     json.RawMessage `json:"citation"`
+			StopReason   string          `json:"stop_reason"`
+			StopSequence string          `json:"stop_sequence"`
+			Summary      struct {
+				Summary string `json:"summary"`
+			} `json:"summary"`
+		} `json:"delta"`
+		MessageLimit json.RawMessage `json:"message_limit"`
+	}
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		if eventType == "error" {
+			ev.Err = fmt.Errorf("received error event: %s", data)
+		}
+		return ev
+	}
+	ev.Index = payload.Index
+	switch eventType {
+	case "message_start":
+		var message MessageInfo
+		if json.Unmarshal(payload.Message, &message) == nil {
+			ev.Message = &message
+		}
+	case "content_block_start":
+		var block ContentBlock
+		if json.Unmarshal(payload.ContentBlock, &block) == nil {
+			block.Index = payload.Index
+			ev.Block = &block
+		}
+	case "content_block_delta":
+		ev.DeltaType = payload.Delta.Type
+		switch payload.Delta.Type {
+		case "thinking_delta":
+			ev.Thinking = payload.Delta.Thinking
+		case "thinking_summary_delta":
+			ev.Thinking = payload.Delta.Summary.Summary
+		case "input_json_delta":
+			ev.PartialJSON = payload.Delta.PartialJSON
+		case "citation_start_delta", "citations_delta":
+			var citation Citation
+			if json.Unmarshal(payload.Delta.Citation, &citation) == nil {
+				ev.Citation = &citation
+			}
+		default:
+			ev.Delta = payload.Delta.Text
+		}
+	case "message_delta":
+		ev.StopReason = payload.Delta.StopReason
+		ev.StopSequence = payload.Delta.StopSequence
+	case "message_limit":
+		var limit MessageLimit
+		if json.Unmarshal(payload.MessageLimit, &limit) == nil {
+			var raw struct {
+				ResetsAt *int64 `json:"resetsAt"`
+			}
+			if json.Unmarshal(payload.MessageLimit, &raw) == nil && raw.ResetsAt != nil {
+				limit.ResetsAt = raw.ResetsAt
+			}
+			ev.MessageLimit = &limit
+		}
+	case "error":
+		ev.Err = fmt.Errorf("received error event: %s", data)
+	}
+	return ev
+}
+
+func (ev StreamEvent) IsTextDelta() bool {
+	return ev.Type == "content_block_delta" && ev.Delta != ""
+}
+
+func (r *StreamResult) block(index int, blockType string) *ContentBlock {
+	for i := len(r.Blocks) - 1; i >= 0; i-- {
+		if r.Blocks[i].Index == index {
+			return r.Blocks[i]
+		}
+	}
+	block := &ContentBlock{Index: index, Type: blockType}
+	r.Blocks = append(r.Blocks, block)
+	return block
+}
+
+func (r *StreamResult) Apply(ev StreamEvent) {
+	switch ev.Type {
+	case "message_start":
+		if ev.Message != nil {
+			r.MessageUUID = ev.Message.UUID
+			r.ParentMessageUUID = ev.Message.ParentUUID
+		}
+	case "content_block_start":
+		if ev.Block != nil {
+			block := *ev.Block
+			r.Blocks = append(r.Blocks, &block)
+			if block.Text != "" {
+				r.text.WriteString(block.Text)
+			}
+		}
+	case "content_block_delta":
+		switch {
+		case ev.Delta != "":
+			r.block(ev.Index, "text").Text += ev.Delta
+			r.text.WriteString(ev.Delta)
+		case ev.Thinking != "":
+			r.block(ev.Index, "thinking").Thinking += ev.Thinking
+			r.thinking.WriteString(ev.Thinking)
+		case ev.PartialJSON != "":
+			r.block(ev.Index, "tool_use").partialInput += ev.PartialJSON
+		case ev.Citation != nil:
+			block := r.block(ev.Index, "text")
+			block.Citations = append(block.Citations, *ev.Citation)
+			r.Citations = append(r.Citations, *ev.Citation)
+		}
+	case "content_block_stop":
+		block := r.block(ev.Index, "text")
+		if block.partialInput != "" {
+			var input any
+			if json.Unmarshal([]byte(block.partialInput), &input) == nil {
+				block.Input = input
+			} else {
+				block.Input = block.partialInput
+			}
+		}
+	case "message_delta":
+		if ev.StopReason != "" {
+			r.StopReason = ev.StopReason
+		}
+		if ev.StopSequence != "" {
+			r.StopSequence = ev.StopSequence
+		}
+	case "message_limit":
+		r.MessageLimit = ev.MessageLimit
+	}
+	r.Text = r.text.String()
+	r.Thinking = r.thinking.String()
+}
+
+func (r *StreamResult) truncate(length int, finishReason, stopSequence string) {
+	r.text.Reset()
+	r.text.WriteString(r.Text[:length])
+	r.Text = r.text.String()
+	r.FinishReason = finishReason
+	r.StopSequence = stopSequence
+}
+
+func upstreamFinishReason(stopReason string) string {
+	switch stopReason {
+	case "":
+		return ""
+	case "max_tokens":
+		return "length"
+	}
+	return "stop"
+}
+
+func (r *StreamResult) StructuredBlocks() []*ContentBlock {
+	blocks := make([]*ContentBlock, 0, len(r.Blocks))
+	for _, block := range r.Blocks {
+		if block.Type != "text" || len(block.Citations) > 0 {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func (d *CldDialogue) ApplyStreamResult(result *StreamResult) {
+	if result == nil {
+		return
+	}
+	d.OutputTokens = estimateOutputTokens(result)
+	if result.FinishReason != "" {
+		finishReason := result.FinishReason
+		d.FinishReason = &finishReason
+	}
+	if result.Thinking != "" {
+		thinking := result.Thinking
+		d.Thinking = &thinking
+	}
+	if result.StopReason != "" {
+		stopReason := result.StopReason
+		d.StopReason = &stopReason
+	}
+	if blocks := result.StructuredBlocks(); len(blocks) > 0 {
+		d.ContentBlocks, _ = json.Marshal(blocks)
+	}
+	if result.ParentMessageUUID != "" {
+		userMessageUUID := result.ParentMessageUUID
+		d.UserMessageUUID = &userMessageUUID
+	}
+	if result.MessageUUID != "" {
+		assistantMessageUUID := result.MessageUUID
+		d.AssistantMessageUUID = &assistantMessageUUID
+	}
+}
+
+type streamLimiter struct {
+	maxTokens int
+	stop      []string
+	longest   int
+	scanned   int
+}
+
+func newStreamLimiter(maxTokens int, stop []string) *streamLimiter {
+	l := &streamLimiter{maxTokens: maxTokens, stop: stop}
+	for _, sequence := range stop {
+		l.longest = max(l.longest, len(sequence))
+	}
+	return l
+}
+
+func (l *streamLimiter) apply(result *StreamResult) bool {
+	text := result.Text
+	end, finishReason, stopSequence := len(text), "", ""
+	if l.longest > 0 {
+		from := max(0, l.scanned-l.longest+1)
+		if cut, sequence := firstStopSequence(text[from:], l.stop); cut >= 0 {
+			end, finishReason, stopSequence = from+cut, "stop", sequence
+		}
+	}
+	if l.maxTokens > 0 && l.scanned < end {
+		if truncated, ok := truncateToTokens(text[:end], l.maxTokens); ok {
+			end, finishReason, stopSequence = len(truncated), "length", ""
+		}
+	}
+	l.scanned = end
+	if finishReason == "" {
+		return false
+	}
+	result.truncate(end, finishReason, stopSequence)
+	return true
+}
+
+func stopUpstream(client ClaudeClient, conversationID string) {
+	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.StopResponse(stopCtx, conversationID); err != nil {
+		log.Printf("⚠ 停止上游生成失败 %s: %v", conversationID, err)
+	} else {
+		log.Printf("⏹ 已停止上游生成: %s", conversationID)
+	}
+}
+
+func streamCompletion(ctx context.Context, client ClaudeClient, cr CompletionRequest, onEvent func(StreamEvent, *StreamResult)) (*StreamResult, error) {
+	result := &StreamResult{}
+	streamCtx, closeStream := context.WithCancel(ctx)
+	defer closeStream()
+	events, err := client.Complete(streamCtx, cr)
+	if err != nil {
+		return result, err
+	}
+	limiter := newStreamLimiter(cr.MaxTokens, cr.Stop)
+	for ev := range events {
+		if ctx.Err() != nil {
+			break
+		}
+		if ev.Err != nil {
+			return result, ev.Err
+		}
+		result.Apply(ev)
+		limited := ev.IsTextDelta() && limiter.apply(result)
+		if onEvent != nil {
+			onEvent(ev, result)
+		}
+		if limited {
+			log.Printf("⏹ 已达到生成限制 (%s): %s", result.FinishReason, cr.ConversationID)
+			closeStream()
+			stopUpstream(client, cr.ConversationID)
+			return result, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		stopUpstream(client, cr.ConversationID)
+		return result, err
+	}
+	result.FinishReason = upstreamFinishReason(result.StopReason)
+	return result, nil
+}
+
+func forwardStreamEvent(ev StreamEvent, send func(msgType string, data any)) {
+	switch ev.Type {
+	case "content_block_delta":
+		if ev.IsTextDelta() || ev.DeltaType == "citation_end_delta" || ev.DeltaType == "signature_delta" {
+			return
+		}
+	case "message_start", "content_block_start", "content_block_stop", "message_delta", "message_limit":
+	default:
+		return
+	}
+	send("stream_event", ev)
+}
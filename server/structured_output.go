@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var jsonFencePattern = regexp.MustCompile("(?s)```(?:json|JSON)?[ \t]*\n?(.*?)```")
+
+type StructuredOutput struct {
+	Name        string
+	Description string
+	Schema      json.RawMessage
+}
+
+func (f *ResponseFormat) structuredOutput() (*StructuredOutput, error) {
+	if f == nil {
+		return nil, nil
+	}
+	switch f.Type {
+	case "", "text":
+		return nil, nil
+	case "json_object":
+		return &StructuredOutput{}, nil
+	case "json_schema":
+		if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+			return nil, fmt.Errorf("response_format.json_schema.schema is required")
+		}
+		if err := checkJSONSchema(f.JSONSchema.Schema, "response_format.json_schema.schema"); err != nil {
+			return nil, err
+		}
+		return &StructuredOutput{
+			Name:        f.JSONSchema.Name,
+			Description: f.JSONSchema.Description,
+			Schema:      f.JSONSchema.Schema,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported response_format type %q", f.Type)
+}
+
+func ollamaResponseFormat(raw json.RawMessage) (*ResponseFormat, error) {
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		return nil, nil
+	}
+	var format string
+	if err := json.Unmarshal(raw, &format); err == nil {
+		if format != "json" {
+			return nil, fmt.Errorf("unsupported format %q", format)
+		}
+		return &ResponseFormat{Type: "json_object"}, nil
+	}
+	return &ResponseFormat{Type: "json_schema", JSONSchema: &ResponseJSONSchema{Schema: raw}}, nil
+}
+
+func (s *StructuredOutput) prompt() string {
+	var prompt strings.Builder
+	if len(s.Schema) == 0 {
+		prompt.WriteString("Respond only with a single valid JSON object.")
+	} else {
+		prompt.WriteString("Respond only with a single valid JSON value that conforms to the following JSON Schema")
+		if s.Name != "" {
+			fmt.Fprintf(&prompt, " (%s)", s.Name)
+		}
+		prompt.WriteString(".")
+		if s.Description != "" {
+			prompt.WriteString(" " + s.Description)
+		}
+		prompt.WriteString("\n\n<json_schema>\n")
+		prompt.Write(s.Schema)
+		prompt.WriteString("\n</json_schema>\n\n")
+		prompt.WriteString("Include every required property and do not add properties the schema does not allow.")
+	}
+	prompt.WriteString(" Do not wrap the JSON in Markdown code fences and do not write anything before or after it.")
+	return prompt.String()
+}
+
+func extractJSON(text string) (string, error) {
+	body := strings.TrimSpace(text)
+	if match := jsonFencePattern.FindStringSubmatch(body); match != nil {
+		body = strings.TrimSpace(match[1])
+	}
+	if json.Valid([]byte(body)) {
+		return body, nil
+	}
+	start := strings.IndexAny(body, "{[")
+	end := strings.LastIndexAny(body, "}]")
+	if start >= 0 && end > start && json.Valid([]byte(body[start:end+1])) {
+		return body[start : end+1], nil
+	}
+	return "", fmt.Errorf("the reply does not contain valid JSON")
+}
+
+func (s *StructuredOutput) parse(text string) (string, error) {
+	body, err := extractJSON(text)
+	if err != nil {
+		return "", err
+	}
+	var value any
+	json.Unmarshal([]byte(body), &value)
+	if len(s.Schema) == 0 {
+		if _, ok := value.(map[string]any); !ok {
+			return "", fmt.Errorf("the reply must be a JSON object")
+		}
+		return body, nil
+	}
+	if problems := validateJSONSchema(s.Schema, value, "$"); len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return body, nil
+}
+
+func (s *StructuredOutput) validate(text string) error {
+	_, err := s.parse(text)
+	return err
+}
+
+func (s *StructuredOutput) systemPrompt(base string) string {
+	if s == nil {
+		return base
+	}
+	return joinSystemPrompts(base, s.prompt())
+}
+
+func (s *StructuredOutput) validator() func(string) error {
+	if s == nil {
+		return nil
+	}
+	return s.validate
+}
+
+func (s *StructuredOutput) reply(text string) string {
+	if s == nil {
+		return text
+	}
+	body, err := s.parse(text)
+	if err != nil {
+		return text
+	}
+	return body
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-server/fakeclaude"
+
+	"github.com/gorilla/websocket"
+)
+
+var personFormat = map[string]any{
+	"type": "json_schema",
+	"json_schema": map[string]any{
+		"name": "person",
+		"schema": map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{"name": map[string]any{"type": "string"}},
+			"required":             []string{"name"},
+			"additionalProperties": false,
+		},
+	},
+}
+
+func TestChatCompletionStructuredOutputRepair(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(
+		fakeclaude.TextReply(`{"nickname": "Ada"}`),
+		fakeclaude.TextReply(`{"name": "Ada"}`),
+	)
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-format", chatRequest("Who?", map[string]any{
+		"response_format": personFormat,
+	}), http.StatusOK, &resp)
+	var person map[string]any
+	if err := json.Unmarshal([]byte(resp.Choices[0].Message.Content), &person); err != nil || person["name"] != "Ada" {
+		t.Fatalf("got %q, want the repaired JSON object", resp.Choices[0].Message.Content)
+	}
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 2 {
+		t.Fatalf("got %d upstream completions, want 2", got)
+	}
+}
+
+func TestChatCompletionRepairGivesUp(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) {
+		cfg.CompatRepairAttempts = 1
+	})
+	env.fake.QueueCompletion(
+		fakeclaude.TextReply("not json"),
+		fakeclaude.TextReply("still not json"),
+	)
+	var resp struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-format-fail", chatRequest("Who?", map[string]any{
+		"response_format": personFormat,
+	}), http.StatusBadGateway, &resp)
+	if resp.Error.Type != "validation_error" {
+		t.Fatalf("got error type %q, want validation_error", resp.Error.Type)
+	}
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 2 {
+		t.Fatalf("got %d upstream completions, want 2", got)
+	}
+}
+
+func TestPersistentWebSocketStructuredOutputRepair(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(
+		fakeclaude.TextReply(`{"nickname": "Ada"}`),
+		fakeclaude.TextReply(`{"name": "Ada"}`),
+	)
+	header := http.Header{"Authorization": {"Bearer " + env.apiKey}, "X-Device-ID": {"device-ws-format"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(env.server.URL, "http")+"/data/websocket/create", header)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]any{"type": "dialogue", "data": map[string]any{
+		"request":         "Who?",
+		"device_id":       "device-ws-format",
+		"response_format": personFormat,
+	}})
+	for {
+		var msg struct {
+			Type string         `json:"type"`
+			Data map[string]any `json:"data"`
+		}
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if msg.Type == "error" {
+			t.Fatalf("got error %v", msg.Data)
+		}
+		if msg.Type != "done" {
+			continue
+		}
+		var person map[string]any
+		if err := json.Unmarshal([]byte(msg.Data["response"].(string)), &person); err != nil || person["name"] != "Ada" {
+			t.Fatalf("got %v, want the repaired JSON object", msg.Data["response"])
+		}
+		break
+	}
+	if got := len(env.fake.Requests(fakeclaude.RouteCompletion)); got != 2 {
+		t.Fatalf("got %d upstream completions, want 2", got)
+	}
+}
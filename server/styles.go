@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var styleKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var builtinStyles = []StyleConfig{
+	{Key: "normal", Name: "Normal", Summary: "默认回复风格"},
+	{Key: "concise", Name: "Concise", Summary: "更精炼的回复风格", Prompt: "concise"},
+	{Key: "explanatory", Name: "Explanatory", Summary: "更详细的回复风格", Prompt: "explanatory"},
+}
+
+type StyleInfo struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+	Type    string `json:"type"`
+	Source  string `json:"source"`
+	Prompt  string `json:"prompt,omitempty"`
+	Default bool   `json:"default"`
+}
+
+type StyleRequest struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+	Prompt  string `json:"prompt"`
+}
+
+type UnknownStyleError struct {
+	Style string
+}
+
+func (e *UnknownStyleError) Error() string {
+	return fmt.Sprintf("unknown style %q", e.Style)
+}
+
+func (c *Config) styleCatalog() []StyleConfig {
+	if len(c.Styles) > 0 {
+		return c.Styles
+	}
+	return builtinStyles
+}
+
+func (c *Config) findStyle(key string) *StyleConfig {
+	styles := c.styleCatalog()
+	for i := range styles {
+		if strings.EqualFold(styles[i].Key, key) {
+			return &styles[i]
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateStyles() error {
+	seen := make(map[string]bool)
+	for i, style := range c.styleCatalog() {
+		if style.Key == "" {
+			return fmt.Errorf("styles[%d] 缺少 key", i)
+		}
+		key := strings.ToLower(style.Key)
+		if seen[key] {
+			return fmt.Errorf("样式 key 重复: %s", style.Key)
+		}
+		seen[key] = true
+	}
+	if c.DefaultStyle != "" && c.findStyle(c.DefaultStyle) == nil {
+		return fmt.Errorf("default_style %s 不在 styles 列表中", c.DefaultStyle)
+	}
+	return nil
+}
+
+func styleType(style StyleConfig) string {
+	if style.Prompt == "" {
+		return "none"
+	}
+	if getStylePrompt(strings.ToLower(style.Prompt)) != nil {
+		return "preset"
+	}
+	return "custom"
+}
+
+func configStylePrompt(style StyleConfig) *StylePrompt {
+	switch styleType(style) {
+	case "none":
+		return nil
+	case "preset":
+		preset := *getStylePrompt(strings.ToLower(style.Prompt))
+		preset.Type = "preset"
+		return &preset
+	}
+	return &StylePrompt{
+		Key:     style.Key,
+		Name:    style.Name,
+		Summary: style.Summary,
+		Prompt:  style.Prompt,
+		Type:    "custom",
+		UUID:    uuid.NewSHA1(uuid.NameSpaceURL, []byte("style:"+style.Key)).String(),
+	}
+}
+
+func resolveStyle(cfg *Config, key string) (*StylePrompt, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = cfg.DefaultStyle
+	}
+	if key == "" {
+		return nil, nil
+	}
+	if style := cfg.findStyle(key); style != nil {
+		return configStylePrompt(*style), nil
+	}
+	if db != nil {
+		if custom, err := db.GetCustomStyle(key); err == nil {
+			return &StylePrompt{
+				Key:     custom.Key,
+				Name:    custom.Name,
+				Summary: custom.Summary,
+				Prompt:  custom.Prompt,
+				Type:    "custom",
+				UUID:    custom.UID,
+			}, nil
+		}
+	}
+	return nil, &UnknownStyleError{Style: key}
+}
+
+func (s *StylePrompt) payload() map[string]any {
+	payload := map[string]any{
+		"type":   s.Type,
+		"key":    s.Key,
+		"name":   s.Name,
+		"prompt": s.Prompt,
+	}
+	if s.Type == "custom" {
+		payload["uuid"] = s.UUID
+		payload["summary"] = s.Summary
+		payload["isDefault"] = false
+	}
+	return payload
+}
+
+func (h *Handler) ListStyles(c *gin.Context) {
+	styles := make([]StyleInfo, 0)
+	for _, style := range h.config.styleCatalog() {
+		styles = append(styles, StyleInfo{
+			Key:     style.Key,
+			Name:    style.Name,
+			Summary: style.Summary,
+			Type:    styleType(style),
+			Source:  "config",
+			Default: strings.EqualFold(style.Key, h.config.DefaultStyle),
+		})
+	}
+	customStyles, err := h.db.GetCustomStyles()
+	if err != nil {
+		log.Printf("Failed to load custom styles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load styles"})
+		return
+	}
+	for _, style := range customStyles {
+		styles = append(styles, StyleInfo{
+			Key:     style.Key,
+			Name:    style.Name,
+			Summary: style.Summary,
+			Type:    "custom",
+			Source:  "custom",
+			Prompt:  style.Prompt,
+			Default: strings.EqualFold(style.Key, h.config.DefaultStyle),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"styles":        styles,
+		"default_style": h.config.DefaultStyle,
+	})
+}
+
+func (h *Handler) CreateStyle(c *gin.Context) {
+	var req StyleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.Key = strings.ToLower(strings.TrimSpace(req.Key))
+	if !styleKeyPattern.MatchString(req.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Style key must be 1-64 characters of a-z, 0-9, '_' or '-'"})
+		return
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Style prompt cannot be empty"})
+		return
+	}
+	if h.config.findStyle(req.Key) != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Style key is reserved by config"})
+		return
+	}
+	if _, err := h.db.GetCustomStyle(req.Key); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Style already exists"})
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.Key
+	}
+	style := &CldStyle{
+		UID:     uuid.New().String(),
+		Key:     req.Key,
+		Name:    req.Name,
+		Summary: req.Summary,
+		Prompt:  req.Prompt,
+	}
+	if err := h.db.CreateCustomStyle(style); err != nil {
+		log.Printf("Failed to create style: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create style"})
+		return
+	}
+	log.Printf("✓ 已创建自定义样式: %s", style.Key)
+	c.JSON(http.StatusOK, style)
+}
+
+func (h *Handler) UpdateStyle(c *gin.Context) {
+	key := c.Param("key")
+	if h.config.findStyle(key) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Config styles cannot be modified"})
+		return
+	}
+	style, err := h.db.GetCustomStyle(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Style not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load style"})
+		return
+	}
+	var req StyleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Name != "" {
+		style.Name = req.Name
+	}
+	if req.Summary != "" {
+		style.Summary = req.Summary
+	}
+	if strings.TrimSpace(req.Prompt) != "" {
+		style.Prompt = req.Prompt
+	}
+	if err := h.db.UpdateCustomStyle(style); err != nil {
+		log.Printf("Failed to update style: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update style"})
+		return
+	}
+	c.JSON(http.StatusOK, style)
+}
+
+func (h *Handler) DeleteStyle(c *gin.Context) {
+	key := c.Param("key")
+	if h.config.findStyle(key) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Config styles cannot be deleted"})
+		return
+	}
+	style, err := h.db.GetCustomStyle(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Style not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load style"})
+		return
+	}
+	if err := h.db.DeleteCustomStyle(style.ID); err != nil {
+		log.Printf("Failed to delete style: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete style"})
+		return
+	}
+	log.Printf("✓ 已删除自定义样式: %s", style.Key)
+	c.JSON(http.StatusOK, gin.H{"message": "Style deleted successfully"})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type SyncResult struct {
+	ConversationID    int  `json:"conversation_id"`
+	Messages          int  `json:"messages"`
+	Created           int  `json:"created"`
+	Updated           int  `json:"updated"`
+	CurrentDialogueID *int `json:"current_dialogue_id"`
+}
+
+func syncString(target **string, value string) bool {
+	if value == "" || (*target != nil && **target == value) {
+		return false
+	}
+	*target = &value
+	return true
+}
+
+func syncMissingString(target **string, value string) bool {
+	if *target != nil && **target != "" {
+		return false
+	}
+	return syncString(target, value)
+}
+
+func applyUpstreamExchange(d *CldDialogue, human, assistant *UpstreamMessage) bool {
+	changed := false
+	if d.UserMessage == "" {
+		d.UserMessage = human.PlainText()
+		changed = true
+	}
+	changed = syncString(&d.UserMessageUUID, human.UUID) || changed
+	changed = syncString(&d.ParentMessageUUID, human.ParentMessageUUID) || changed
+	if len(d.Attachments) == 0 {
+		if attachments := human.FileAttachments(); len(attachments) > 0 {
+			d.Attachments, _ = json.Marshal(attachments)
+			changed = true
+		}
+	}
+	if assistant == nil {
+		return changed
+	}
+	changed = syncString(&d.AssistantMessageUUID, assistant.UUID) || changed
+	changed = syncMissingString(&d.AssistantMessage, assistant.PlainText()) || changed
+	changed = syncMissingString(&d.Thinking, assistant.ThinkingText()) || changed
+	changed = syncMissingString(&d.StopReason, assistant.StopReason) || changed
+	if len(d.ContentBlocks) == 0 {
+		if blocks := assistant.StructuredContent(); blocks != nil {
+			d.ContentBlocks = blocks
+			changed = true
+		}
+	}
+	if d.FinishTime == nil {
+		finishTime := assistant.UpdateTime()
+		d.FinishTime = &finishTime
+		duration := int(finishTime.Sub(d.CreateTime).Milliseconds())
+		d.Duration = &duration
+		changed = true
+	}
+	if (d.Status == "send_failed" || d.Status == "reply_failed") && d.AssistantMessage != nil && *d.AssistantMessage != "" {
+		d.Status = "done"
+		changed = true
+	}
+	return changed
+}
+
+func (h *Handler) syncConversation(ctx context.Context, conv *CldConversation) (*SyncResult, error) {
+	upstream, err := h.client.GetConversation(ctx, conv.UID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := h.db.GetConversationDialogues(conv.ID)
+	if err != nil {
+		return nil, err
+	}
+	byAssistant := make(map[string]*CldDialogue)
+	byUser := make(map[string]*CldDialogue)
+	var legacy []*CldDialogue
+	for i := range existing {
+		d := &existing[i]
+		switch {
+		case d.AssistantMessageUUID != nil:
+			byAssistant[*d.AssistantMessageUUID] = d
+		case d.UserMessageUUID != nil:
+			byUser[*d.UserMessageUUID] = d
+		default:
+			legacy = append(legacy, d)
+		}
+	}
+	messages := append([]UpstreamMessage(nil), upstream.Messages...)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Index < messages[j].Index
+	})
+	replies := make(map[string][]*UpstreamMessage)
+	for i := range messages {
+		if messages[i].Sender == "assistant" {
+			replies[messages[i].ParentMessageUUID] = append(replies[messages[i].ParentMessageUUID], &messages[i])
+		}
+	}
+	nextOrder, err := h.db.GetNextDialogueOrder(conv.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := &SyncResult{ConversationID: conv.ID, Messages: len(messages)}
+	var synced, changed []*CldDialogue
+	for i := range messages {
+		human := &messages[i]
+		if human.Sender != "human" {
+			continue
+		}
+		assistants := replies[human.UUID]
+		if len(assistants) == 0 {
+			assistants = []*UpstreamMessage{nil}
+		}
+		for _, assistant := range assistants {
+			var dialogue *CldDialogue
+			if assistant != nil {
+				dialogue = byAssistant[assistant.UUID]
+			}
+			if dialogue == nil {
+				if d, ok := byUser[human.UUID]; ok {
+					dialogue = d
+					delete(byUser, human.UUID)
+				}
+			}
+			if dialogue == nil {
+				text := human.PlainText()
+				for j, d := range legacy {
+					if d.UserMessage == text {
+						dialogue = d
+						legacy = append(legacy[:j], legacy[j+1:]...)
+						break
+					}
+				}
+			}
+			if dialogue == nil {
+				status := "reply_failed"
+				if assistant != nil {
+					status = "done"
+				}
+				dialogue = &CldDialogue{
+					UID:            uuid.New().String(),
+					ConversationID: conv.ID,
+					Order:          nextOrder,
+					CreateTime:     human.CreateTime(),
+					Status:         status,
+				}
+				nextOrder++
+				result.Created++
+				applyUpstreamExchange(dialogue, human, assistant)
+				changed = append(changed, dialogue)
+			} else if applyUpstreamExchange(dialogue, human, assistant) {
+				result.Updated++
+				changed = append(changed, dialogue)
+			}
+			synced = append(synced, dialogue)
+		}
+	}
+	if err := h.db.SaveDialogues(changed); err != nil {
+		return nil, err
+	}
+	assistantIDs := make(map[string]int)
+	userIDs := make(map[string]int)
+	for _, d := range synced {
+		if d.AssistantMessageUUID != nil {
+			assistantIDs[*d.AssistantMessageUUID] = d.ID
+		}
+		if d.UserMessageUUID != nil {
+			userIDs[*d.UserMessageUUID] = d.ID
+		}
+	}
+	var relinked []*CldDialogue
+	for _, d := range synced {
+		if d.ParentMessageUUID == nil {
+			continue
+		}
+		parentID, ok := assistantIDs[*d.ParentMessageUUID]
+		if !ok {
+			continue
+		}
+		if d.ParentID == nil || *d.ParentID != parentID {
+			d.ParentID = &parentID
+			relinked = append(relinked, d)
+		}
+	}
+	if err := h.db.SaveDialogues(relinked); err != nil {
+		return nil, err
+	}
+	leafID, ok := assistantIDs[upstream.CurrentLeafMessageUUID]
+	if !ok {
+		leafID, ok = userIDs[upstream.CurrentLeafMessageUUID]
+	}
+	if ok && (conv.CurrentDialogueID == nil || result.Created > 0) {
+		if err := h.db.SetCurrentDialogue(conv.ID, leafID); err != nil {
+			return nil, err
+		}
+		conv.CurrentDialogueID = &leafID
+		if _, isAssistant := assistantIDs[upstream.CurrentLeafMessageUUID]; isAssistant {
+			h.dialogueManager.UpdateSession(conv.UID, upstream.CurrentLeafMessageUUID)
+		}
+	}
+	result.CurrentDialogueID = conv.CurrentDialogueID
+	if result.Created > 0 || result.Updated > 0 {
+		log.Printf("✓ 已同步上游对话历史: %s (新增 %d, 更新 %d)", conv.UID, result.Created, result.Updated)
+	}
+	return result, nil
+}
+
+func (h *Handler) SyncDialogue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	conv, err := h.db.GetConversation(id)
+	if err != nil || !requestAPIAccess(c).ownsConversation(conv) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	session := h.dialogueManager.GetOrCreateSession(conv.UID)
+	session.GeneratingMutex.RLock()
+	generating := session.IsGenerating
+	session.GeneratingMutex.RUnlock()
+	if generating {
+		c.JSON(http.StatusConflict, gin.H{"error": "Conversation is generating, try again later"})
+		return
+	}
+	result, err := h.syncConversation(c.Request.Context(), conv)
+	if err != nil {
+		log.Printf("Failed to sync conversation %s: %v", conv.UID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to sync conversation: " + err.Error()})
+		return
+	}
+	broadcastDialogues()
+	c.JSON(http.StatusOK, result)
+}
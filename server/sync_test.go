@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+func TestSyncDialogue(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("First reply"))
+	var dialogue DialogueResponse
+	env.doJSON(http.MethodPost, "/chat/dialogue/http", "device-sync", map[string]any{"request": "First question"}, http.StatusOK, &dialogue)
+	conv, err := env.db.GetConversationByUID(dialogue.ConversationID)
+	if err != nil {
+		t.Fatalf("conversation %s was not stored: %v", dialogue.ConversationID, err)
+	}
+	if _, _, ok := env.fake.AppendExchange(dialogue.ConversationID, "Asked on claude.ai", "Answered on claude.ai"); !ok {
+		t.Fatalf("upstream conversation %s not found", dialogue.ConversationID)
+	}
+	var result SyncResult
+	path := fmt.Sprintf("/api/dialogues/%d/sync", conv.ID)
+	env.doJSON(http.MethodPost, path, "device-sync", nil, http.StatusOK, &result)
+	if result.Messages != 4 || result.Created != 1 {
+		t.Fatalf("got %+v, want 4 upstream messages and 1 created dialogue", result)
+	}
+	dialogues, err := env.db.GetConversationDialogues(conv.ID)
+	if err != nil || len(dialogues) != 2 {
+		t.Fatalf("got %d dialogues (%v), want 2", len(dialogues), err)
+	}
+	synced := dialogues[1]
+	if synced.UserMessage != "Asked on claude.ai" || synced.AssistantMessage == nil || *synced.AssistantMessage != "Answered on claude.ai" {
+		t.Fatalf("synced dialogue has %q / %v", synced.UserMessage, synced.AssistantMessage)
+	}
+	if synced.ParentID == nil || *synced.ParentID != dialogue.DialogueID {
+		t.Fatalf("synced dialogue parent is %v, want %d", synced.ParentID, dialogue.DialogueID)
+	}
+	if result.CurrentDialogueID == nil || *result.CurrentDialogueID != synced.ID {
+		t.Fatalf("current dialogue is %v, want %d", result.CurrentDialogueID, synced.ID)
+	}
+	env.doJSON(http.MethodPost, path, "device-sync", nil, http.StatusOK, &result)
+	if result.Created != 0 || result.Updated != 0 {
+		t.Fatalf("second sync changed dialogues: %+v", result)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	imageTokensMax = 1600
+	pdfPageTokens  = 1500
+)
+
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page\b`)
+
+var (
+	tokenizerOnce sync.Once
+	tokenizer     *tiktoken.Tiktoken
+)
+
+func tokenEncoding() *tiktoken.Tiktoken {
+	tokenizerOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+		encoding, err := tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+		if err != nil {
+			log.Fatalf("加载 cl100k_base 分词器失败: %v", err)
+		}
+		tokenizer = encoding
+	})
+	return tokenizer
+}
+
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return len(tokenEncoding().EncodeOrdinary(text))
+}
+
+func truncateToTokens(text string, maxTokens int) (string, bool) {
+	if len(text) <= maxTokens {
+		return text, false
+	}
+	encoding := tokenEncoding()
+	tokens := encoding.EncodeOrdinary(text)
+	if len(tokens) <= maxTokens {
+		return text, false
+	}
+	end := len(encoding.Decode(tokens[:maxTokens]))
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end], true
+}
+
+func fileTokens(file RequestFile) int {
+	raw := file.ContentRaw
+	if raw == nil && file.Content != "" {
+		raw, _ = base64.StdEncoding.DecodeString(file.Content)
+	}
+	switch {
+	case strings.HasPrefix(file.Type, "image/"):
+		if config, _, err := image.DecodeConfig(bytes.NewReader(raw)); err == nil {
+			return min(imageTokensMax, max(1, config.Width*config.Height/750))
+		}
+		return imageTokensMax
+	case file.Type == "application/pdf":
+		return max(1, len(pdfPagePattern.FindAll(raw, -1))) * pdfPageTokens
+	case utf8.Valid(raw):
+		return estimateTokens(string(raw))
+	}
+	return 0
+}
+
+func estimateInputTokens(systemPrompt, prompt string, files []RequestFile) int {
+	tokens := estimateTokens(systemPrompt) + estimateTokens(prompt)
+	for _, file := range files {
+		tokens += fileTokens(file)
+	}
+	return tokens
+}
+
+func estimateOutputTokens(result *StreamResult) int {
+	if result == nil {
+		return 0
+	}
+	return estimateTokens(result.Text) + estimateTokens(result.Thinking)
+}
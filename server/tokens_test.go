@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEstimateTokensMatchesCL100K(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"tiktoken is great!", 6},
+	}
+	for _, tc := range cases {
+		if got := estimateTokens(tc.text); got != tc.want {
+			t.Errorf("estimateTokens(%q) = %d, want %d", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	if got, ok := truncateToTokens("tiktoken is great!", 3); !ok || got != "tiktoken" {
+		t.Fatalf("got %q (%v), want the first three tokens", got, ok)
+	}
+	if got, ok := truncateToTokens("hello world", 2); ok || got != "hello world" {
+		t.Fatalf("got %q (%v), want the text unchanged", got, ok)
+	}
+	text := strings.Repeat("数据同步完成，", 20)
+	for limit := 1; limit < 40; limit++ {
+		got, ok := truncateToTokens(text, limit)
+		if !ok || !utf8.ValidString(got) || !strings.HasPrefix(text, got) || estimateTokens(got) > limit {
+			t.Fatalf("limit %d: got %q (%v), want a valid prefix of at most %d tokens", limit, got, ok, limit)
+		}
+	}
+}
+
+func TestStreamLimiterCutsAtTokenLimit(t *testing.T) {
+	limiter := newStreamLimiter(3, nil)
+	result := &StreamResult{}
+	for _, chunk := range []string{"tik", "token", " is", " great!"} {
+		result.Text += chunk
+		if limiter.apply(result) {
+			break
+		}
+	}
+	if result.Text != "tiktoken" || result.FinishReason != "length" {
+		t.Fatalf("got %q (%s), want tiktoken cut with finish_reason length", result.Text, result.FinishReason)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	toolCallsOpen  = "<tool_calls>"
+	toolCallsClose = "</tool_calls>"
+)
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type CompatTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type CompatToolChoice struct {
+	Mode string
+	Name string
+}
+
+type CompatToolCall struct {
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+func newToolCallID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+}
+
+func (r *CompatRequest) enableTools(tools []CompatTool, choice CompatToolChoice, parallel bool) error {
+	if len(tools) == 0 {
+		if choice.Mode == "required" {
+			return fmt.Errorf("tool_choice requires tools")
+		}
+		return nil
+	}
+	seen := make(map[string]bool, len(tools))
+	for i := range tools {
+		if !toolNamePattern.MatchString(tools[i].Name) {
+			return fmt.Errorf("tools.%d: invalid tool name %q", i, tools[i].Name)
+		}
+		if seen[tools[i].Name] {
+			return fmt.Errorf("tools.%d: duplicate tool name %q", i, tools[i].Name)
+		}
+		seen[tools[i].Name] = true
+		if len(tools[i].Parameters) == 0 || string(tools[i].Parameters) == "null" {
+			tools[i].Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		if err := checkJSONSchema(tools[i].Parameters, fmt.Sprintf("tools.%d.parameters", i)); err != nil {
+			return err
+		}
+	}
+	if choice.Mode == "none" {
+		return nil
+	}
+	if choice.Name != "" && !seen[choice.Name] {
+		return fmt.Errorf("tool_choice references unknown tool %q", choice.Name)
+	}
+	r.Tools = tools
+	r.ToolChoice = choice
+	r.ParallelToolCalls = parallel
+	r.System = joinSystemPrompts(r.System, renderToolsPrompt(tools, choice, parallel))
+	return nil
+}
+
+func renderToolsPrompt(tools []CompatTool, choice CompatToolChoice, parallel bool) string {
+	definitions, _ := json.MarshalIndent(tools, "", "  ")
+	var prompt strings.Builder
+	prompt.WriteString("You can call the following tools. They are executed by the client, not by you, and their results are sent back to you in <tool_result> blocks.\n\n<tools>\n")
+	prompt.Write(definitions)
+	prompt.WriteString("\n</tools>\n\n")
+	prompt.WriteString("To call tools, end your reply with exactly one block in this format and stop writing after it:\n")
+	prompt.WriteString(toolCallsOpen + "\n[{\"name\": \"tool_name\", \"arguments\": {\"parameter\": \"value\"}}]\n" + toolCallsClose + "\n")
+	prompt.WriteString("The arguments of each call must be a JSON object that matches the tool's parameters schema. Never write <tool_result> blocks yourself.\n")
+	switch {
+	case choice.Name != "":
+		fmt.Fprintf(&prompt, "You must call the tool %q in this reply.", choice.Name)
+	case choice.Mode == "required":
+		prompt.WriteString("You must call at least one tool in this reply.")
+	default:
+		prompt.WriteString("Only call a tool when it is needed; otherwise reply normally without a " + toolCallsOpen + " block.")
+	}
+	if !parallel {
+		prompt.WriteString(" Call at most one tool per reply.")
+	}
+	return prompt.String()
+}
+
+func renderToolCallsBlock(calls []CompatToolCall) string {
+	data, _ := json.Marshal(calls)
+	return toolCallsOpen + "\n" + string(data) + "\n" + toolCallsClose
+}
+
+func renderToolResult(id, name, content string) string {
+	attributes := ""
+	if id != "" {
+		attributes += fmt.Sprintf(" tool_call_id=%q", id)
+	}
+	if name != "" {
+		attributes += fmt.Sprintf(" name=%q", name)
+	}
+	return fmt.Sprintf("<tool_result%s>\n%s\n</tool_result>", attributes, content)
+}
+
+func normalizeToolArguments(arguments json.RawMessage) json.RawMessage {
+	trimmed := strings.TrimSpace(string(arguments))
+	if trimmed == "" || trimmed == "null" {
+		return json.RawMessage("{}")
+	}
+	var encoded string
+	if err := json.Unmarshal(arguments, &encoded); err == nil && json.Valid([]byte(encoded)) {
+		return json.RawMessage(encoded)
+	}
+	if !json.Valid([]byte(trimmed)) {
+		quoted, _ := json.Marshal(trimmed)
+		return quoted
+	}
+	return json.RawMessage(trimmed)
+}
+
+func parseToolCalls(text string) (string, []CompatToolCall, bool, error) {
+	start := strings.Index(text, toolCallsOpen)
+	if start < 0 {
+		return text, nil, false, nil
+	}
+	content := strings.TrimSpace(text[:start])
+	body := text[start+len(toolCallsOpen):]
+	if end := strings.Index(body, toolCallsClose); end >= 0 {
+		body = body[:end]
+	}
+	body = strings.TrimSpace(body)
+	body = strings.TrimPrefix(body, "```json")
+	body = strings.TrimPrefix(body, "```")
+	body = strings.TrimSpace(strings.TrimSuffix(body, "```"))
+	var calls []CompatToolCall
+	if err := json.Unmarshal([]byte(body), &calls); err != nil {
+		var single CompatToolCall
+		if json.Unmarshal([]byte(body), &single) != nil || single.Name == "" {
+			return content, nil, true, fmt.Errorf("the %s block is not a valid JSON array: %v", toolCallsOpen, err)
+		}
+		calls = []CompatToolCall{single}
+	}
+	for i := range calls {
+		calls[i].ID = newToolCallID()
+		calls[i].Arguments = normalizeToolArguments(calls[i].Arguments)
+	}
+	return content, calls, true, nil
+}
+
+func (r CompatRequest) findTool(name string) *CompatTool {
+	for i := range r.Tools {
+		if r.Tools[i].Name == name {
+			return &r.Tools[i]
+		}
+	}
+	return nil
+}
+
+func (r CompatRequest) validateToolReply(text string) error {
+	_, calls, found, err := parseToolCalls(text)
+	if err != nil {
+		return err
+	}
+	if !found || len(calls) == 0 {
+		switch {
+		case r.ToolChoice.Name != "":
+			return fmt.Errorf("you must call the tool %q", r.ToolChoice.Name)
+		case r.ToolChoice.Mode == "required":
+			return fmt.Errorf("you must call at least one tool")
+		}
+		return nil
+	}
+	if !r.ParallelToolCalls && len(calls) > 1 {
+		return fmt.Errorf("only one tool call is allowed per reply, got %d", len(calls))
+	}
+	var problems []string
+	for i, call := range calls {
+		path := fmt.Sprintf("tool_calls[%d]", i)
+		tool := r.findTool(call.Name)
+		if tool == nil {
+			problems = append(problems, fmt.Sprintf("%s: unknown tool %q", path, call.Name))
+			continue
+		}
+		if r.ToolChoice.Name != "" && call.Name != r.ToolChoice.Name {
+			problems = append(problems, fmt.Sprintf("%s: only the tool %q may be called", path, r.ToolChoice.Name))
+		}
+		var arguments any
+		if err := json.Unmarshal(call.Arguments, &arguments); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.arguments: invalid JSON: %v", path, err))
+			continue
+		}
+		if _, ok := arguments.(map[string]any); !ok {
+			problems = append(problems, fmt.Sprintf("%s.arguments: must be a JSON object", path))
+			continue
+		}
+		problems = append(problems, validateJSONSchema(tool.Parameters, arguments, path+".arguments")...)
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func openAIToolChoice(raw json.RawMessage) (CompatToolChoice, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return CompatToolChoice{Mode: "auto"}, nil
+	}
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "auto", "none", "required":
+			return CompatToolChoice{Mode: mode}, nil
+		}
+		return CompatToolChoice{}, fmt.Errorf("invalid tool_choice %q", mode)
+	}
+	var named struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil || named.Function.Name == "" {
+		return CompatToolChoice{}, fmt.Errorf("invalid tool_choice")
+	}
+	return CompatToolChoice{Mode: "required", Name: named.Function.Name}, nil
+}
+
+func openAIToolCalls(calls []CompatToolCall, indexed bool) []OpenAIToolCall {
+	result := make([]OpenAIToolCall, 0, len(calls))
+	for i, call := range calls {
+		toolCall := OpenAIToolCall{
+			ID:   "call_" + call.ID,
+			Type: "function",
+			Function: OpenAIFunctionCall{
+				Name:      call.Name,
+				Arguments: string(call.Arguments),
+			},
+		}
+		if indexed {
+			index := i
+			toolCall.Index = &index
+		}
+		result = append(result, toolCall)
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"claude-server/fakeclaude"
+)
+
+var weatherTool = []map[string]any{{
+	"type": "function",
+	"function": map[string]any{
+		"name": "get_weather",
+		"parameters": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"city": map[string]any{"type": "string"}},
+			"required":   []string{"city"},
+		},
+	},
+}}
+
+func TestChatCompletionToolCalls(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(fakeclaude.TextReply("Let me check.\n<tool_calls>\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]\n</tool_calls>"))
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-tools", chatRequest("Weather in Paris?", map[string]any{
+		"tools": weatherTool,
+	}), http.StatusOK, &resp)
+	got := resp.Choices[0]
+	if got.FinishReason != "tool_calls" || len(got.Message.ToolCalls) != 1 {
+		t.Fatalf("got %+v, want one tool call", got)
+	}
+	call := got.Message.ToolCalls[0].Function
+	if call.Name != "get_weather" || call.Arguments != `{"city": "Paris"}` {
+		t.Fatalf("got call %s(%s), want get_weather({\"city\": \"Paris\"})", call.Name, call.Arguments)
+	}
+	if got.Message.Content != "Let me check." {
+		t.Fatalf("got content %q, want the text before the tool calls", got.Message.Content)
+	}
+	if requests := env.fake.Requests(fakeclaude.RouteCompletion); len(requests) != 1 || !strings.Contains(requests[0].Body, "get_weather") {
+		t.Fatalf("tool definitions were not sent upstream")
+	}
+}
+
+func TestChatCompletionRepairsToolCall(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(
+		fakeclaude.TextReply("<tool_calls>\n[{\"name\": \"get_weather\", \"arguments\": {}}]\n</tool_calls>"),
+		fakeclaude.TextReply("<tool_calls>\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Oslo\"}}]\n</tool_calls>"),
+	)
+	var resp OpenAIResponse
+	env.doJSON(http.MethodPost, "/v1/chat/completions", "device-tools-repair", chatRequest("Weather?", map[string]any{
+		"tools":       weatherTool,
+		"tool_choice": "required",
+	}), http.StatusOK, &resp)
+	got := resp.Choices[0]
+	if len(got.Message.ToolCalls) != 1 || got.Message.ToolCalls[0].Function.Arguments != `{"city": "Oslo"}` {
+		t.Fatalf("got %+v, want the repaired tool call", got.Message.ToolCalls)
+	}
+	requests := env.fake.Requests(fakeclaude.RouteCompletion)
+	if len(requests) != 2 {
+		t.Fatalf("got %d upstream completions, want 2", len(requests))
+	}
+	if prompt := completionPrompt(t, requests[1]); !strings.Contains(prompt, "city: is required") {
+		t.Fatalf("repair prompt does not explain the problem: %q", prompt)
+	}
+}
+
+func TestChatCompletionStreamRepairDoesNotLeakFirstAttempt(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.fake.QueueCompletion(
+		fakeclaude.TextReply("I would rather not call anything."),
+		fakeclaude.TextReply("Checking.\n<tool_calls>\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Rome\"}}]\n</tool_calls>"),
+	)
+	resp := env.do(http.MethodPost, "/v1/chat/completions", "device-stream-repair", chatRequest("Weather?", map[string]any{
+		"stream":      true,
+		"tools":       weatherTool,
+		"tool_choice": "required",
+	}))
+	var content strings.Builder
+	var calls []OpenAIToolCall
+	for _, data := range readSSEData(t, resp.Body) {
+		var chunk OpenAIChunk
+		if json.Unmarshal([]byte(data), &chunk) == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			calls = append(calls, chunk.Choices[0].Delta.ToolCalls...)
+		}
+	}
+	if content.String() != "Checking." {
+		t.Fatalf("streamed %q, want only the repaired content", content.String())
+	}
+	if len(calls) != 1 || calls[0].Function.Name != "get_weather" {
+		t.Fatalf("got tool calls %+v, want get_weather", calls)
+	}
+}
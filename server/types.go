@@ -1,38 +1,150 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type OpenAIChatRequest struct {
-	Model    string          `json:"model"`
-	Messages []OpenAIMessage `json:"messages"`
-	Stream   bool            `json:"stream"`
+	Model               string               `json:"model"`
+	Messages            []OpenAIMessage      `json:"messages"`
+	Stream              bool                 `json:"stream"`
+	Temperature         *float64             `json:"temperature,omitempty"`
+	TopP                *float64             `json:"top_p,omitempty"`
+	MaxTokens           int                  `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int                  `json:"max_completion_tokens,omitempty"`
+	Stop                StopSequences        `json:"stop,omitempty"`
+	User                string               `json:"user,omitempty"`
+	StreamOptions       *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	Tools               []OpenAITool         `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage      `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool                `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat      *ResponseFormat      `json:"response_format,omitempty"`
+}
+
+type ResponseFormat struct {
+	Type       string              `json:"type"`
+	JSONSchema *ResponseJSONSchema `json:"json_schema,omitempty"`
+}
+
+type ResponseJSONSchema struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      bool            `json:"strict,omitempty"`
+}
+
+type OpenAITool struct {
+	Type     string         `json:"type"`
+	Function OpenAIFunction `json:"function"`
+}
+
+type OpenAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	Index    *int               `json:"index,omitempty"`
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type OpenAIChunk struct {
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []OpenAIChunkChoice `json:"choices"`
+	Usage   *OpenAIUsage        `json:"usage,omitempty"`
+}
+
+type OpenAIChunkChoice struct {
+	Index        int         `json:"index"`
+	Delta        OpenAIDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type OpenAIDelta struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
 }
 
 type DialogueRequest struct {
-	ConversationID string        `json:"conversation_id,omitempty"`
-	Request        string        `json:"request"`
-	Model          string        `json:"model,omitempty"`
-	Style          string        `json:"style,omitempty"`
-	Files          []RequestFile `json:"files,omitempty"`
-	KeepAlive      bool          `json:"keep_alive,omitempty"`
+	ConversationID    string          `json:"conversation_id,omitempty"`
+	Request           string          `json:"request"`
+	Model             string          `json:"model,omitempty"`
+	Style             string          `json:"style,omitempty"`
+	Files             []RequestFile   `json:"files,omitempty"`
+	KeepAlive         bool            `json:"keep_alive,omitempty"`
+	ReplaceDialogueID int             `json:"replace_dialogue_id,omitempty"`
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
 }
 
 type DialogueResponse struct {
-	ConversationID string `json:"conversation_id"`
-	Response       string `json:"response"`
+	ConversationID string          `json:"conversation_id"`
+	DialogueID     int             `json:"dialogue_id,omitempty"`
+	ParentID       *int            `json:"parent_id,omitempty"`
+	Response       string          `json:"response"`
+	Thinking       string          `json:"thinking,omitempty"`
+	ContentBlocks  []*ContentBlock `json:"content_blocks,omitempty"`
+	Citations      []Citation      `json:"citations,omitempty"`
+	StopReason     string          `json:"stop_reason,omitempty"`
+	MessageLimit   *MessageLimit   `json:"message_limit,omitempty"`
+	Cancelled      bool            `json:"cancelled,omitempty"`
 }
 
 type DialogueStreamRequest struct {
-	ConversationID string        `json:"conversation_id,omitempty"`
-	Request        string        `json:"request"`
-	Model          string        `json:"model,omitempty"`
-	Style          string        `json:"style,omitempty"`
-	Files          []RequestFile `json:"files,omitempty"`
+	ConversationID    string          `json:"conversation_id,omitempty"`
+	Request           string          `json:"request"`
+	Model             string          `json:"model,omitempty"`
+	Style             string          `json:"style,omitempty"`
+	Files             []RequestFile   `json:"files,omitempty"`
+	Timezone          string          `json:"timezone,omitempty"`
+	Locale            string          `json:"locale,omitempty"`
+	ReplaceDialogueID int             `json:"replace_dialogue_id,omitempty"`
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+	Name       string           `json:"name,omitempty"`
+	Files      []RequestFile    `json:"-"`
+}
+
+type OpenAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL json.RawMessage `json:"image_url,omitempty"`
+	File     *OpenAIFilePart `json:"file,omitempty"`
+	Filename string          `json:"filename,omitempty"`
+	FileData string          `json:"file_data,omitempty"`
+}
+
+type OpenAIImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type OpenAIFilePart struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
 }
 
 type RequestFile struct {
@@ -63,21 +175,145 @@ type OpenAIUsage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []ModelConfig `json:"data"`
+}
+
+type AnthropicContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Source    *AnthropicSource `json:"source,omitempty"`
+	Title     string           `json:"title,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   AnthropicContent `json:"content,omitempty"`
+	IsError   bool             `json:"is_error,omitempty"`
+}
+
+type AnthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type AnthropicToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type AnthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content AnthropicContent `json:"content"`
+}
+
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type AnthropicMessagesRequest struct {
+	Model         string               `json:"model"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	System        AnthropicContent     `json:"system,omitempty"`
+	MaxTokens     int                  `json:"max_tokens"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream"`
+	Metadata      *AnthropicMetadata   `json:"metadata,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	TopK          *int                 `json:"top_k,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	OutputFormat  *ResponseFormat      `json:"output_format,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicMessagesResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
 type OllamaTagsResponse struct {
 	Models []OllamaModel `json:"models"`
 }
 
 type OllamaModel struct {
-	Name       string `json:"name"`
-	ModifiedAt string `json:"modified_at"`
-	Size       int64  `json:"size"`
-	Digest     string `json:"digest"`
+	Name         string             `json:"name"`
+	Model        string             `json:"model"`
+	DisplayName  string             `json:"display_name,omitempty"`
+	ModifiedAt   string             `json:"modified_at"`
+	Size         int64              `json:"size"`
+	Digest       string             `json:"digest"`
+	Details      OllamaModelDetails `json:"details"`
+	Capabilities []string           `json:"capabilities,omitempty"`
+}
+
+type OllamaModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
 }
 
 type OllamaChatRequest struct {
 	Model    string          `json:"model"`
 	Messages []OpenAIMessage `json:"messages"`
-	Stream   bool            `json:"stream"`
+	Stream   *bool           `json:"stream,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+type OllamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	System  string          `json:"system,omitempty"`
+	Images  []string        `json:"images,omitempty"`
+	Stream  *bool           `json:"stream,omitempty"`
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options *OllamaOptions  `json:"options,omitempty"`
+}
+
+type OllamaShowRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name"`
+}
+
+type OllamaStats struct {
+	DoneReason         string `json:"done_reason,omitempty"`
+	TotalDuration      int64  `json:"total_duration,omitempty"`
+	LoadDuration       int64  `json:"load_duration,omitempty"`
+	PromptEvalCount    int    `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int    `json:"eval_count,omitempty"`
+	EvalDuration       int64  `json:"eval_duration,omitempty"`
 }
 
 type OllamaChatResponse struct {
@@ -85,6 +321,26 @@ type OllamaChatResponse struct {
 	CreatedAt string        `json:"created_at"`
 	Message   OpenAIMessage `json:"message"`
 	Done      bool          `json:"done"`
+	OllamaStats
+}
+
+type OllamaGenerateResponse struct {
+	Model     string `json:"model"`
+	CreatedAt string `json:"created_at"`
+	Response  string `json:"response"`
+	Done      bool   `json:"done"`
+	Context   []int  `json:"context,omitempty"`
+	OllamaStats
+}
+
+type OllamaShowResponse struct {
+	Modelfile    string             `json:"modelfile"`
+	Parameters   string             `json:"parameters"`
+	Template     string             `json:"template"`
+	Details      OllamaModelDetails `json:"details"`
+	ModelInfo    map[string]any     `json:"model_info"`
+	Capabilities []string           `json:"capabilities"`
+	ModifiedAt   string             `json:"modified_at"`
 }
 
 type RecordsRequest struct {
@@ -99,8 +355,8 @@ type StatsResponse struct {
 	TPM             float64 `json:"tpm"`
 	RPM             float64 `json:"rpm"`
 	RPD             float64 `json:"rpd"`
-	ServiceShutdown bool    `json:"service_shutdown"`
-	ShutdownReason  string  `json:"shutdown_reason,omitempty"`
+	TokensEstimated bool    `json:"tokens_estimated"`
+	ServiceState
 }
 
 type MCPRemoteServer struct {